@@ -0,0 +1,185 @@
+package aadrm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+
+	"github.com/bored-engineer/rms/seal"
+
+	"github.com/pkg/errors"
+)
+
+// Resource is the Azure AD resource of aadrm in the PublicCloud
+const Resource = "https://aadrm.com"
+
+// DefaultAuthority is the Azure AD authority used if unspecified
+const DefaultAuthority = "https://login.microsoftonline.com/common"
+
+// DefaultClientID is a public (native) client which is allowed to access aadrm (Microsoft Office)
+const DefaultClientID = "d3590ed6-52b3-4102-aeff-aad2292ab01c"
+
+// OAuth2Config creates an *oauth2.Config for a public client of aadrm on the v2 endpoints of authority,
+// the resource is picked from the cloud of authority
+func OAuth2Config(authority string, clientID string) *oauth2.Config {
+	authority = strings.TrimSuffix(authority, "/")
+	return &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       authority + "/oauth2/v2.0/authorize",
+			TokenURL:      authority + "/oauth2/v2.0/token",
+			DeviceAuthURL: authority + "/oauth2/v2.0/devicecode",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+		Scopes: []string{cloudForAuthority(authority).Resource + "/user_impersonation", "offline_access"},
+	}
+}
+
+// DeviceLogin performs the device authorization grant, prompt must show the user the code and URL
+func DeviceLogin(ctx context.Context, cfg *oauth2.Config, prompt func(*oauth2.DeviceAuthResponse) error) (*oauth2.Token, error) {
+	da, err := cfg.DeviceAuth(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start device authorization")
+	}
+	if err := prompt(da); err != nil {
+		return nil, err
+	}
+	tok, err := cfg.DeviceAccessToken(ctx, da)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to complete device authorization")
+	}
+	return tok, nil
+}
+
+// TokenIdentity returns the tenant and user (or service principal) the access token was issued to, as
+// "<tid>/<oid>", the claims are read without verifying the token (aadrm does that)
+func TokenIdentity(tok *oauth2.Token) (string, error) {
+	parts := strings.Split(tok.AccessToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("access_token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to base64 decode access_token claims")
+	}
+	var claims struct {
+		TenantID string `json:"tid"`
+		ObjectID string `json:"oid"`
+		Subject  string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.Wrap(err, "failed to decode access_token claims")
+	}
+	user := claims.ObjectID
+	if user == "" {
+		user = claims.Subject
+	}
+	if user == "" {
+		return "", errors.New("access_token does not identify a user")
+	}
+	return claims.TenantID + "/" + user, nil
+}
+
+// TokenStore persists an *oauth2.Token (and with it the refresh token) between runs
+type TokenStore interface {
+	// Load returns the stored token, or nil if there is none
+	Load() (*oauth2.Token, error)
+	// Save stores the token
+	Save(tok *oauth2.Token) error
+}
+
+// FileTokenStore is a TokenStore which keeps the token in a single file
+type FileTokenStore struct {
+	Path string
+	// Sealer encrypts the token at rest if set
+	Sealer *seal.Sealer
+}
+
+// Load implements TokenStore
+func (s *FileTokenStore) Load() (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read token file")
+	}
+	if seal.IsSealed(b) {
+		if s.Sealer == nil {
+			return nil, errors.New("token file is sealed but no Sealer was provided")
+		}
+		if b, err = s.Sealer.Open(b); err != nil {
+			return nil, errors.Wrap(err, "failed to open token file")
+		}
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token file")
+	}
+	return &tok, nil
+}
+
+// Save implements TokenStore, the file is only readable by the owner
+func (s *FileTokenStore) Save(tok *oauth2.Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode token")
+	}
+	if s.Sealer != nil {
+		if b, err = s.Sealer.Seal(b); err != nil {
+			return errors.Wrap(err, "failed to seal token")
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create token directory")
+	}
+	if err := ioutil.WriteFile(s.Path, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write token file")
+	}
+	return nil
+}
+
+// storedTokenSource saves the token whenever it is refreshed
+type storedTokenSource struct {
+	mu    sync.Mutex
+	src   oauth2.TokenSource
+	store TokenStore
+	last  string
+}
+
+func (s *storedTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken != s.last {
+		if err := s.store.Save(tok); err != nil {
+			return nil, err
+		}
+		s.last = tok.AccessToken
+	}
+	return tok, nil
+}
+
+// NewStoredTokenSource creates a refreshing oauth2.TokenSource from the token in store
+func NewStoredTokenSource(ctx context.Context, cfg *oauth2.Config, store TokenStore) (oauth2.TokenSource, error) {
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	} else if tok == nil {
+		return nil, errors.New("no token stored, login first")
+	}
+	return &storedTokenSource{
+		src:   cfg.TokenSource(ctx, tok),
+		store: store,
+		last:  tok.AccessToken,
+	}, nil
+}
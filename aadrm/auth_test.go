@@ -0,0 +1,258 @@
+package aadrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/bored-engineer/rms/seal"
+)
+
+// tokenServer is an Azure AD authority, polls for the device code go through responses in order
+type tokenServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// responses are the error codes (or "" for a token) returned when polling
+	responses []string
+	// polls are the times the device code was polled
+	polls []time.Time
+	// refreshes are the refresh tokens which were redeemed
+	refreshes []string
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	s := &tokenServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.Form.Get("client_id") != "client" {
+			t.Errorf("unexpected client_id %q", r.Form.Get("client_id"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/common/oauth2/v2.0/devicecode":
+			if scope := r.Form.Get("scope"); scope != Resource+"/user_impersonation offline_access" {
+				t.Errorf("unexpected scope %q", scope)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"device_code":      "device",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": "https://microsoft.com/devicelogin",
+				"expires_in":       60,
+				"interval":         1,
+			})
+		case "/common/oauth2/v2.0/token":
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			var code string
+			switch grant := r.Form.Get("grant_type"); grant {
+			case "urn:ietf:params:oauth:grant-type:device_code":
+				if device := r.Form.Get("device_code"); device != "device" {
+					t.Errorf("unexpected device_code %q", device)
+				}
+				s.polls = append(s.polls, time.Now())
+				if len(s.responses) > 0 {
+					code, s.responses = s.responses[0], s.responses[1:]
+				}
+			case "refresh_token":
+				s.refreshes = append(s.refreshes, r.Form.Get("refresh_token"))
+			default:
+				t.Errorf("unexpected grant_type %q", grant)
+			}
+			if code != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": code})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "access-" + r.Form.Get("grant_type"),
+				"refresh_token": "refresh-2",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) config() *oauth2.Config {
+	return OAuth2Config(s.URL+"/common/", "client")
+}
+
+func TestDeviceLogin(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		responses []string
+		// wantErr is the error code DeviceLogin fails with, "" on success
+		wantErr string
+		// wantGap is the minimum time between the last two polls, slow_down adds 5 seconds to the interval
+		wantGap time.Duration
+	}{
+		{"success", nil, "", 0},
+		{"pending", []string{"authorization_pending", "authorization_pending"}, "", 0},
+		{"slow down", []string{"slow_down"}, "", 5 * time.Second},
+		{"expired", []string{"authorization_pending", "expired_token"}, "expired_token", 0},
+		{"denied", []string{"access_denied"}, "access_denied", 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := newTokenServer(t)
+			s.responses = tc.responses
+
+			var prompted *oauth2.DeviceAuthResponse
+			tok, err := DeviceLogin(context.Background(), s.config(), func(da *oauth2.DeviceAuthResponse) error {
+				prompted = da
+				return nil
+			})
+			if prompted == nil || prompted.UserCode != "ABCD-EFGH" || prompted.VerificationURI != "https://microsoft.com/devicelogin" {
+				t.Errorf("unexpected prompt %+v", prompted)
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tok.AccessToken != "access-urn:ietf:params:oauth:grant-type:device_code" || tok.RefreshToken != "refresh-2" {
+					t.Errorf("unexpected %+v", tok)
+				}
+			} else {
+				var re *oauth2.RetrieveError
+				if !errors.As(err, &re) || re.ErrorCode != tc.wantErr {
+					t.Errorf("got %v, want %s", err, tc.wantErr)
+				}
+			}
+
+			s.mu.Lock()
+			polls := s.polls
+			s.mu.Unlock()
+			if len(polls) != len(tc.responses)+1 && tc.wantErr == "" {
+				t.Errorf("polled %d times, want %d", len(polls), len(tc.responses)+1)
+			}
+			if n := len(polls); n >= 2 && polls[n-1].Sub(polls[n-2]) < tc.wantGap {
+				t.Errorf("polled again after %s, want at least %s", polls[n-1].Sub(polls[n-2]), tc.wantGap)
+			}
+		})
+	}
+}
+
+func TestDeviceLoginPromptError(t *testing.T) {
+	s := newTokenServer(t)
+	promptErr := errors.New("no terminal")
+	_, err := DeviceLogin(context.Background(), s.config(), func(*oauth2.DeviceAuthResponse) error { return promptErr })
+	if !errors.Is(err, promptErr) {
+		t.Errorf("got %v, want the prompt error", err)
+	}
+	if len(s.polls) != 0 {
+		t.Error("polled for a token after the prompt failed")
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	sealer, err := seal.NewKeySealer(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, tc := range []struct {
+		name   string
+		sealer *seal.Sealer
+	}{{"plain", nil}, {"sealed", sealer}} {
+		store := &FileTokenStore{Path: filepath.Join(dir, tc.name, "rms", "token.json"), Sealer: tc.sealer}
+		if got, err := store.Load(); got != nil || err != nil {
+			t.Errorf("%s: Load before Save returned %v, %v", tc.name, got, err)
+		}
+		if err := store.Save(tok); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(store.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s: token file mode is %o, want 600", tc.name, perm)
+		}
+		if info, err := os.Stat(filepath.Dir(store.Path)); err != nil || info.Mode().Perm() != 0700 {
+			t.Errorf("%s: token directory mode is %v (%v), want 700", tc.name, info.Mode().Perm(), err)
+		}
+		b, _ := ioutil.ReadFile(store.Path)
+		if sealed := seal.IsSealed(b); sealed != (tc.sealer != nil) || (sealed && strings.Contains(string(b), "refresh")) {
+			t.Errorf("%s: token file is %q", tc.name, b)
+		}
+		got, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.AccessToken != tok.AccessToken || got.RefreshToken != tok.RefreshToken || !got.Expiry.Equal(tok.Expiry) {
+			t.Errorf("%s: loaded %+v", tc.name, got)
+		}
+	}
+	if _, err := (&FileTokenStore{Path: filepath.Join(dir, "sealed", "rms", "token.json")}).Load(); err == nil {
+		t.Error("expected an error loading a sealed token without a Sealer")
+	}
+}
+
+func TestStoredTokenSource(t *testing.T) {
+	s := newTokenServer(t)
+	store := &FileTokenStore{Path: filepath.Join(t.TempDir(), "token.json")}
+	if _, err := NewStoredTokenSource(context.Background(), s.config(), store); err == nil {
+		t.Error("expected an error without a stored token")
+	}
+
+	// A valid token is used as-is
+	valid := &oauth2.Token{AccessToken: "valid", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)}
+	if err := store.Save(valid); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewStoredTokenSource(context.Background(), s.config(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := src.Token(); err != nil || tok.AccessToken != "valid" {
+		t.Errorf("got %v (%v), want the stored token", tok, err)
+	}
+	if len(s.refreshes) != 0 {
+		t.Errorf("refreshed a valid token %q", s.refreshes)
+	}
+
+	// An expired token is refreshed once and the new token is stored
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Hour)}
+	if err := store.Save(expired); err != nil {
+		t.Fatal(err)
+	}
+	src, err = NewStoredTokenSource(context.Background(), s.config(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if tok, err := src.Token(); err != nil || tok.AccessToken != "access-refresh_token" {
+			t.Fatalf("got %v (%v), want the refreshed token", tok, err)
+		}
+	}
+	if len(s.refreshes) != 1 || s.refreshes[0] != "refresh-1" {
+		t.Errorf("refreshed with %q, want refresh-1 once", s.refreshes)
+	}
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AccessToken != "access-refresh_token" || stored.RefreshToken != "refresh-2" {
+		t.Errorf("stored %+v", stored)
+	}
+}
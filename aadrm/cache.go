@@ -0,0 +1,201 @@
+package aadrm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bored-engineer/rms/seal"
+	"github.com/bored-engineer/rms/xrml"
+
+	"github.com/pkg/errors"
+)
+
+// LicenseCache stores raw end-user licenses, keys combine the user's identity and the content ID
+type LicenseCache interface {
+	// Get returns the cached entry for key, or nil if there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores the entry for key
+	Put(ctx context.Context, key string, entry []byte) error
+	// Delete removes the entry for key (if any)
+	Delete(ctx context.Context, key string) error
+}
+
+// cacheEntry is what the Client stores in a LicenseCache
+type cacheEntry struct {
+	// Fetched is when the license was issued to us, used by IntervalTimeInDays
+	Fetched time.Time       `json:"Fetched"`
+	License json.RawMessage `json:"License"`
+}
+
+// parseTime parses the timestamps used by EndUserLicense
+func parseTime(v *string) (time.Time, bool) {
+	if v == nil || *v == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04:05.9999999"} {
+		if t, err := time.Parse(layout, *v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Cacheable reports if the license may be stored for offline use
+func (l *EndUserLicense) Cacheable() bool {
+	return l.OnlineAccessOnly == nil || !*l.OnlineAccessOnly
+}
+
+// ValidAt reports if a license fetched at fetched can still be used at now
+func (l *EndUserLicense) ValidAt(fetched time.Time, now time.Time) bool {
+	for _, v := range []*string{l.LicenseValidUntil, l.ContentValidUntil} {
+		if t, ok := parseTime(v); ok && !now.Before(t) {
+			return false
+		}
+	}
+	if l.Policy != nil {
+		if t, ok := parseTime(l.Policy.LicenseValidUntil); ok && !now.Before(t) {
+			return false
+		}
+		if l.Policy.IntervalTimeInDays != nil && *l.Policy.IntervalTimeInDays > 0 {
+			if now.Sub(fetched) >= time.Duration(*l.Policy.IntervalTimeInDays)*24*time.Hour {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LicenseKey returns the content ID of a publishing license, or a hash of it if it can't be parsed
+func LicenseKey(license []byte) string {
+	if pl, err := xrml.Parse(license); err == nil {
+		if id := pl.ContentID(); id != "" {
+			return id
+		}
+	}
+	sum := sha256.Sum256(license)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// cacheKey returns the LicenseCache key of a publishing license, licenses are only ever
+// shared by requests made as the same CacheIdentity
+func (c *Client) cacheKey(license []byte) (string, error) {
+	if c.CacheIdentity == "" {
+		return "", errors.New("Cache requires a CacheIdentity so licenses are never shared between users")
+	}
+	return c.CacheIdentity + "/" + LicenseKey(license), nil
+}
+
+// cachedLicense returns a usable license from the cache, expired entries are deleted
+func (c *Client) cachedLicense(ctx context.Context, key string) (*EndUserLicense, []byte, error) {
+	b, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read license cache")
+	} else if b == nil {
+		return nil, nil, nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, nil, c.Cache.Delete(ctx, key)
+	}
+	var l EndUserLicense
+	if err := json.Unmarshal(entry.License, &l); err != nil || !l.Cacheable() || !l.ValidAt(entry.Fetched, time.Now()) {
+		return nil, nil, c.Cache.Delete(ctx, key)
+	}
+	return &l, entry.License, nil
+}
+
+// cacheLicense stores a freshly fetched license if it is allowed to be
+func (c *Client) cacheLicense(ctx context.Context, key string, l *EndUserLicense, raw []byte) error {
+	if !l.Cacheable() {
+		return nil
+	}
+	b, err := json.Marshal(&cacheEntry{Fetched: time.Now().UTC(), License: raw})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cache entry")
+	}
+	if err := c.Cache.Put(ctx, key, b); err != nil {
+		return errors.Wrap(err, "failed to write license cache")
+	}
+	return nil
+}
+
+// FileCache is a LicenseCache storing each entry as a file in a directory
+type FileCache struct {
+	Dir string
+	// Sealer encrypts the entries at rest if set
+	Sealer *seal.Sealer
+}
+
+// NewFileCache creates a *FileCache in dir
+func NewFileCache(dir string) *FileCache {
+	return &FileCache{Dir: dir}
+}
+
+// path returns the file for key, keys aren't safe file names so they are hashed
+func (fc *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(key)))
+	return filepath.Join(fc.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get implements LicenseCache
+func (fc *FileCache) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fc.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read cache file")
+	}
+	if seal.IsSealed(b) {
+		if fc.Sealer == nil {
+			return nil, errors.New("cache file is sealed but no Sealer was provided")
+		}
+		if b, err = fc.Sealer.Open(b); err != nil {
+			return nil, errors.Wrap(err, "failed to open cache file")
+		}
+	}
+	return b, nil
+}
+
+// Put implements LicenseCache, the file is written atomically (and sealed if Sealer is set)
+func (fc *FileCache) Put(ctx context.Context, key string, entry []byte) error {
+	if fc.Sealer != nil {
+		var err error
+		if entry, err = fc.Sealer.Seal(entry); err != nil {
+			return errors.Wrap(err, "failed to seal cache entry")
+		}
+	}
+	if err := os.MkdirAll(fc.Dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create cache directory")
+	}
+	tmp, err := ioutil.TempFile(fc.Dir, ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create cache file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(entry); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write cache file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close cache file")
+	}
+	if err := os.Rename(tmp.Name(), fc.path(key)); err != nil {
+		return errors.Wrap(err, "failed to rename cache file")
+	}
+	return nil
+}
+
+// Delete implements LicenseCache
+func (fc *FileCache) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(fc.path(key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete cache file")
+	}
+	return nil
+}
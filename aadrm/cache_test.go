@@ -0,0 +1,211 @@
+package aadrm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestValidAt(t *testing.T) {
+	fetched := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	str := func(s string) *string { return &s }
+	days := func(n int) *int { return &n }
+	for _, tc := range []struct {
+		name    string
+		license EndUserLicense
+		now     time.Time
+		valid   bool
+	}{
+		{"no limits", EndUserLicense{}, fetched.AddDate(10, 0, 0), true},
+		{"LicenseValidUntil before", EndUserLicense{LicenseValidUntil: str("2020-03-02T00:00:00Z")}, fetched.Add(23 * time.Hour), true},
+		{"LicenseValidUntil after", EndUserLicense{LicenseValidUntil: str("2020-03-02T00:00:00Z")}, fetched.Add(24 * time.Hour), false},
+		{"LicenseValidUntil without zone", EndUserLicense{LicenseValidUntil: str("2020-03-02T00:00:00")}, fetched.Add(25 * time.Hour), false},
+		{"ContentValidUntil before", EndUserLicense{ContentValidUntil: str("2020-04-01T00:00:00.1234567")}, fetched.AddDate(0, 0, 30), true},
+		{"ContentValidUntil after", EndUserLicense{ContentValidUntil: str("2020-04-01T00:00:00Z")}, fetched.AddDate(0, 1, 1), false},
+		{"unparseable", EndUserLicense{ContentValidUntil: str("never")}, fetched.AddDate(10, 0, 0), true},
+		{"Policy.LicenseValidUntil after", EndUserLicense{Policy: &Policy{LicenseValidUntil: str("2020-03-01T12:00:00Z")}}, fetched.Add(12 * time.Hour), false},
+		{"IntervalTimeInDays before", EndUserLicense{Policy: &Policy{IntervalTimeInDays: days(7)}}, fetched.AddDate(0, 0, 6), true},
+		{"IntervalTimeInDays after", EndUserLicense{Policy: &Policy{IntervalTimeInDays: days(7)}}, fetched.AddDate(0, 0, 7), false},
+		{"IntervalTimeInDays zero", EndUserLicense{Policy: &Policy{IntervalTimeInDays: days(0)}}, fetched.AddDate(1, 0, 0), true},
+	} {
+		if got := tc.license.ValidAt(fetched, tc.now); got != tc.valid {
+			t.Errorf("%s: ValidAt is %v, want %v", tc.name, got, tc.valid)
+		}
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		online    *bool
+		cacheable bool
+	}{{nil, true}, {&no, true}, {&yes, false}} {
+		if got := (&EndUserLicense{OnlineAccessOnly: tc.online}).Cacheable(); got != tc.cacheable {
+			t.Errorf("OnlineAccessOnly %v: Cacheable is %v", tc.online, got)
+		}
+	}
+}
+
+// testLicense is a publishing license with a content ID
+var testLicense = []byte(`<?xml version="1.0"?><XrML><BODY type="Microsoft Rights Label"><WORK><OBJECT><ID type="MS-GUID">{content-1}</ID></OBJECT></WORK></BODY></XrML>`)
+
+// licenseServer issues the same end-user license for every request and counts them
+func licenseServer(t *testing.T, license string) (*Client, *int32) {
+	var calls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(license))
+	}))
+	t.Cleanup(s.Close)
+	c := NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL)
+	c.RetryPolicy = nil
+	return c, &calls
+}
+
+const grantedLicense = `{"AccessStatus":"AccessGranted","ContentId":"{content-1}","Key":{"Value":"AAECAwQFBgcICQoLDA0ODw==","Algorithm":"AES","CipherMode":"MICROSOFT.ECB","Size":16}}`
+
+func TestClientCache(t *testing.T) {
+	ctx := context.Background()
+	c, calls := licenseServer(t, grantedLicense)
+	cache := NewFileCache(t.TempDir())
+	c.Cache = cache
+
+	// The cache is never used without knowing who the licenses belong to
+	if _, _, _, err := c.GetEndUserLicense(ctx, testLicense); err == nil {
+		t.Fatal("expected an error without a CacheIdentity")
+	}
+
+	c.CacheIdentity = "tenant/alice"
+	for i := 0; i < 2; i++ {
+		l, _, _, err := c.GetEndUserLicense(ctx, testLicense)
+		if err != nil {
+			t.Fatal(err)
+		} else if l.Key == nil {
+			t.Fatal("license is missing the Key")
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("got %d calls for the same user, want 1", n)
+	}
+
+	// Another user sharing the cache directory must be licensed by the service
+	c.CacheIdentity = "tenant/bob"
+	if _, _, _, err := c.GetEndUserLicense(ctx, testLicense); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("got %d calls after switching user, want 2", n)
+	}
+}
+
+func TestClientCacheOnlineAccessOnly(t *testing.T) {
+	ctx := context.Background()
+	c, calls := licenseServer(t, `{"AccessStatus":"AccessGranted","OnlineAccessOnly":true}`)
+	c.Cache = NewFileCache(t.TempDir())
+	c.CacheIdentity = "tenant/alice"
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := c.GetEndUserLicense(ctx, testLicense); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("got %d calls, want 2", n)
+	}
+}
+
+// TestClientCacheFailure makes sure a broken cache never loses a freshly fetched license
+func TestClientCacheFailure(t *testing.T) {
+	c, _ := licenseServer(t, grantedLicense)
+	// A file where the cache directory should be makes every Put fail
+	dir := filepath.Join(t.TempDir(), "cache")
+	if err := ioutil.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.Cache = NewFileCache(dir)
+	c.CacheIdentity = "tenant/alice"
+	l, _, _, err := c.GetEndUserLicense(context.Background(), testLicense)
+	if err != nil {
+		t.Fatal(err)
+	} else if l == nil || l.Key == nil {
+		t.Fatal("license was not returned")
+	}
+}
+
+func TestClientCacheExpired(t *testing.T) {
+	ctx := context.Background()
+	c, calls := licenseServer(t, grantedLicense)
+	cache := NewFileCache(t.TempDir())
+	c.Cache = cache
+	c.CacheIdentity = "tenant/alice"
+	key, err := c.cacheKey(testLicense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := json.Marshal(&cacheEntry{
+		Fetched: time.Now().Add(-time.Hour),
+		License: json.RawMessage(`{"AccessStatus":"AccessGranted","LicenseValidUntil":"2000-01-01T00:00:00Z"}`),
+	})
+	if err := cache.Put(ctx, key, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := c.GetEndUserLicense(ctx, testLicense); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("expired entry was served from the cache")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	ctx := context.Background()
+	fc := NewFileCache(t.TempDir())
+	if b, err := fc.Get(ctx, "missing"); err != nil || b != nil {
+		t.Fatalf("got %q %v for a missing entry", b, err)
+	}
+	if err := fc.Put(ctx, "tenant/{A}", []byte("entry")); err != nil {
+		t.Fatal(err)
+	}
+	// Keys are case-insensitive like content IDs
+	if b, err := fc.Get(ctx, "TENANT/{a}"); err != nil || string(b) != "entry" {
+		t.Fatalf("got %q %v", b, err)
+	}
+	if err := fc.Delete(ctx, "tenant/{A}"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fc.path("tenant/{A}")); !os.IsNotExist(err) {
+		t.Errorf("entry still exists: %v", err)
+	}
+}
+
+func TestTokenIdentity(t *testing.T) {
+	jwt := func(claims string) *oauth2.Token {
+		return &oauth2.Token{AccessToken: "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"}
+	}
+	for _, tc := range []struct {
+		tok  *oauth2.Token
+		want string
+	}{
+		{jwt(`{"tid":"t1","oid":"o1","sub":"s1"}`), "t1/o1"},
+		{jwt(`{"tid":"t1","sub":"s1"}`), "t1/s1"},
+		{jwt(`{"tid":"t1"}`), ""},
+		{&oauth2.Token{AccessToken: "opaque"}, ""},
+	} {
+		got, err := TokenIdentity(tc.tok)
+		if got != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("%s: got %q %v", tc.tok.AccessToken, got, err)
+		}
+	}
+}
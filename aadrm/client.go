@@ -31,6 +31,18 @@ type Client struct {
 	RMSPlatformID string
 	// Becomes User-Agent header if set
 	UserAgent string
+	// RetryPolicy for transient failures, requests are not retried if nil (as NewClient leaves it), see DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+	// Cache is consulted by GetEndUserLicense before calling aadrm if set
+	Cache LicenseCache
+	// CacheIdentity identifies the user licenses are issued to (ex: from TokenIdentity), it is required by
+	// Cache and is part of every key so a shared cache never hands one user's license to another
+	CacheIdentity string
+	// Cloud the token of the client was issued for, discovered URLs outside of it are never called (PublicCloud if nil)
+	Cloud *Cloud
+	// DiscoverLicenses makes GetEndUserLicense call the licensing URL from the publishing license instead of BaseURL,
+	// BaseURL is still used if the license does not name an aadrm URL in the Cloud
+	DiscoverLicenses bool
 }
 
 // NewRequest creates a request with the expected aadrm headers
@@ -62,3 +74,15 @@ func (c *Client) NewRequest(ctx context.Context, method string, path string, bod
 func NewClient(c *http.Client) *Client {
 	return &Client{c: c, BaseURL: DefaultBaseURL}
 }
+
+// NewCloudClient creates a client for cloud, the caller must supply a client with auth for the cloud's Resource
+func NewCloudClient(c *http.Client, cloud *Cloud) (*Client, error) {
+	baseURL, err := url.Parse(cloud.BaseURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse BaseURL of cloud %s", cloud.Name)
+	}
+	client := NewClient(c)
+	client.BaseURL = baseURL
+	client.Cloud = cloud
+	return client, nil
+}
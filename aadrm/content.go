@@ -0,0 +1,72 @@
+package aadrm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// contentHeaderSize is the size of the little-endian plaintext size which prefixes DRMContent
+const contentHeaderSize = 8
+
+// ContentSizeError is returned when the plaintext size declared by DRMContent does not fit the ciphertext,
+// either because it is larger or because it would leave more than a block of padding
+type ContentSizeError struct {
+	// Declared is the plaintext size from the DRMContent header
+	Declared uint64
+	// Ciphertext is the size of the ciphertext following the header
+	Ciphertext int64
+}
+
+func (e *ContentSizeError) Error() string {
+	if e.Declared > uint64(e.Ciphertext) {
+		return fmt.Sprintf("DRMContent declares %d bytes of plaintext but only has %d bytes of ciphertext", e.Declared, e.Ciphertext)
+	}
+	return fmt.Sprintf("DRMContent declares %d bytes of plaintext but has %d bytes of ciphertext, it is truncated or corrupt", e.Declared, e.Ciphertext)
+}
+
+// NewContentDecrypter creates a *Decrypter for a DRMContent stream of size bytes read from r
+func (k *Key) NewContentDecrypter(r io.ReaderAt, size int64) (*Decrypter, error) {
+	if size < contentHeaderSize {
+		return nil, errors.Errorf("DRMContent is too short (%d bytes) for the header", size)
+	}
+	var header [contentHeaderSize]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, errors.Wrap(err, "failed to read DRMContent header")
+	}
+	declared := binary.LittleEndian.Uint64(header[:])
+	length := size - contentHeaderSize
+	// The ciphertext is only ever padded up to the next block
+	if declared > uint64(length) || int64(declared) <= length-aes.BlockSize {
+		return nil, &ContentSizeError{Declared: declared, Ciphertext: length}
+	}
+	return k.newDecrypter(r, contentHeaderSize, length, int64(declared))
+}
+
+// DecryptContent decrypts a DRMContent stream, truncating the plaintext to the declared size
+func (k *Key) DecryptContent(content []byte) ([]byte, error) {
+	d, err := k.NewContentDecrypter(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, d.Size())
+	if _, err := io.ReadFull(d, plaintext); err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return plaintext, nil
+}
+
+// EncryptContent creates a DRMContent stream, the inverse of DecryptContent
+func (k *Key) EncryptContent(plaintext []byte) ([]byte, error) {
+	ciphertext, err := k.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, contentHeaderSize, contentHeaderSize+len(ciphertext))
+	binary.LittleEndian.PutUint64(content, uint64(len(plaintext)))
+	return append(content, ciphertext...), nil
+}
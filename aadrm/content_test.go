@@ -0,0 +1,85 @@
+package aadrm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testKey creates a Key of size bytes counting up from 0 (the FIPS-197 appendix C keys)
+func testKey(t *testing.T, cipherMode string, size int) *Key {
+	t.Helper()
+	value := make([]byte, size)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	encoded := base64.StdEncoding.EncodeToString(value)
+	algorithm := algorithmAES
+	return &Key{
+		Value:      &encoded,
+		CipherMode: &cipherMode,
+		Algorithm:  &algorithm,
+		Size:       &size,
+	}
+}
+
+// testPlaintext returns n bytes of i%251 so no segment repeats another
+func testPlaintext(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	for _, mode := range []string{"MICROSOFT.ECB", "MICROSOFT.CBC512", "MICROSOFT.CBC4K"} {
+		k := testKey(t, mode, 16)
+		for _, n := range []int{0, 1, 15, 16, 17, 4096, 4104} {
+			plaintext := testPlaintext(n)
+			content, err := k.EncryptContent(plaintext)
+			if err != nil {
+				t.Fatalf("%s %d: EncryptContent: %v", mode, n, err)
+			}
+			got, err := k.DecryptContent(content)
+			if err != nil {
+				t.Fatalf("%s %d: DecryptContent: %v", mode, n, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("%s %d: got %d bytes which do not match", mode, n, len(got))
+			}
+		}
+	}
+}
+
+func TestContentSizeError(t *testing.T) {
+	k := testKey(t, "MICROSOFT.ECB", 16)
+	content, err := k.EncryptContent(testPlaintext(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, declared := range []uint64{49, 1 << 40, 32, 0} {
+		b := append([]byte{}, content...)
+		binary.LittleEndian.PutUint64(b, declared)
+		_, err := k.DecryptContent(b)
+		var sizeErr *ContentSizeError
+		if !errors.As(err, &sizeErr) {
+			t.Errorf("declared %d: got %v, want a *ContentSizeError", declared, err)
+		} else if sizeErr.Declared != declared || sizeErr.Ciphertext != 48 {
+			t.Errorf("declared %d: unexpected %+v", declared, sizeErr)
+		}
+	}
+	// Anything which only leaves padding in the final block is consistent
+	for _, declared := range []uint64{33, 40, 48} {
+		b := append([]byte{}, content...)
+		binary.LittleEndian.PutUint64(b, declared)
+		if _, err := k.DecryptContent(b); err != nil {
+			t.Errorf("declared %d: %v", declared, err)
+		}
+	}
+	if _, err := k.DecryptContent(content[:4]); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
@@ -0,0 +1,164 @@
+package aadrm
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/pkg/errors"
+)
+
+// clientAssertionType is the client_assertion_type of a signed JWT
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// assertionLifetime is how long a client assertion is valid for
+const assertionLifetime = 10 * time.Minute
+
+// clientCredentialsConfig creates the config for a service principal, authority must name the tenant (not common)
+func clientCredentialsConfig(authority string, clientID string) *clientcredentials.Config {
+	return &clientcredentials.Config{
+		ClientID:  clientID,
+		TokenURL:  strings.TrimSuffix(authority, "/") + "/oauth2/v2.0/token",
+		Scopes:    []string{cloudForAuthority(authority).Resource + "/.default"},
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+}
+
+// ClientSecretTokenSource creates an oauth2.TokenSource for a service principal with a client secret
+func ClientSecretTokenSource(ctx context.Context, authority string, clientID string, secret string) oauth2.TokenSource {
+	cfg := clientCredentialsConfig(authority, clientID)
+	cfg.ClientSecret = secret
+	return cfg.TokenSource(ctx)
+}
+
+// certificateTokenSource signs a new client assertion for every token request
+type certificateTokenSource struct {
+	ctx  context.Context
+	cfg  *clientcredentials.Config
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// assertion creates the signed JWT identifying the client
+func (s *certificateTokenSource) assertion() (string, error) {
+	thumbprint := sha1.Sum(s.cert.Raw)
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode JWT header")
+	}
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate UUID")
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": s.cfg.TokenURL,
+		"iss": s.cfg.ClientID,
+		"sub": s.cfg.ClientID,
+		"jti": jti.String(),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode JWT claims")
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign JWT")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s *certificateTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := s.assertion()
+	if err != nil {
+		return nil, err
+	}
+	cfg := *s.cfg
+	cfg.EndpointParams = url.Values{
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	return cfg.Token(s.ctx)
+}
+
+// ClientCertificateTokenSource creates an oauth2.TokenSource for a service principal with a certificate
+func ClientCertificateTokenSource(ctx context.Context, authority string, clientID string, cert *x509.Certificate, key crypto.Signer) (oauth2.TokenSource, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("only RSA keys are supported for client assertions")
+	}
+	return oauth2.ReuseTokenSource(nil, &certificateTokenSource{
+		ctx:  ctx,
+		cfg:  clientCredentialsConfig(authority, clientID),
+		cert: cert,
+		key:  key,
+	}), nil
+}
+
+// ParseCertificate parses a PEM file containing both a certificate and its private key
+func ParseCertificate(b []byte) (*x509.Certificate, crypto.Signer, error) {
+	var cert *x509.Certificate
+	var key crypto.Signer
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert != nil {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to parse certificate")
+			}
+			cert = c
+		case "RSA PRIVATE KEY":
+			k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to parse PKCS1 private key")
+			}
+			key = k
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to parse PKCS8 private key")
+			}
+			signer, ok := k.(crypto.Signer)
+			if !ok {
+				return nil, nil, errors.New("private key cannot sign")
+			}
+			key = signer
+		}
+	}
+	if cert == nil {
+		return nil, nil, errors.New("no CERTIFICATE found")
+	} else if key == nil {
+		return nil, nil, errors.New("no PRIVATE KEY found")
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,227 @@
+package aadrm
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate for a service principal
+func testCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rms test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// credentialsServer is a tenant token endpoint which records the form of every request
+func credentialsServer(t *testing.T) (*httptest.Server, func() []url.Values) {
+	var mu sync.Mutex
+	var forms []url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		forms = append(forms, r.PostForm)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(s.Close)
+	return s, func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return forms
+	}
+}
+
+// checkClientCredentials checks the fields every client credentials request has
+func checkClientCredentials(t *testing.T, form url.Values) {
+	t.Helper()
+	for k, want := range map[string]string{
+		"grant_type": "client_credentials",
+		"client_id":  "client",
+		"scope":      Resource + "/.default",
+	} {
+		if got := form.Get(k); got != want {
+			t.Errorf("%s is %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestClientSecretTokenSource(t *testing.T) {
+	s, forms := credentialsServer(t)
+	tok, err := ClientSecretTokenSource(context.Background(), s.URL+"/tenant/", "client", "s3cret").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" {
+		t.Errorf("unexpected %+v", tok)
+	}
+	if len(forms()) != 1 {
+		t.Fatalf("got %d requests", len(forms()))
+	}
+	form := forms()[0]
+	checkClientCredentials(t, form)
+	if got := form.Get("client_secret"); got != "s3cret" {
+		t.Errorf("client_secret is %q", got)
+	}
+	if _, ok := form["client_assertion"]; ok {
+		t.Error("client_assertion was sent with a secret")
+	}
+}
+
+func TestClientCertificateTokenSource(t *testing.T) {
+	cert, key := testCertificate(t)
+	s, forms := credentialsServer(t)
+	src, err := ClientCertificateTokenSource(context.Background(), s.URL+"/tenant", "client", cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token is reused until it expires
+	for i := 0; i < 2; i++ {
+		if tok, err := src.Token(); err != nil || tok.AccessToken != "access" {
+			t.Fatalf("got %v (%v)", tok, err)
+		}
+	}
+	if len(forms()) != 1 {
+		t.Fatalf("got %d requests, want 1", len(forms()))
+	}
+	form := forms()[0]
+	checkClientCredentials(t, form)
+	if _, ok := form["client_secret"]; ok {
+		t.Error("client_secret was sent with a certificate")
+	}
+	if got := form.Get("client_assertion_type"); got != clientAssertionType {
+		t.Errorf("client_assertion_type is %q", got)
+	}
+
+	parts := strings.Split(form.Get("client_assertion"), ".")
+	if len(parts) != 3 {
+		t.Fatalf("client_assertion is not a JWT: %q", form.Get("client_assertion"))
+	}
+	decode := func(part string, v interface{}) {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var header map[string]string
+	decode(parts[0], &header)
+	thumbprint := sha1.Sum(cert.Raw)
+	if header["alg"] != "RS256" || header["typ"] != "JWT" || header["x5t"] != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+		t.Errorf("unexpected header %v", header)
+	}
+	var claims struct {
+		Audience  string `json:"aud"`
+		Issuer    string `json:"iss"`
+		Subject   string `json:"sub"`
+		ID        string `json:"jti"`
+		NotBefore int64  `json:"nbf"`
+		IssuedAt  int64  `json:"iat"`
+		Expiry    int64  `json:"exp"`
+	}
+	decode(parts[1], &claims)
+	if claims.Audience != s.URL+"/tenant/oauth2/v2.0/token" || claims.Issuer != "client" || claims.Subject != "client" || claims.ID == "" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if now := time.Now().Unix(); claims.IssuedAt > now || claims.IssuedAt < now-60 || claims.NotBefore != claims.IssuedAt {
+		t.Errorf("iat %d and nbf %d are not now (%d)", claims.IssuedAt, claims.NotBefore, now)
+	}
+	if lifetime := time.Duration(claims.Expiry-claims.IssuedAt) * time.Second; lifetime != assertionLifetime {
+		t.Errorf("assertion is valid for %s", lifetime)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("signature does not verify with the certificate: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClientCertificateTokenSource(context.Background(), s.URL+"/tenant", "client", cert, ecKey); err == nil {
+		t.Error("expected an error for an ECDSA key")
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	cert, key := testCertificate(t)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	for name, b := range map[string][]byte{
+		"PKCS #8":   append(append([]byte{}, certPEM...), pkcs8PEM...),
+		"PKCS #1":   append(append([]byte{}, certPEM...), pkcs1PEM...),
+		"key first": append(append([]byte{}, pkcs8PEM...), certPEM...),
+	} {
+		gotCert, gotKey, err := ParseCertificate(b)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !gotCert.Equal(cert) || gotKey.Public().(*rsa.PublicKey).N.Cmp(key.N) != 0 {
+			t.Errorf("%s: parsed the wrong certificate or key", name)
+		}
+	}
+	for name, b := range map[string][]byte{
+		"no key":         certPEM,
+		"no certificate": pkcs8PEM,
+		"bad key":        append(append([]byte{}, certPEM...), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("bad")})...),
+	} {
+		if _, _, err := ParseCertificate(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package aadrm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bored-engineer/rms/xrml"
+
+	"github.com/pkg/errors"
+)
+
+// Cloud is an Azure cloud hosting aadrm, sovereign clouds have their own Azure AD and aadrm endpoints
+type Cloud struct {
+	// Name is used to select the cloud (ex: --cloud)
+	Name string
+	// LoginHost is the Azure AD host of the cloud
+	LoginHost string
+	// Resource is the Azure AD resource of aadrm in the cloud
+	Resource string
+	// BaseURL is the default aadrm endpoint of the cloud
+	BaseURL string
+	// Domain contains every aadrm host of the cloud, discovered URLs outside of it are rejected
+	Domain string
+}
+
+// Authority is the (multi-tenant) Azure AD authority of the cloud
+func (cloud *Cloud) Authority() string {
+	return "https://" + cloud.LoginHost + "/common"
+}
+
+// trusts checks if host is an aadrm host in the cloud
+func (cloud *Cloud) trusts(host string) bool {
+	host = strings.ToLower(host)
+	return host == cloud.Domain || strings.HasSuffix(host, "."+cloud.Domain)
+}
+
+// PublicCloud is the commercial (worldwide) cloud, GCC (moderate) tenants are also served from it
+var PublicCloud = &Cloud{
+	Name:      "public",
+	LoginHost: "login.microsoftonline.com",
+	Resource:  Resource,
+	BaseURL:   "https://api.aadrm.com",
+	Domain:    "aadrm.com",
+}
+
+// Clouds are the known clouds by name
+var Clouds = map[string]*Cloud{
+	"public": PublicCloud,
+	"gcc": {
+		Name:      "gcc",
+		LoginHost: "login.microsoftonline.com",
+		Resource:  Resource,
+		BaseURL:   "https://api.aadrm.com",
+		Domain:    "aadrm.com",
+	},
+	"gcc-high": {
+		Name:      "gcc-high",
+		LoginHost: "login.microsoftonline.us",
+		Resource:  "https://aadrm.us",
+		BaseURL:   "https://api.aadrm.us",
+		Domain:    "aadrm.us",
+	},
+	"dod": {
+		Name:      "dod",
+		LoginHost: "login.microsoftonline.us",
+		Resource:  "https://aadrm.us",
+		BaseURL:   "https://api.aadrm.us",
+		Domain:    "aadrm.us",
+	},
+	"china": {
+		Name:      "china",
+		LoginHost: "login.chinacloudapi.cn",
+		Resource:  "https://aadrm.cn",
+		BaseURL:   "https://api.aadrm.cn",
+		Domain:    "aadrm.cn",
+	},
+}
+
+// cloudForAuthority finds the cloud of an Azure AD authority, PublicCloud if unknown
+func cloudForAuthority(authority string) *Cloud {
+	u, err := url.Parse(authority)
+	if err != nil {
+		return PublicCloud
+	}
+	for _, cloud := range Clouds {
+		if strings.EqualFold(u.Hostname(), cloud.LoginHost) {
+			return cloud
+		}
+	}
+	return PublicCloud
+}
+
+// trustedURL checks that rawURL is https and in the cloud, returning only the scheme and host,
+// tokens are only ever sent to the cloud they were issued for
+func (cloud *Cloud) trustedURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse URL %q", rawURL)
+	}
+	if u.Scheme != "https" {
+		return nil, errors.Errorf("refusing non-https aadrm URL %q", rawURL)
+	}
+	if !cloud.trusts(u.Hostname()) {
+		return nil, errors.Errorf("refusing aadrm URL %q outside of the %s cloud", rawURL, cloud.Name)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// LicenseBaseURL finds the aadrm endpoint in the cloud named by the licensing URLs in a publishing license
+func (cloud *Cloud) LicenseBaseURL(license []byte) (*url.URL, error) {
+	pl, err := xrml.Parse(license)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse publishing license")
+	}
+	var lastErr error
+	for _, rawURL := range []string{pl.ExtranetURL(), pl.IntranetURL()} {
+		if rawURL == "" {
+			continue
+		}
+		u, err := cloud.trustedURL(rawURL)
+		if err == nil {
+			return u, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, errors.New("publishing license does not contain a licensing URL")
+}
+
+// cloud is the Cloud of the client, PublicCloud if not set
+func (c *Client) cloud() *Cloud {
+	if c.Cloud == nil {
+		return PublicCloud
+	}
+	return c.Cloud
+}
+
+// DiscoverFromLicense sets BaseURL from the licensing URLs in a publishing license
+func (c *Client) DiscoverFromLicense(license []byte) error {
+	u, err := c.cloud().LicenseBaseURL(license)
+	if err != nil {
+		return err
+	}
+	c.BaseURL = u
+	return nil
+}
+
+// forLicense returns a copy of c using the endpoint of license if DiscoverLicenses is set,
+// otherwise (or if the license does not name an aadrm URL in the client's Cloud) c is returned
+func (c *Client) forLicense(license []byte) *Client {
+	if !c.DiscoverLicenses {
+		return c
+	}
+	u, err := c.cloud().LicenseBaseURL(license)
+	if err != nil {
+		return c
+	}
+	cc := *c
+	cc.BaseURL = u
+	return &cc
+}
+
+// Service is an aadrm service returned by service discovery
+type Service struct {
+	Name string `json:"Name"`
+	URI  string `json:"Uri"`
+}
+
+// ListServices calls /my/v1/servicediscovery
+func (c *Client) ListServices(ctx context.Context) ([]Service, *http.Response, error) {
+	resp, err := c.do(ctx, "GET", "/my/v1/servicediscovery", nil, "")
+	if err != nil {
+		return nil, resp, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, resp, err
+	}
+	var services []Service
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, resp, errors.Wrap(err, "failed decode JSON")
+	}
+	return services, resp, nil
+}
+
+// Discover calls service discovery and sets BaseURL to the endpoint serving end user licenses for the tenant
+func (c *Client) Discover(ctx context.Context) error {
+	services, _, err := c.ListServices(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to discover services")
+	}
+	for _, service := range services {
+		if !strings.Contains(strings.ToLower(service.Name), "enduserlicense") {
+			continue
+		}
+		u, err := c.cloud().trustedURL(service.URI)
+		if err != nil {
+			return err
+		}
+		c.BaseURL = u
+		return nil
+	}
+	return errors.New("service discovery did not return an end user license service")
+}
@@ -0,0 +1,181 @@
+package aadrm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClouds(t *testing.T) {
+	for name, cloud := range Clouds {
+		if cloud.Name != name {
+			t.Errorf("%s: Name is %s", name, cloud.Name)
+		}
+		u, err := cloud.trustedURL(cloud.BaseURL)
+		if err != nil {
+			t.Errorf("%s: BaseURL is not trusted: %v", name, err)
+		} else if u.String() != cloud.BaseURL {
+			t.Errorf("%s: BaseURL %s is not a bare https URL", name, cloud.BaseURL)
+		}
+		if got := cloudForAuthority(cloud.Authority()); got.LoginHost != cloud.LoginHost || got.Resource != cloud.Resource {
+			t.Errorf("%s: authority %s maps to %s", name, cloud.Authority(), got.Name)
+		}
+		if got := cloudForAuthority("https://" + cloud.LoginHost + "/contoso.onmicrosoft.com"); got.Resource != cloud.Resource {
+			t.Errorf("%s: tenant authority maps to %s", name, got.Name)
+		}
+	}
+	if got := cloudForAuthority("https://login.example.com/common"); got != PublicCloud {
+		t.Errorf("unknown authority maps to %s", got.Name)
+	}
+}
+
+func TestTrustedURL(t *testing.T) {
+	for _, tc := range []struct {
+		cloud  string
+		rawURL string
+		// want is empty if the URL is rejected
+		want string
+	}{
+		{"public", "https://contoso.rms.na.aadrm.com/_wmcs/licensing", "https://contoso.rms.na.aadrm.com"},
+		{"public", " https://API.AADRM.COM ", "https://API.AADRM.COM"},
+		{"public", "https://aadrm.com", "https://aadrm.com"},
+		{"gcc", "https://contoso.rms.aadrm.com/_wmcs/licensing", "https://contoso.rms.aadrm.com"},
+		{"gcc-high", "https://contoso.rms.aadrm.us/_wmcs/licensing", "https://contoso.rms.aadrm.us"},
+		{"dod", "https://api.aadrm.us", "https://api.aadrm.us"},
+		{"china", "https://api.aadrm.cn:443/my", "https://api.aadrm.cn:443"},
+		// Other clouds are not trusted with the token
+		{"public", "https://api.aadrm.us", ""},
+		{"public", "https://api.aadrm.cn", ""},
+		{"gcc-high", "https://api.aadrm.com", ""},
+		{"china", "https://api.aadrm.com", ""},
+		// Neither are lookalikes or plain http
+		{"public", "https://evilaadrm.com", ""},
+		{"public", "https://aadrm.com.evil.example", ""},
+		{"public", "https://evil.example/.aadrm.com", ""},
+		{"public", "http://api.aadrm.com", ""},
+		{"public", "://api.aadrm.com", ""},
+	} {
+		u, err := Clouds[tc.cloud].trustedURL(tc.rawURL)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s %q: expected an error, got %s", tc.cloud, tc.rawURL, u)
+			}
+		} else if err != nil {
+			t.Errorf("%s %q: %v", tc.cloud, tc.rawURL, err)
+		} else if u.String() != tc.want {
+			t.Errorf("%s %q: got %s, want %s", tc.cloud, tc.rawURL, u, tc.want)
+		}
+	}
+}
+
+// licenseWithURL is a publishing license naming licensingURL as the extranet licensing URL
+func licenseWithURL(licensingURL string) []byte {
+	return []byte(`<?xml version="1.0"?><XrML><BODY type="Microsoft Rights Label">` +
+		`<DISTRIBUTIONPOINT><OBJECT type="Extranet-License-Acquisition-URL"><ADDRESS type="URL">` + licensingURL + `/_wmcs/licensing</ADDRESS></OBJECT></DISTRIBUTIONPOINT>` +
+		`<WORK><OBJECT><ID type="MS-GUID">{content-1}</ID></OBJECT></WORK></BODY></XrML>`)
+}
+
+// testCloud trusts every httptest server
+var testCloud = &Cloud{Name: "test", Domain: "127.0.0.1"}
+
+// discoveryServer is an aadrm endpoint which serves service discovery and end user licenses
+func discoveryServer(t *testing.T, services []Service) (*httptest.Server, *int32) {
+	var licenses int32
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/my/v1/servicediscovery":
+			json.NewEncoder(w).Encode(services)
+		case "/my/v2/enduserlicenses":
+			atomic.AddInt32(&licenses, 1)
+			w.Write([]byte(grantedLicense))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s, &licenses
+}
+
+func testDiscoveryClient(s *httptest.Server, cloud *Cloud) *Client {
+	c := NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL)
+	c.RetryPolicy = nil
+	c.Cloud = cloud
+	return c
+}
+
+func TestDiscover(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		services []Service
+		// want is the discovered host, empty if Discover fails
+		want string
+	}{
+		{"trusted", []Service{
+			{Name: "TemplateDistribution", URI: "https://127.0.0.1:1/my/v1/templates"},
+			{Name: "EndUserLicense", URI: "https://127.0.0.1:2/my/v2/enduserlicenses"},
+		}, "127.0.0.1:2"},
+		{"other cloud", []Service{{Name: "EndUserLicense", URI: "https://api.aadrm.us/my/v2/enduserlicenses"}}, ""},
+		{"http", []Service{{Name: "EndUserLicense", URI: "http://127.0.0.1:2"}}, ""},
+		{"missing", []Service{{Name: "TemplateDistribution", URI: "https://127.0.0.1:1"}}, ""},
+	} {
+		s, _ := discoveryServer(t, tc.services)
+		c := testDiscoveryClient(s, testCloud)
+		err := c.Discover(context.Background())
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, BaseURL is %s", tc.name, c.BaseURL)
+			} else if c.BaseURL.String() != s.URL {
+				t.Errorf("%s: BaseURL changed to %s", tc.name, c.BaseURL)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if c.BaseURL.Host != tc.want {
+			t.Errorf("%s: BaseURL is %s, want %s", tc.name, c.BaseURL, tc.want)
+		}
+	}
+
+	// A client without a Cloud only trusts the public cloud
+	s, _ := discoveryServer(t, []Service{{Name: "EndUserLicense", URI: "https://127.0.0.1:2"}})
+	if err := testDiscoveryClient(s, nil).Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "public") {
+		t.Errorf("got %v, want an error for the public cloud", err)
+	}
+}
+
+// TestDiscoverLicenses requests each license from its licensing URL, but only in the client's cloud
+func TestDiscoverLicenses(t *testing.T) {
+	base, baseCalls := discoveryServer(t, nil)
+	named, namedCalls := discoveryServer(t, nil)
+	for _, tc := range []struct {
+		name     string
+		cloud    *Cloud
+		license  []byte
+		discover bool
+		// base and named are the expected requests to each server
+		base, named int32
+	}{
+		{"discovered", testCloud, licenseWithURL(named.URL), true, 0, 1},
+		{"disabled", testCloud, licenseWithURL(named.URL), false, 1, 0},
+		{"public cloud", PublicCloud, licenseWithURL(named.URL), true, 1, 0},
+		{"other cloud", testCloud, licenseWithURL("https://api.aadrm.us"), true, 1, 0},
+		{"no licensing URL", testCloud, testLicense, true, 1, 0},
+	} {
+		atomic.StoreInt32(baseCalls, 0)
+		atomic.StoreInt32(namedCalls, 0)
+		c := testDiscoveryClient(base, tc.cloud)
+		c.DiscoverLicenses = tc.discover
+		if _, _, _, err := c.GetEndUserLicense(context.Background(), tc.license); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if b, n := atomic.LoadInt32(baseCalls), atomic.LoadInt32(namedCalls); b != tc.base || n != tc.named {
+			t.Errorf("%s: %d requests to BaseURL and %d to the licensing URL, want %d and %d", tc.name, b, n, tc.base, tc.named)
+		}
+	}
+}
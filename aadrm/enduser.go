@@ -3,7 +3,6 @@ package aadrm
 import (
 	"bytes"
 	"context"
-	"crypto/aes"
 	"encoding/base64"
 	"encoding/json"
 	"io"
@@ -12,64 +11,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-type Key struct {
-	Value      *string `json:"Value,omitempty"`
-	CipherMode *string `json:"CipherMode,omitempty"`
-	Algorithm  *string `json:"Algorithm,omitempty"`
-	Size       *int    `json:"Size,omitempty"`
-}
-
-// Decrypt data using this key
-func (k *Key) Decrypt(ciphertext []byte) ([]byte, error) {
-	if k == nil {
-		return nil, errors.New("Key is nil")
-	}
-
-	if k.Algorithm == nil {
-		return nil, errors.New("Algorithm is nil")
-	} else if *k.Algorithm != "AES" {
-		return nil, errors.Errorf("Unsupported Algorithm %s", *k.Algorithm)
-	}
-	if k.CipherMode == nil {
-		return nil, errors.New("CipherMode is nil")
-	} else if *k.CipherMode != "MICROSOFT.ECB" {
-		return nil, errors.Errorf("Unsupported CipherMode %s", *k.CipherMode)
-	}
-
-	if k.Value == nil {
-		return nil, errors.New("Value is nil")
-	}
-	value, err := base64.StdEncoding.DecodeString(*k.Value)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to base64 decode Value")
-	}
-
-	cipher, err := aes.NewCipher(value)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create AES cipher")
-	}
-
-	if k.Size == nil {
-		return nil, errors.New("Size is nil")
-	} else if *k.Size != cipher.BlockSize() {
-		return nil, errors.Errorf("Mismatched block size %d and %d", *k.Size, cipher.BlockSize())
-	}
-
-	// TODO: Hacky af, no idea if this is "correct", seems very wrong
-	bs := cipher.BlockSize()
-	ciphertext = ciphertext[len(ciphertext) % bs:]
-
-	// Go "intentionally" never implemented ECB because it's insecure, implement by hand
-	tmp := make([]byte, len(ciphertext))
-	plaintext := tmp[:]
-	for len(ciphertext) > 0 {
-		cipher.Decrypt(tmp, ciphertext)
-		tmp = tmp[bs:]
-		ciphertext = ciphertext[bs:]
-	}
-	return plaintext, nil
-}
-
 type UserRight struct {
 	Users  []string `json:"Users,omitempty"`
 	Rights []string `json:"Rights,omitempty"`
@@ -121,8 +62,25 @@ func DecodeEndUserLicense(r io.Reader) (*EndUserLicense, error) {
 	return &l, nil
 }
 
-// GetEndUserLicense calls /my/v2/enduserlicenses
+// Licensor issues user licenses for publishing licenses, implemented by *Client and *adrms.Client
+type Licensor interface {
+	GetEndUserLicense(ctx context.Context, license []byte) (*EndUserLicense, []byte, *http.Response, error)
+}
+
+// GetEndUserLicense calls /my/v2/enduserlicenses, if the license is served from Cache the *http.Response is nil
 func (c *Client) GetEndUserLicense(ctx context.Context, license []byte) (*EndUserLicense, []byte, *http.Response, error) {
+	var key string
+	if c.Cache != nil {
+		var err error
+		if key, err = c.cacheKey(license); err != nil {
+			return nil, nil, nil, err
+		}
+		// The cache is transparent, if it can't be read the service is asked instead
+		if l, raw, err := c.cachedLicense(ctx, key); err == nil && l != nil {
+			return l, raw, nil, nil
+		}
+	}
+
 	serializedLicense := base64.StdEncoding.EncodeToString(license)
 	reqBytes, err := json.Marshal(&struct {
 		SerializedPublishingLicense string `json:"SerializedPublishingLicense"`
@@ -132,21 +90,38 @@ func (c *Client) GetEndUserLicense(ctx context.Context, license []byte) (*EndUse
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "failed to wrap license")
 	}
-	req, err := c.NewRequest(ctx, "POST", "/my/v2/enduserlicenses", bytes.NewReader(reqBytes))
-	if err != nil {
-		return nil, nil, nil, errors.Wrap(err, "failed to create Request")
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := c.c.Do(req)
+	resp, err := c.forLicense(license).do(ctx, "POST", "/my/v2/enduserlicenses", reqBytes, "application/json")
 	if err != nil {
-		return nil, nil, resp, errors.Wrap(err, "failed to do Request")
+		return nil, nil, resp, err
 	}
 	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, nil, resp, err
+	}
 	var buf bytes.Buffer
 	r := io.TeeReader(resp.Body, &buf)
 	l, err := DecodeEndUserLicense(r)
 	if err != nil {
 		return nil, buf.Bytes(), resp, errors.Wrap(err, "failed to decode EndUserLicense")
 	}
+	// A successful response can still deny access
+	if (l.AccessStatus != nil && *l.AccessStatus != AccessGranted) || (l.ErrorMessage != nil && *l.ErrorMessage != "") {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			RequestID:  requestID(resp),
+			Body:       buf.Bytes(),
+		}
+		if l.AccessStatus != nil {
+			apiErr.Code = *l.AccessStatus
+		}
+		if l.ErrorMessage != nil {
+			apiErr.Message = *l.ErrorMessage
+		}
+		return nil, buf.Bytes(), resp, apiErr
+	}
+	if c.Cache != nil {
+		// Failing to cache the license must not lose it, the next request will just fetch it again
+		c.cacheLicense(ctx, key, l, buf.Bytes())
+	}
 	return l, buf.Bytes(), resp, nil
 }
@@ -0,0 +1,116 @@
+package aadrm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sentinel errors which an *APIError matches with errors.Is
+var (
+	ErrAccessDenied   = errors.New("access denied")
+	ErrLicenseExpired = errors.New("license expired")
+	ErrUnauthorized   = errors.New("authentication failed")
+)
+
+// Values of EndUserLicense.AccessStatus
+const (
+	AccessGranted = "AccessGranted"
+	AccessDenied  = "AccessDenied"
+)
+
+// maxErrorBody limits how much of an error response is read
+const maxErrorBody = 64 * 1024
+
+// APIError is returned when aadrm responds with an error (or denies access)
+type APIError struct {
+	// StatusCode of the HTTP response
+	StatusCode int
+	// RequestID is the X-MS-RMS-Request-Id of the request
+	RequestID string
+	// Code is the service error code (or AccessStatus)
+	Code string
+	// Message is the service error message
+	Message string
+	// Body is the raw response body
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("aadrm returned status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += fmt.Sprintf(" (%s)", e.Code)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" [request %s]", e.RequestID)
+	}
+	return msg
+}
+
+// Is matches ErrAccessDenied, ErrLicenseExpired and ErrUnauthorized
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrAccessDenied:
+		return e.StatusCode == http.StatusForbidden || strings.EqualFold(e.Code, AccessDenied)
+	case ErrLicenseExpired:
+		return strings.Contains(strings.ToLower(e.Code), "expired")
+	}
+	return false
+}
+
+// requestID returns the request ID of resp, preferring the one echoed by the service
+func requestID(resp *http.Response) string {
+	if id := resp.Header.Get("X-MS-RMS-Request-Id"); id != "" {
+		return id
+	}
+	if resp.Request != nil {
+		return resp.Request.Header.Get("X-MS-RMS-Request-Id")
+	}
+	return ""
+}
+
+// checkResponse returns an *APIError if resp is not successful
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  requestID(resp),
+		Body:       body,
+	}
+	// The error body isn't documented, accept the common shapes
+	var details struct {
+		Code         string `json:"Code"`
+		ErrorCode    string `json:"ErrorCode"`
+		Message      string `json:"Message"`
+		ErrorMessage string `json:"ErrorMessage"`
+		AccessStatus string `json:"AccessStatus"`
+	}
+	if err := json.Unmarshal(body, &details); err == nil {
+		for _, code := range []string{details.Code, details.ErrorCode, details.AccessStatus} {
+			if code != "" {
+				apiErr.Code = code
+				break
+			}
+		}
+		apiErr.Message = details.Message
+		if apiErr.Message == "" {
+			apiErr.Message = details.ErrorMessage
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(http.StatusText(resp.StatusCode))
+	}
+	return apiErr
+}
@@ -0,0 +1,133 @@
+package aadrm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// errorServer responds to every request with status and body, echoing requestID if it is set
+func errorServer(t *testing.T, status int, requestID string, body string) (*Client, *string) {
+	var sent string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("X-MS-RMS-Request-Id")
+		if requestID != "" {
+			w.Header().Set("X-MS-RMS-Request-Id", requestID)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	c := NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL)
+	c.RetryPolicy = nil
+	return c, &sent
+}
+
+func TestAPIError(t *testing.T) {
+	sentinels := []error{ErrAccessDenied, ErrLicenseExpired, ErrUnauthorized}
+	for _, tc := range []struct {
+		name      string
+		status    int
+		requestID string
+		body      string
+		code      string
+		message   string
+		// sentinel is the only sentinel error which matches, if any
+		sentinel error
+	}{
+		{"JSON", 400, "echoed", `{"Code":"InvalidPublishingLicense","Message":"The license is malformed"}`, "InvalidPublishingLicense", "The license is malformed", nil},
+		{"JSON ErrorCode", 400, "", `{"ErrorCode":"BadRequest","ErrorMessage":"Missing license"}`, "BadRequest", "Missing license", nil},
+		{"not JSON", 500, "", "<html>Server Error</html>", "", "Internal Server Error", nil},
+		{"empty", 404, "", "", "", "Not Found", nil},
+		{"unauthorized", 401, "", `{"Message":"Token expired"}`, "", "Token expired", ErrUnauthorized},
+		{"forbidden", 403, "", "Forbidden", "", "Forbidden", ErrAccessDenied},
+		{"AccessStatus", 400, "", `{"AccessStatus":"AccessDenied"}`, AccessDenied, "", ErrAccessDenied},
+		{"expired", 400, "", `{"Code":"PublishingLicenseExpired"}`, "PublishingLicenseExpired", "", ErrLicenseExpired},
+	} {
+		c, sent := errorServer(t, tc.status, tc.requestID, tc.body)
+		_, _, err := c.ListTemplates(context.Background())
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: got %v, want an *APIError", tc.name, err)
+			continue
+		}
+		wantID := tc.requestID
+		if wantID == "" {
+			wantID = *sent
+		}
+		if apiErr.StatusCode != tc.status || apiErr.Code != tc.code || apiErr.Message != tc.message || apiErr.RequestID != wantID || string(apiErr.Body) != tc.body {
+			t.Errorf("%s: got %+v", tc.name, apiErr)
+		}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == tc.sentinel) {
+				t.Errorf("%s: errors.Is(%v) is %v", tc.name, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestAPIErrorString(t *testing.T) {
+	for _, tc := range []struct {
+		err  *APIError
+		want string
+	}{
+		{&APIError{StatusCode: 500}, "aadrm returned status 500"},
+		{&APIError{StatusCode: 403, Code: "AccessDenied", Message: "No rights", RequestID: "1234"}, "aadrm returned status 403 (AccessDenied): No rights [request 1234]"},
+	} {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestClientAPIErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		status   int
+		body     string
+		call     func(c *Client) error
+		sentinel error
+	}{
+		{"GetEndUserLicense forbidden", 403, "", func(c *Client) error {
+			_, _, _, err := c.GetEndUserLicense(ctx, testLicense)
+			return err
+		}, ErrAccessDenied},
+		// A successful response can still deny access
+		{"GetEndUserLicense AccessDenied", 200, `{"AccessStatus":"AccessDenied","ErrorMessage":"No rights"}`, func(c *Client) error {
+			_, _, _, err := c.GetEndUserLicense(ctx, testLicense)
+			return err
+		}, ErrAccessDenied},
+		{"CreatePublishingLicense unauthorized", 401, "", func(c *Client) error {
+			_, _, err := c.CreatePublishingLicense(ctx, &PublishingLicenseRequest{TemplateID: new(string)})
+			return err
+		}, ErrUnauthorized},
+		{"CreatePublishingLicense ErrorMessage", 200, `{"ErrorMessage":"Template not found"}`, func(c *Client) error {
+			_, _, err := c.CreatePublishingLicense(ctx, &PublishingLicenseRequest{TemplateID: new(string)})
+			return err
+		}, nil},
+		{"ListServices", 503, "", func(c *Client) error {
+			_, _, err := c.ListServices(ctx)
+			return err
+		}, nil},
+	} {
+		c, _ := errorServer(t, tc.status, "", tc.body)
+		err := tc.call(c)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: got %v, want an *APIError", tc.name, err)
+			continue
+		}
+		if apiErr.StatusCode != tc.status {
+			t.Errorf("%s: StatusCode is %d", tc.name, apiErr.StatusCode)
+		}
+		if tc.sentinel != nil && !errors.Is(err, tc.sentinel) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.sentinel)
+		}
+	}
+}
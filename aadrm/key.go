@@ -0,0 +1,279 @@
+package aadrm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// algorithmAES is the only supported Algorithm of a Key
+const algorithmAES = "AES"
+
+type Key struct {
+	Value      *string `json:"Value,omitempty"`
+	CipherMode *string `json:"CipherMode,omitempty"`
+	Algorithm  *string `json:"Algorithm,omitempty"`
+	Size       *int    `json:"Size,omitempty"`
+}
+
+// cipherModes maps each supported CipherMode to the size of its CBC segments, ECB has none
+var cipherModes = map[string]int64{
+	"MICROSOFT.ECB":    0,
+	"MICROSOFT.CBC4K":  4096,
+	"MICROSOFT.CBC512": 512,
+}
+
+// block validates the key and creates the underlying block cipher and the CBC segment size
+func (k *Key) block() (cipher.Block, int64, error) {
+	if k == nil {
+		return nil, 0, errors.New("Key is nil")
+	}
+
+	if k.Algorithm == nil {
+		return nil, 0, errors.New("Algorithm is nil")
+	} else if *k.Algorithm != algorithmAES {
+		return nil, 0, errors.Errorf("Unsupported Algorithm %s", *k.Algorithm)
+	}
+	if k.CipherMode == nil {
+		return nil, 0, errors.New("CipherMode is nil")
+	}
+	segment, ok := cipherModes[strings.ToUpper(*k.CipherMode)]
+	if !ok {
+		return nil, 0, errors.Errorf("Unsupported CipherMode %s", *k.CipherMode)
+	}
+
+	value, err := k.Bytes()
+	if err != nil {
+		return nil, 0, err
+	}
+	block, err := aes.NewCipher(value)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to create AES cipher")
+	}
+	return block, segment, nil
+}
+
+// Bytes returns the raw key (ex: for formats which derive their own keys from it)
+func (k *Key) Bytes() ([]byte, error) {
+	if k == nil {
+		return nil, errors.New("Key is nil")
+	}
+	if k.Value == nil {
+		return nil, errors.New("Value is nil")
+	}
+	value, err := base64.StdEncoding.DecodeString(*k.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to base64 decode Value")
+	}
+
+	// Both 128 and 256 bit keys are issued
+	if k.Size == nil {
+		return nil, errors.New("Size is nil")
+	} else if *k.Size != len(value) {
+		return nil, errors.Errorf("Mismatched key size %d and %d", *k.Size, len(value))
+	}
+	return value, nil
+}
+
+// Decrypter decrypts ciphertext block-by-block (or segment-by-segment for CBC) as it is read
+type Decrypter struct {
+	block cipher.Block
+	// segment is the size of each independently chained CBC segment, 0 for ECB
+	segment int64
+	// underlying ciphertext
+	r io.ReaderAt
+	// base is the offset of the first ciphertext block in r
+	base int64
+	// length of the ciphertext
+	length int64
+	// size of the plaintext, the ciphertext may be padded beyond it
+	size int64
+	// off is the current offset for Read and Seek
+	off int64
+}
+
+// newDecrypter creates a *Decrypter for the block aligned ciphertext at [base, base+length) of r
+func (k *Key) newDecrypter(r io.ReaderAt, base int64, length int64, size int64) (*Decrypter, error) {
+	block, segment, err := k.block()
+	if err != nil {
+		return nil, err
+	}
+	if bs := int64(block.BlockSize()); length%bs != 0 {
+		return nil, errors.Errorf("ciphertext size %d is not a multiple of the block size %d", length, bs)
+	}
+	return &Decrypter{
+		block:   block,
+		segment: segment,
+		r:       r,
+		base:    base,
+		length:  length,
+		size:    size,
+	}, nil
+}
+
+// NewDecrypter creates a *Decrypter for size bytes of block aligned ciphertext read from r
+func (k *Key) NewDecrypter(r io.ReaderAt, size int64) (*Decrypter, error) {
+	return k.newDecrypter(r, 0, size, size)
+}
+
+// segmentIV derives the IV of a CBC segment by encrypting the little-endian byte offset it starts at
+func segmentIV(block cipher.Block, offset int64) []byte {
+	iv := make([]byte, block.BlockSize())
+	binary.LittleEndian.PutUint64(iv, uint64(offset))
+	block.Encrypt(iv, iv)
+	return iv
+}
+
+// Size returns the size of the plaintext
+func (d *Decrypter) Size() int64 {
+	return d.size
+}
+
+// ReadAt decrypts len(p) bytes of plaintext starting at off
+func (d *Decrypter) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > d.size {
+		end = d.size
+	}
+
+	// Read in every block (or segment) which overlaps [off, end)
+	unit := int64(d.block.BlockSize())
+	if d.segment > 0 {
+		unit = d.segment
+	}
+	start := off / unit * unit
+	stop := (end + unit - 1) / unit * unit
+	if stop > d.length {
+		stop = d.length
+	}
+	buf := make([]byte, stop-start)
+	if n, err := d.r.ReadAt(buf, d.base+start); n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, errors.Wrap(err, "failed to read ciphertext")
+	}
+
+	if d.segment > 0 {
+		// Each segment is chained separately so it can be decrypted on its own
+		for b, offset := buf, start; len(b) > 0; offset += d.segment {
+			n := int64(len(b))
+			if n > d.segment {
+				n = d.segment
+			}
+			cipher.NewCBCDecrypter(d.block, segmentIV(d.block, offset)).CryptBlocks(b[:n], b[:n])
+			b = b[n:]
+		}
+	} else {
+		// Go "intentionally" never implemented ECB because it's insecure, implement by hand
+		for b := buf; len(b) > 0; b = b[unit:] {
+			d.block.Decrypt(b, b)
+		}
+	}
+
+	n := copy(p, buf[off-start:end-start])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read decrypts the next len(p) bytes of plaintext
+func (d *Decrypter) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.off)
+	d.off += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (d *Decrypter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.off
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.off = offset
+	return offset, nil
+}
+
+// Decrypt data using this key
+func (k *Key) Decrypt(ciphertext []byte) ([]byte, error) {
+	d, err := k.NewDecrypter(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, d.Size())
+	if _, err := io.ReadFull(d, plaintext); err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return plaintext, nil
+}
+
+// GenerateKey creates a random AES key of size bytes (16 or 32) for cipherMode (ex: MICROSOFT.CBC4K)
+func GenerateKey(cipherMode string, size int) (*Key, error) {
+	if _, ok := cipherModes[strings.ToUpper(cipherMode)]; !ok {
+		return nil, errors.Errorf("Unsupported CipherMode %s", cipherMode)
+	}
+	if size != 16 && size != 32 {
+		return nil, errors.Errorf("Unsupported key size %d", size)
+	}
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return nil, errors.Wrap(err, "failed to generate key")
+	}
+	encoded := base64.StdEncoding.EncodeToString(value)
+	algorithm := algorithmAES
+	return &Key{
+		Value:      &encoded,
+		CipherMode: &cipherMode,
+		Algorithm:  &algorithm,
+		Size:       &size,
+	}, nil
+}
+
+// Encrypt data using this key, the plaintext is padded with zeros to the block size
+func (k *Key) Encrypt(plaintext []byte) ([]byte, error) {
+	block, segment, err := k.block()
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	ciphertext := make([]byte, (len(plaintext)+bs-1)/bs*bs)
+	copy(ciphertext, plaintext)
+
+	if segment > 0 {
+		for b, offset := ciphertext, int64(0); len(b) > 0; offset += segment {
+			n := int64(len(b))
+			if n > segment {
+				n = segment
+			}
+			cipher.NewCBCEncrypter(block, segmentIV(block, offset)).CryptBlocks(b[:n], b[:n])
+			b = b[n:]
+		}
+	} else {
+		for b := ciphertext; len(b) > 0; b = b[bs:] {
+			block.Encrypt(b, b)
+		}
+	}
+	return ciphertext, nil
+}
@@ -0,0 +1,150 @@
+package aadrm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestFIPS197(t *testing.T) {
+	plaintext := mustHex("00112233445566778899aabbccddeeff")
+	for size, want := range map[int]string{
+		16: "69c4e0d86a7b0430d8cdb78070b4c55a",
+		32: "8ea2b7ca516745bfeafc49904b496089",
+	} {
+		k := testKey(t, "MICROSOFT.ECB", size)
+		ciphertext, err := k.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("AES-%d: Encrypt: %v", size*8, err)
+		}
+		if got := hex.EncodeToString(ciphertext); got != want {
+			t.Errorf("AES-%d: got %s, want %s", size*8, got, want)
+		}
+	}
+}
+
+// knownAnswers are ciphertexts of testPlaintext(length) under testKey generated with the openssl CLI
+// by testdata/gen.sh, so they do not depend on this package
+var knownAnswers = []struct {
+	cipherMode string
+	size       int
+	length     int
+	file       string
+}{
+	{"MICROSOFT.ECB", 16, 1040, "ecb-128.bin"},
+	{"MICROSOFT.CBC512", 16, 1056, "cbc512-128.bin"},
+	{"MICROSOFT.CBC4K", 16, 8224, "cbc4k-128.bin"},
+	{"MICROSOFT.ECB", 32, 1040, "ecb-256.bin"},
+	{"MICROSOFT.CBC512", 32, 1056, "cbc512-256.bin"},
+	{"MICROSOFT.CBC4K", 32, 8224, "cbc4k-256.bin"},
+}
+
+func readKnownAnswer(t *testing.T, file string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKnownAnswers(t *testing.T) {
+	for _, tc := range knownAnswers {
+		k := testKey(t, tc.cipherMode, tc.size)
+		plaintext := testPlaintext(tc.length)
+		want := readKnownAnswer(t, tc.file)
+
+		decrypted, err := k.Decrypt(want)
+		if err != nil {
+			t.Fatalf("%s: Decrypt: %v", tc.file, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%s: Decrypt does not match the plaintext", tc.file)
+		}
+
+		ciphertext, err := k.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("%s: Encrypt: %v", tc.file, err)
+		}
+		if !bytes.Equal(ciphertext, want) {
+			t.Errorf("%s: Encrypt does not match the known answer", tc.file)
+		}
+	}
+}
+
+// TestSegmentIV checks the IVs printed by testdata/gen.sh
+func TestSegmentIV(t *testing.T) {
+	for _, tc := range []struct {
+		size   int
+		offset int64
+		iv     string
+	}{
+		{16, 0, "c6a13b37878f5b826f4f8162a1c8d879"},
+		{16, 512, "6fa7bcfefed6668273a24904694d7264"},
+		{16, 4096, "ff5d987e268f87a0afe65d004452e7b7"},
+		{16, 8192, "d741776a294e36b187a1009330e984ff"},
+		{32, 0, "f29000b62a499fd0a9f39a6add2e7780"},
+		{32, 1024, "6b0413e1dcc2a1aeb75afccbc5edfe27"},
+		{32, 4096, "15aa0a393a8a6099a58759e05e5ea4bd"},
+	} {
+		block, _, err := testKey(t, "MICROSOFT.CBC4K", tc.size).block()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(segmentIV(block, tc.offset)); got != tc.iv {
+			t.Errorf("AES-%d at %d: got %s, want %s", tc.size*8, tc.offset, got, tc.iv)
+		}
+	}
+}
+
+// TestDecrypterReadAt decrypts ranges which start and end inside of segments
+func TestDecrypterReadAt(t *testing.T) {
+	for _, tc := range knownAnswers {
+		k := testKey(t, tc.cipherMode, tc.size)
+		plaintext := testPlaintext(tc.length)
+		ciphertext := readKnownAnswer(t, tc.file)
+		d, err := k.NewDecrypter(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, off := range []int{0, 1, 15, 16, 511, 512, 513, 4095, 4096, 4100, tc.length - 17} {
+			if off >= tc.length {
+				continue
+			}
+			p := make([]byte, 33)
+			n, err := d.ReadAt(p, int64(off))
+			if err != nil && err != io.EOF {
+				t.Fatalf("%s: ReadAt(%d): %v", tc.cipherMode, off, err)
+			}
+			if !bytes.Equal(p[:n], plaintext[off:off+n]) {
+				t.Errorf("%s: ReadAt(%d) does not match", tc.cipherMode, off)
+			}
+		}
+	}
+}
+
+func TestKeyErrors(t *testing.T) {
+	if _, err := testKey(t, "MICROSOFT.CTR", 16).Encrypt(nil); err == nil {
+		t.Error("expected an error for an unsupported CipherMode")
+	}
+	k := testKey(t, "MICROSOFT.ECB", 16)
+	size := 32
+	k.Size = &size
+	if _, err := k.Decrypt(make([]byte, 16)); err == nil {
+		t.Error("expected an error for a mismatched Size")
+	}
+	if _, err := testKey(t, "MICROSOFT.ECB", 16).Decrypt(make([]byte, 17)); err == nil {
+		t.Error("expected an error for ciphertext which is not block aligned")
+	}
+}
@@ -0,0 +1,81 @@
+package aadrm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Descriptor is the (localized) name and description shown for protected content
+type Descriptor struct {
+	Name        *string `json:"Name,omitempty"`
+	Description *string `json:"Description,omitempty"`
+	Language    *string `json:"Language,omitempty"`
+}
+
+// PublishingLicenseRequest protects content with either a TemplateID (see ListTemplates) or an ad-hoc Policy
+type PublishingLicenseRequest struct {
+	TemplateID            *string                    `json:"TemplateId,omitempty"`
+	Policy                *Policy                    `json:"Policy,omitempty"`
+	Descriptors           []Descriptor               `json:"Descriptors,omitempty"`
+	Key                   *Key                       `json:"Key,omitempty"`
+	Referrer              *string                    `json:"Referrer,omitempty"`
+	ContentValidUntil     *string                    `json:"ContentValidUntil,omitempty"`
+	SignedApplicationData map[string]json.RawMessage `json:"SignedApplicationData,omitempty"`
+}
+
+// PublishingLicense is the response to a PublishingLicenseRequest
+type PublishingLicense struct {
+	SerializedPublishingLicense *string `json:"SerializedPublishingLicense,omitempty"`
+	ContentID                   *string `json:"ContentId,omitempty"`
+	Owner                       *string `json:"Owner,omitempty"`
+	// Key is only set if the service generated the content key instead of using the requested one
+	Key          *Key    `json:"Key,omitempty"`
+	ErrorMessage *string `json:"ErrorMessage,omitempty"`
+}
+
+// License decodes the XrML publishing license
+func (pl *PublishingLicense) License() ([]byte, error) {
+	if pl.SerializedPublishingLicense == nil {
+		return nil, errors.New("SerializedPublishingLicense is nil")
+	}
+	license, err := base64.StdEncoding.DecodeString(*pl.SerializedPublishingLicense)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to base64 decode SerializedPublishingLicense")
+	}
+	return license, nil
+}
+
+// CreatePublishingLicense calls /my/v2/publishinglicenses
+func (c *Client) CreatePublishingLicense(ctx context.Context, req *PublishingLicenseRequest) (*PublishingLicense, *http.Response, error) {
+	if (req.TemplateID == nil) == (req.Policy == nil) {
+		return nil, nil, errors.New("exactly one of TemplateID and Policy must be set")
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode request")
+	}
+	resp, err := c.do(ctx, "POST", "/my/v2/publishinglicenses", reqBytes, "application/json")
+	if err != nil {
+		return nil, resp, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, resp, err
+	}
+	var pl PublishingLicense
+	if err := json.NewDecoder(resp.Body).Decode(&pl); err != nil {
+		return nil, resp, errors.Wrap(err, "failed decode JSON")
+	}
+	if pl.ErrorMessage != nil && *pl.ErrorMessage != "" {
+		return nil, resp, &APIError{
+			StatusCode: resp.StatusCode,
+			RequestID:  requestID(resp),
+			Message:    *pl.ErrorMessage,
+		}
+	}
+	return &pl, resp, nil
+}
@@ -0,0 +1,134 @@
+package aadrm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how requests which fail transiently are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 1 (or less) disables retries
+	MaxAttempts int
+	// MinBackoff is the backoff before the first retry, it doubles for each retry
+	MinBackoff time.Duration
+	// MaxBackoff caps the exponential backoff and any Retry-After the server asks for
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is what the rms command uses, a Client only retries if its RetryPolicy is set
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+}
+
+// retryable reports if a response status is worth retrying
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header (either seconds or a HTTP date)
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before retry number attempt (starting at 1) with full jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// do sends a request (retrying according to RetryPolicy), body is replayed for each attempt
+func (c *Client) do(ctx context.Context, method string, path string, body []byte, contentType string) (*http.Response, error) {
+	policy := c.RetryPolicy
+	if policy == nil {
+		policy = &RetryPolicy{MaxAttempts: 1}
+	}
+	for attempt := 1; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		// NewRequest generates a new X-MS-RMS-Request-Id for every attempt
+		req, err := c.NewRequest(ctx, method, path, r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Request")
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.c.Do(req)
+		last := attempt >= policy.MaxAttempts
+		if err != nil {
+			if last || ctx.Err() != nil {
+				return resp, errors.Wrap(err, "failed to do Request")
+			}
+		} else if !retryable(resp.StatusCode) || last {
+			return resp, nil
+		}
+
+		delay := policy.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				// Never let the server stall the caller for longer than MaxBackoff
+				delay = d
+				if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+					delay = policy.MaxBackoff
+				}
+			}
+		}
+		// Don't wait for a retry which could not finish before the deadline, return the last failure instead
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			if err != nil {
+				return resp, errors.Wrap(err, "failed to do Request")
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			// Drain the body so the connection can be re-used
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBody))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Wrap(ctx.Err(), "context done while waiting to retry")
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package aadrm
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer responds with statuses in order (repeating the last one) and records every request
+type testServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	requestIDs []string
+	bodies     []string
+}
+
+func newTestServer(t *testing.T, retryAfter string, statuses ...int) *testServer {
+	s := &testServer{statuses: statuses, retryAfter: retryAfter}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		n := len(s.requestIDs)
+		s.requestIDs = append(s.requestIDs, r.Header.Get("X-MS-RMS-Request-Id"))
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+		status := s.statuses[len(s.statuses)-1]
+		if n < len(s.statuses) {
+			status = s.statuses[n]
+		}
+		if s.retryAfter != "" && status != http.StatusOK {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) client(t *testing.T, policy *RetryPolicy) *Client {
+	c := NewClient(s.Server.Client())
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = u
+	c.RetryPolicy = policy
+	return c
+}
+
+var fastPolicy = &RetryPolicy{MaxAttempts: 4, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func TestRetryTransient(t *testing.T) {
+	s := newTestServer(t, "", http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	resp, err := s.client(t, fastPolicy).do(context.Background(), http.MethodPost, "/test", []byte("body"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+	if len(s.requestIDs) != 3 {
+		t.Fatalf("got %d requests, want 3", len(s.requestIDs))
+	}
+	seen := make(map[string]bool)
+	for i, id := range s.requestIDs {
+		if id == "" || seen[id] {
+			t.Errorf("request %d re-used X-MS-RMS-Request-Id %q", i, id)
+		}
+		seen[id] = true
+		if s.bodies[i] != "body" {
+			t.Errorf("request %d has body %q", i, s.bodies[i])
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	s := newTestServer(t, "", http.StatusServiceUnavailable)
+	resp, err := s.client(t, fastPolicy).do(context.Background(), http.MethodGet, "/test", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(s.requestIDs) != fastPolicy.MaxAttempts {
+		t.Errorf("got status %d after %d requests", resp.StatusCode, len(s.requestIDs))
+	}
+}
+
+// TestNewClientNoRetry makes sure a Client only retries once a RetryPolicy is set
+func TestNewClientNoRetry(t *testing.T) {
+	s := newTestServer(t, "", http.StatusServiceUnavailable, http.StatusOK)
+	c := NewClient(s.Server.Client())
+	if c.RetryPolicy != nil {
+		t.Fatalf("NewClient set RetryPolicy %+v", c.RetryPolicy)
+	}
+	c.BaseURL, _ = url.Parse(s.URL)
+	resp, err := c.do(context.Background(), http.MethodGet, "/test", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(s.requestIDs) != 1 {
+		t.Errorf("got status %d after %d requests", resp.StatusCode, len(s.requestIDs))
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	for _, policy := range []*RetryPolicy{fastPolicy, nil} {
+		s := newTestServer(t, "", http.StatusBadRequest)
+		resp, err := s.client(t, policy).do(context.Background(), http.MethodGet, "/test", nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if len(s.requestIDs) != 1 {
+			t.Errorf("got %d requests, want 1", len(s.requestIDs))
+		}
+	}
+}
+
+// TestRetryAfterClamped makes sure a huge Retry-After is capped at MaxBackoff
+func TestRetryAfterClamped(t *testing.T) {
+	s := newTestServer(t, "3600", http.StatusTooManyRequests, http.StatusOK)
+	start := time.Now()
+	resp, err := s.client(t, fastPolicy).do(context.Background(), http.MethodGet, "/test", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %s for the retry", elapsed)
+	}
+}
+
+// TestRetryAfterDeadline returns the last response instead of waiting past the context deadline
+func TestRetryAfterDeadline(t *testing.T) {
+	s := newTestServer(t, "3600", http.StatusServiceUnavailable)
+	policy := &RetryPolicy{MaxAttempts: 4, MinBackoff: time.Millisecond, MaxBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	resp, err := s.client(t, policy).do(ctx, http.MethodGet, "/test", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(s.requestIDs) != 1 {
+		t.Errorf("got status %d after %d requests", resp.StatusCode, len(s.requestIDs))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s before giving up", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	for _, tc := range []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+		ok    bool
+	}{
+		{"", 0, 0, false},
+		{"120", 120 * time.Second, 120 * time.Second, true},
+		{"-1", 0, 0, false},
+		{"soon", 0, 0, false},
+		{future, 59 * time.Minute, time.Hour, true},
+		{past, 0, 0, true},
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if tc.value != "" {
+			resp.Header.Set("Retry-After", tc.value)
+		}
+		d, ok := retryAfter(resp)
+		if ok != tc.ok || d < tc.min || d > tc.max {
+			t.Errorf("%q: got %s %v", tc.value, d, ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 1; attempt < 10; attempt++ {
+		if d := p.backoff(attempt); d < 0 || d >= time.Second {
+			t.Errorf("attempt %d: backoff %s", attempt, d)
+		}
+	}
+}
@@ -16,15 +16,14 @@ type Template struct {
 
 // ListTemplates calls /my/v2/templates
 func (c *Client) ListTemplates(ctx context.Context) ([]Template, *http.Response, error) {
-	req, err := c.NewRequest(ctx, "GET", "/my/v2/templates", nil)
+	resp, err := c.do(ctx, "GET", "/my/v2/templates", nil, "")
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to create Request")
-	}
-	resp, err := c.c.Do(req)
-	if err != nil {
-		return nil, resp, errors.Wrap(err, "failed to do Request")
+		return nil, resp, err
 	}
 	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, resp, err
+	}
 	var templates []Template
 	if err := json.NewDecoder(resp.Body).Decode(&templates); err != nil {
 		return nil, resp, errors.Wrap(err, "failed decode JSON")
@@ -0,0 +1,37 @@
+#!/bin/sh
+# Regenerates the known-answer ciphertexts with the openssl CLI, independently of package aadrm.
+# The key is bytes 0, 1, 2... and the plaintext is byte i%251 at offset i. CBC segments are chained
+# separately with the IV AES-ECB(key, little-endian uint64 byte offset of the segment || 8 zero bytes).
+set -e
+cd "$(dirname "$0")"
+
+plaintext() {
+	python3 -c "import sys; sys.stdout.buffer.write(bytes(i % 251 for i in range($1)))"
+}
+
+# kat mode segment bits length
+kat() {
+	key=$(python3 -c "print(bytes(range($3 // 8)).hex())")
+	plaintext "$4" > pt.tmp
+	if [ "$2" -eq 0 ]; then
+		openssl enc -aes-"$3"-ecb -nopad -K "$key" -in pt.tmp > "$1-$3.bin"
+	else
+		: > "$1-$3.bin"
+		i=0
+		while [ $((i * $2)) -lt "$4" ]; do
+			iv=$(python3 -c "import sys, struct; sys.stdout.buffer.write(struct.pack('<Q', $i * $2) + bytes(8))" |
+				openssl enc -aes-"$3"-ecb -nopad -K "$key" | xxd -p)
+			echo "$1-$3 IV at $((i * $2)): $iv"
+			dd if=pt.tmp bs="$2" skip="$i" count=1 2>/dev/null |
+				openssl enc -aes-"$3"-cbc -nopad -K "$key" -iv "$iv" >> "$1-$3.bin"
+			i=$((i + 1))
+		done
+	fi
+	rm pt.tmp
+}
+
+for bits in 128 256; do
+	kat ecb 0 "$bits" 1040
+	kat cbc512 512 "$bits" 1056
+	kat cbc4k 4096 "$bits" 8224
+done
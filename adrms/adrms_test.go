@@ -0,0 +1,407 @@
+package adrms
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/seal"
+	"github.com/bored-engineer/rms/xrml"
+)
+
+// keyBlob encodes an AES key as a PLAINTEXTKEYBLOB
+func keyBlob(key []byte) []byte {
+	alg := uint32(calgAES128)
+	if len(key) == 32 {
+		alg = calgAES256
+	}
+	b := []byte{plaintextKeyBlob, curBlobVersion, 0, 0}
+	b = binary.LittleEndian.AppendUint32(b, alg)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(key)))
+	return append(b, key...)
+}
+
+// privateKeyBlobOf encodes an RSA key as a PRIVATEKEYBLOB
+func privateKeyBlobOf(key *rsa.PrivateKey) []byte {
+	bits := key.N.BitLen()
+	b := []byte{privateKeyBlob, curBlobVersion, 0, 0, 0xa4, 0, 0, 0}
+	b = binary.LittleEndian.AppendUint32(b, rsa2Magic)
+	b = binary.LittleEndian.AppendUint32(b, uint32(bits))
+	b = binary.LittleEndian.AppendUint32(b, uint32(key.E))
+	for _, v := range []struct {
+		n    []byte
+		size int
+	}{
+		{key.N.Bytes(), bits / 8},
+		{key.Primes[0].Bytes(), bits / 16},
+		{key.Primes[1].Bytes(), bits / 16},
+		{key.Precomputed.Dp.Bytes(), bits / 16},
+		{key.Precomputed.Dq.Bytes(), bits / 16},
+		{key.Precomputed.Qinv.Bytes(), bits / 16},
+		{key.D.Bytes(), bits / 8},
+	} {
+		padded := make([]byte, v.size)
+		copy(padded[v.size-len(v.n):], v.n)
+		b = append(b, reverse(padded)...)
+	}
+	return b
+}
+
+// sealKey encrypts a key blob to pub little-endian like CryptoAPI
+func sealKey(t *testing.T, pub *rsa.PublicKey, blob []byte) string {
+	t.Helper()
+	sealed, err := rsa.EncryptPKCS1v15(rand.Reader, pub, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(reverse(sealed))
+}
+
+// certificate encodes an XrML certificate of typ with the enabling bits
+func certificate(t *testing.T, typ string, bits ...xrml.EnablingBits) string {
+	t.Helper()
+	b, err := xml.Marshal(&xrml.Certificate{Version: "1.2", Body: xrml.Body{Type: typ, EnablingBits: bits}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// server is a fake AD RMS cluster, faults maps a SOAPAction to the status and body returned instead
+type server struct {
+	t          *testing.T
+	machineKey *rsa.PublicKey
+	racKey     *rsa.PrivateKey
+	contentKey []byte
+
+	mu       sync.Mutex
+	requests map[string]string
+	faults   map[string]fault
+}
+
+// fault is a response returned instead of the result of an action
+type fault struct {
+	status int
+	body   string
+}
+
+func newServer(t *testing.T, id *Identity) (*server, *Client) {
+	machineKey, err := id.machineKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	racKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		t:          t,
+		machineKey: &machineKey.PublicKey,
+		racKey:     racKey,
+		contentKey: []byte("0123456789abcdef"),
+		requests:   make(map[string]string),
+		faults:     make(map[string]fault),
+	}
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	u, _ := url.Parse(hs.URL + "/rms")
+	return s, NewClient(hs.Client(), u)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	b, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests[action] = string(b)
+	f, faulted := s.faults[action]
+	s.mu.Unlock()
+
+	paths := map[string]string{
+		"http://microsoft.com/DRM/CertificationService/Certify":            "/rms" + CertificationPath,
+		"http://microsoft.com/DRM/PublishingService/GetClientLicensorCert": "/rms" + PublishingPath,
+		"http://microsoft.com/DRM/LicensingService/AcquireLicense":         "/rms" + LicensingPath,
+	}
+	if r.Method != "POST" || r.URL.Path != paths[action] || !strings.HasPrefix(r.Header.Get("Content-Type"), "text/xml") {
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path+" "+action, http.StatusBadRequest)
+		return
+	}
+	if faulted {
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+		return
+	}
+
+	var name, chain string
+	switch action {
+	case "http://microsoft.com/DRM/CertificationService/Certify":
+		// The RAC private key is encrypted with a symmetric key sealed to the machine
+		racSymmetric := []byte("fedcba9876543210")
+		encryptedKey, err := ecbKey(racSymmetric).Encrypt(privateKeyBlobOf(s.racKey))
+		if err != nil {
+			s.t.Error(err)
+		}
+		name, chain = "CertifyResponse><CertifyResult", certificate(s.t, "Rights-Account-Certificate",
+			xrml.EnablingBits{Type: xrml.SealedKeyType, Value: xrml.Value{Encoding: "base64", Value: sealKey(s.t, s.machineKey, keyBlob(racSymmetric))}},
+			xrml.EnablingBits{Type: xrml.EncryptedPrivateKeyType, Value: xrml.Value{Encoding: "base64", Value: base64.StdEncoding.EncodeToString(encryptedKey)}},
+		)
+	case "http://microsoft.com/DRM/PublishingService/GetClientLicensorCert":
+		name, chain = "GetClientLicensorCertResponse><GetClientLicensorCertResult", certificate(s.t, "Client-Licensor-Certificate")
+	case "http://microsoft.com/DRM/LicensingService/AcquireLicense":
+		name, chain = "AcquireLicenseResponse><AcquireLicenseResult><AcquireLicenseResponse", certificate(s.t, "Microsoft Use License",
+			xrml.EnablingBits{Type: xrml.SealedKeyType, Value: xrml.Value{Encoding: "base64", Value: sealKey(s.t, &s.racKey.PublicKey, keyBlob(s.contentKey))}},
+		)
+	}
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(chain))
+	open := "<" + name + "><CertificateChain><Certificate>" + escaped.String() + "</Certificate></CertificateChain>"
+	closing := ""
+	for _, element := range strings.Split(name, "><") {
+		closing = "</" + element + ">" + closing
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+		open + closing + `</soap:Body></soap:Envelope>`))
+}
+
+// request returns the last request body for action
+func (s *server) request(action string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests["http://microsoft.com/DRM/"+action]
+}
+
+// ecbKey wraps a raw AES key
+func ecbKey(value []byte) *aadrm.Key {
+	encoded := base64.StdEncoding.EncodeToString(value)
+	mode, algorithm, size := "MICROSOFT.ECB", "AES", len(value)
+	return &aadrm.Key{Value: &encoded, CipherMode: &mode, Algorithm: &algorithm, Size: &size}
+}
+
+var testLicense = []byte(`<?xml version="1.0"?><XrML><BODY type="Microsoft Rights Label"><WORK><OBJECT><ID type="MS-GUID">{content-1}</ID></OBJECT></WORK></BODY></XrML>`)
+
+func TestClient(t *testing.T) {
+	id, err := ActivateMachine("test-machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := xrml.Parse([]byte(xml.Header + id.MachineCertificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := pl.Certificates[0].Body.Type; typ != MachineCertificateType {
+		t.Errorf("machine certificate type is %s", typ)
+	}
+
+	s, c := newServer(t, id)
+	ctx := context.Background()
+	if _, _, _, err := c.GetEndUserLicense(ctx, testLicense); err == nil {
+		t.Error("expected an error without an Identity")
+	}
+	if err := c.GetClientLicensorCert(ctx, id); err == nil {
+		t.Error("expected an error before Certify")
+	}
+
+	if err := c.Certify(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if len(id.RAC) != 1 || !strings.Contains(id.RAC[0], "Rights-Account-Certificate") {
+		t.Errorf("RAC is %q", id.RAC)
+	}
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(id.MachineCertificate))
+	if req := s.request("CertificationService/Certify"); !strings.Contains(req, escaped.String()) {
+		t.Errorf("Certify request does not contain the machine certificate: %s", req)
+	}
+	racKey, err := id.RACKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if racKey.N.Cmp(s.racKey.N) != 0 || racKey.D.Cmp(s.racKey.D) != 0 {
+		t.Error("RACKey does not match the issued key")
+	}
+
+	if err := c.GetClientLicensorCert(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if len(id.CLC) != 1 || !strings.Contains(id.CLC[0], "Client-Licensor-Certificate") {
+		t.Errorf("CLC is %q", id.CLC)
+	}
+
+	c.Identity = id
+	l, raw, resp, err := c.GetEndUserLicense(ctx, testLicense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil || !strings.Contains(string(raw), "Microsoft Use License") {
+		t.Errorf("unexpected response %v and use license %q", resp, raw)
+	}
+	if value, err := l.Key.Bytes(); err != nil || !bytes.Equal(value, s.contentKey) {
+		t.Errorf("content key is %x (%v), want %x", value, err, s.contentKey)
+	}
+	if l.ContentID == nil || *l.ContentID != "{content-1}" || l.AccessStatus == nil || *l.AccessStatus != aadrm.AccessGranted {
+		t.Errorf("unexpected %+v", l)
+	}
+	if req := s.request("LicensingService/AcquireLicense"); !strings.Contains(req, "{content-1}") {
+		t.Errorf("AcquireLicense request does not contain the publishing license: %s", req)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	soapFault := func(s string) string {
+		return `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault>` +
+			`<faultcode>soap:Server</faultcode><faultstring>` + s + `</faultstring><detail/></soap:Fault></soap:Body></soap:Envelope>`
+	}
+	id, err := ActivateMachine("test-machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"no rights", 500, soapFault("Microsoft.RightsManagementServices.NoRightsException: The rights are denied"), aadrm.ErrAccessDenied},
+		{"expired", 500, soapFault("Microsoft.RightsManagementServices.LicenseExpiredException"), aadrm.ErrLicenseExpired},
+		{"forbidden", 403, "Forbidden", aadrm.ErrAccessDenied},
+		{"unauthorized", 401, "<html>401 Unauthorized</html>", aadrm.ErrUnauthorized},
+		{"server error", 500, soapFault("Microsoft.RightsManagementServices.ServerException"), nil},
+		{"not XML", 200, "not XML", nil},
+		{"empty chain", 200, `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><CertifyResponse/></soap:Body></soap:Envelope>`, nil},
+	} {
+		s, c := newServer(t, id)
+		s.faults["http://microsoft.com/DRM/CertificationService/Certify"] = fault{tc.status, tc.body}
+		err := c.Certify(context.Background(), id)
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		for _, sentinel := range []error{aadrm.ErrAccessDenied, aadrm.ErrLicenseExpired, aadrm.ErrUnauthorized} {
+			if got := errors.Is(err, sentinel); got != (sentinel == tc.want) {
+				t.Errorf("%s: errors.Is(%v, %v) is %v", tc.name, err, sentinel, got)
+			}
+		}
+		var f *Fault
+		if isFault := errors.As(err, &f); isFault != (tc.status != http.StatusOK) {
+			t.Errorf("%s: %v is a *Fault: %v", tc.name, err, isFault)
+		} else if isFault && f.StatusCode != tc.status {
+			t.Errorf("%s: StatusCode is %d", tc.name, f.StatusCode)
+		}
+	}
+}
+
+func TestKeyBlobs(t *testing.T) {
+	for _, size := range []int{16, 32} {
+		key := bytes.Repeat([]byte{byte(size)}, size)
+		got, err := parseKeyBlob(keyBlob(key))
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("AES-%d: got %x (%v)", size*8, got, err)
+		}
+	}
+	valid := keyBlob(make([]byte, 16))
+	for name, b := range map[string][]byte{
+		"short":     valid[:11],
+		"type":      append([]byte{privateKeyBlob}, valid[1:]...),
+		"version":   append([]byte{plaintextKeyBlob, 1}, valid[2:]...),
+		"algorithm": append(append(append([]byte{}, valid[:4]...), 0x01, 0x68, 0, 0), valid[8:]...),
+		"truncated": valid[:len(valid)-1],
+	} {
+		if _, err := parseKeyBlob(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := privateKeyBlobOf(rsaKey)
+	got, err := parsePrivateKeyBlob(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.N.Cmp(rsaKey.N) != 0 || got.D.Cmp(rsaKey.D) != 0 || got.E != rsaKey.E {
+		t.Error("private key does not match")
+	}
+	badMagic := append([]byte{}, blob...)
+	badMagic[8] = 'X'
+	badPrime := append([]byte{}, blob...)
+	badPrime[20+128] ^= 1
+	for name, b := range map[string][]byte{
+		"short":     blob[:19],
+		"type":      append([]byte{plaintextKeyBlob}, blob[1:]...),
+		"magic":     badMagic,
+		"truncated": blob[:len(blob)-1],
+		"invalid":   badPrime,
+	} {
+		if _, err := parsePrivateKeyBlob(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Sealed keys are stored little-endian
+	sealed, _ := base64.StdEncoding.DecodeString(sealKey(t, &rsaKey.PublicKey, keyBlob(make([]byte, 16))))
+	if b, err := unseal(rsaKey, sealed); err != nil || !bytes.Equal(b, keyBlob(make([]byte, 16))) {
+		t.Errorf("unseal: %x (%v)", b, err)
+	}
+	if _, err := unseal(rsaKey, reverse(sealed)); err == nil {
+		t.Error("expected an error unsealing big-endian ciphertext")
+	}
+}
+
+func TestIdentityFile(t *testing.T) {
+	id, err := ActivateMachine("test-machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id.RAC = []string{"<XrML>rac</XrML>"}
+	id.CLC = []string{"<XrML>clc</XrML>"}
+	sealer, err := seal.NewKeySealer(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		sealer *seal.Sealer
+	}{{"plain.json", nil}, {"sealed.json", sealer}} {
+		name := filepath.Join(dir, "identity", tc.name)
+		if err := id.Write(name, tc.sealer); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sealed := seal.IsSealed(b); sealed != (tc.sealer != nil) {
+			t.Errorf("%s: IsSealed is %v", tc.name, sealed)
+		}
+		got, err := ReadIdentity(name, tc.sealer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.MachineKey, id.MachineKey) || got.MachineCertificate != id.MachineCertificate || got.RAC[0] != id.RAC[0] || got.CLC[0] != id.CLC[0] {
+			t.Errorf("%s: identity does not round trip", tc.name)
+		}
+		if _, err := got.machineKey(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+	if _, err := ReadIdentity(filepath.Join(dir, "identity", "sealed.json"), nil); err == nil {
+		t.Error("expected an error reading a sealed identity without a Sealer")
+	}
+}
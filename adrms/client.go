@@ -0,0 +1,171 @@
+// Package adrms talks to on-premises Active Directory Rights Management Services (AD RMS) clusters
+// using the SOAP endpoints under /_wmcs (MS-RMPR).
+//
+// Before licenses can be acquired the client must be bootstrapped: ActivateMachine creates the
+// machine key pair locally, Certify exchanges the machine certificate for a rights account
+// certificate (RAC) and GetClientLicensorCert fetches a client licensor certificate (CLC) for publishing.
+package adrms
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/pkg/errors"
+)
+
+// Endpoints of an AD RMS cluster, relative to the cluster URL
+const (
+	CertificationPath = "/_wmcs/certification/certification.asmx"
+	PublishingPath    = "/_wmcs/licensing/publish.asmx"
+	LicensingPath     = "/_wmcs/licensing/license.asmx"
+)
+
+// soapNamespace is the namespace of SOAP 1.1 envelopes
+const soapNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// drmNamespace prefixes the namespace (and SOAPAction) of each AD RMS service
+const drmNamespace = "http://microsoft.com/DRM/"
+
+// maxFaultBody limits how much of an error response is read
+const maxFaultBody = 64 << 10
+
+// versionData is sent in the header of every request
+type versionData struct {
+	XMLName        xml.Name `xml:"VersionData"`
+	Namespace      string   `xml:"xmlns,attr"`
+	MinimumVersion string   `xml:"MinimumVersion"`
+	MaximumVersion string   `xml:"MaximumVersion"`
+}
+
+// requestEnvelope is a SOAP request
+type requestEnvelope struct {
+	XMLName   xml.Name    `xml:"soap:Envelope"`
+	Namespace string      `xml:"xmlns:soap,attr"`
+	Header    versionData `xml:"soap:Header>VersionData"`
+	Body      interface{} `xml:"soap:Body"`
+}
+
+// responseEnvelope is a SOAP response
+type responseEnvelope struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    struct {
+		Fault   *Fault `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
+		Content []byte `xml:",innerxml"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+// Fault is a SOAP fault (or an unexpected HTTP status) returned by AD RMS
+type Fault struct {
+	// StatusCode of the HTTP response
+	StatusCode int `xml:"-"`
+	// Code is the faultcode
+	Code string `xml:"faultcode"`
+	// String is the faultstring, usually the name of the server exception
+	String string `xml:"faultstring"`
+	// Detail is the raw detail element
+	Detail string `xml:"detail"`
+}
+
+func (f *Fault) Error() string {
+	if f.String == "" {
+		return fmt.Sprintf("adrms: HTTP %d", f.StatusCode)
+	}
+	return fmt.Sprintf("adrms: HTTP %d: %s: %s", f.StatusCode, f.Code, f.String)
+}
+
+// Is maps faults to the aadrm sentinel errors so callers can handle both services alike
+func (f *Fault) Is(target error) bool {
+	text := strings.ToLower(f.String + " " + f.Detail)
+	switch target {
+	case aadrm.ErrUnauthorized:
+		return f.StatusCode == http.StatusUnauthorized
+	case aadrm.ErrAccessDenied:
+		return f.StatusCode == http.StatusForbidden || strings.Contains(text, "norights") || strings.Contains(text, "denied")
+	case aadrm.ErrLicenseExpired:
+		return strings.Contains(text, "expired")
+	}
+	return false
+}
+
+// Client interacts with an AD RMS cluster
+type Client struct {
+	c *http.Client
+	// BaseURL is the URL of the cluster (ex: https://rms.example.com)
+	BaseURL *url.URL
+	// Becomes User-Agent header if set
+	UserAgent string
+	// Identity is used to acquire licenses, see ActivateMachine and Certify
+	Identity *Identity
+}
+
+// NewClient creates a client for the cluster at baseURL, the caller must supply a client with auth (ex: basic or negotiate)
+func NewClient(c *http.Client, baseURL *url.URL) *Client {
+	return &Client{c: c, BaseURL: baseURL}
+}
+
+// call posts the SOAP request req for action of service at path and decodes the response into resp
+func (c *Client) call(ctx context.Context, path string, service string, action string, req interface{}, resp interface{}) error {
+	body, err := xml.Marshal(&requestEnvelope{
+		Namespace: soapNamespace,
+		Header: versionData{
+			Namespace:      drmNamespace + service,
+			MinimumVersion: "1.0.0.0",
+			MaximumVersion: "1.0.0.0",
+		},
+		Body: req,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode SOAP request")
+	}
+	u := c.BaseURL.ResolveReference(&url.URL{
+		Path: strings.TrimSuffix(c.BaseURL.Path, "/") + path,
+	})
+	hreq, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return errors.Wrap(err, "failed to call http.NewRequestWithContext")
+	}
+	hreq.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	hreq.Header.Set("SOAPAction", `"`+drmNamespace+service+"/"+action+`"`)
+	if c.UserAgent != "" {
+		hreq.Header.Set("User-Agent", c.UserAgent)
+	}
+	hresp, err := c.c.Do(hreq)
+	if err != nil {
+		return errors.Wrap(err, "failed to do Request")
+	}
+	defer hresp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(hresp.Body, 16<<20))
+	if err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+	var env responseEnvelope
+	if err := xml.Unmarshal(b, &env); err != nil {
+		if hresp.StatusCode != http.StatusOK {
+			if len(b) > maxFaultBody {
+				b = b[:maxFaultBody]
+			}
+			return &Fault{StatusCode: hresp.StatusCode, Detail: string(b)}
+		}
+		return errors.Wrap(err, "failed to decode SOAP response")
+	}
+	if env.Body.Fault != nil {
+		env.Body.Fault.StatusCode = hresp.StatusCode
+		return env.Body.Fault
+	} else if hresp.StatusCode != http.StatusOK {
+		return &Fault{StatusCode: hresp.StatusCode}
+	}
+	if err := xml.Unmarshal(env.Body.Content, resp); err != nil {
+		return errors.Wrapf(err, "failed to decode %s response", action)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package adrms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/seal"
+	"github.com/bored-engineer/rms/xrml"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/pkg/errors"
+)
+
+// MachineCertificateType is the BODY type of the certificate created by ActivateMachine
+const MachineCertificateType = "Machine"
+
+// machineKeyBits is the size of the machine key pair
+const machineKeyBits = 2048
+
+// Identity is the state created while bootstrapping a client, it contains private keys and must be kept secret
+type Identity struct {
+	// MachineKey is the PKCS #1 encoded private key of the machine
+	MachineKey []byte
+	// MachineCertificate is the XrML certificate of the machine public key
+	MachineCertificate string
+	// RAC is the rights account certificate chain from Certify
+	RAC []string `json:",omitempty"`
+	// CLC is the client licensor certificate chain from GetClientLicensorCert
+	CLC []string `json:",omitempty"`
+}
+
+// ActivateMachine creates a new machine key pair and certificate, name identifies the machine
+func ActivateMachine(name string) (*Identity, error) {
+	key, err := rsa.GenerateKey(rand.Reader, machineKeyBits)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate machine key")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate UUID")
+	}
+	cert, err := xml.Marshal(&xrml.Certificate{
+		Version: "1.2",
+		Purpose: "certificate",
+		Body: xrml.Body{
+			Type:       MachineCertificateType,
+			Version:    "3.0",
+			IssuedTime: time.Now().UTC().Format("2006-01-02T15:04"),
+			IssuedPrincipals: []xrml.Principal{{
+				Object: xrml.Object{
+					Type: "MS-DRM-Machine",
+					ID:   &xrml.ID{Type: "MS-GUID", Value: "{" + id.String() + "}"},
+					Name: name,
+				},
+				PublicKey: xrml.NewPublicKey(&key.PublicKey),
+			}},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode machine certificate")
+	}
+	return &Identity{
+		MachineKey:         x509.MarshalPKCS1PrivateKey(key),
+		MachineCertificate: string(cert),
+	}, nil
+}
+
+// machineKey decodes MachineKey
+func (id *Identity) machineKey() (*rsa.PrivateKey, error) {
+	key, err := x509.ParsePKCS1PrivateKey(id.MachineKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse machine key")
+	}
+	return key, nil
+}
+
+// parseChain parses a certificate chain
+func parseChain(chain []string) (*xrml.PublishingLicense, error) {
+	b := []byte(xml.Header)
+	for _, cert := range chain {
+		for _, c := range splitCertificates([]byte(cert)) {
+			b = append(b, c...)
+		}
+	}
+	return xrml.Parse(b)
+}
+
+// unsealedKey finds the sealed-key ENABLINGBITS in chain and decrypts it with key
+func unsealedKey(chain *xrml.PublishingLicense, key *rsa.PrivateKey) (*xrml.Certificate, *aadrm.Key, error) {
+	for _, cert := range chain.Certificates {
+		bits := cert.EnablingBits(xrml.SealedKeyType)
+		if bits == nil {
+			continue
+		}
+		sealed, err := bits.Value.Bytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		blob, err := unseal(key, sealed)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := parseKeyBlob(blob)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(value)
+		mode := "MICROSOFT.ECB"
+		algorithm := "AES"
+		size := len(value)
+		return cert, &aadrm.Key{
+			Value:      &encoded,
+			CipherMode: &mode,
+			Algorithm:  &algorithm,
+			Size:       &size,
+		}, nil
+	}
+	return nil, nil, errors.New("certificate chain does not contain a sealed key")
+}
+
+// RACKey decrypts the private key of the RAC using the machine key
+func (id *Identity) RACKey() (*rsa.PrivateKey, error) {
+	if len(id.RAC) == 0 {
+		return nil, errors.New("identity does not have a RAC, call Certify first")
+	}
+	machineKey, err := id.machineKey()
+	if err != nil {
+		return nil, err
+	}
+	chain, err := parseChain(id.RAC)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse RAC")
+	}
+	cert, key, err := unsealedKey(chain, machineKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unseal RAC key")
+	}
+	bits := cert.EnablingBits(xrml.EncryptedPrivateKeyType)
+	if bits == nil {
+		return nil, errors.New("RAC does not contain an encrypted private key")
+	}
+	encrypted, err := bits.Value.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := key.Decrypt(encrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt RAC private key")
+	}
+	return parsePrivateKeyBlob(blob)
+}
+
+// ReadIdentity reads an identity written by Write, sealer is required if it was sealed
+func ReadIdentity(name string, sealer *seal.Sealer) (*Identity, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read identity file")
+	}
+	if seal.IsSealed(b) {
+		if sealer == nil {
+			return nil, errors.New("identity file is sealed but no Sealer was provided")
+		}
+		if b, err = sealer.Open(b); err != nil {
+			return nil, errors.Wrap(err, "failed to open identity file")
+		}
+	}
+	var id Identity
+	if err := json.Unmarshal(b, &id); err != nil {
+		return nil, errors.Wrap(err, "failed to decode identity file")
+	}
+	return &id, nil
+}
+
+// Write stores the identity (sealed if sealer is set), the file is only readable by the owner
+func (id *Identity) Write(name string, sealer *seal.Sealer) error {
+	b, err := json.Marshal(id)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode identity")
+	}
+	if sealer != nil {
+		if b, err = sealer.Seal(b); err != nil {
+			return errors.Wrap(err, "failed to seal identity")
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return errors.Wrap(err, "failed to create identity directory")
+	}
+	if err := ioutil.WriteFile(name, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write identity file")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package adrms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// CryptoAPI BLOBHEADER types
+const (
+	plaintextKeyBlob = 0x08
+	privateKeyBlob   = 0x07
+	curBlobVersion   = 0x02
+)
+
+// CryptoAPI ALG_IDs of the supported symmetric keys
+const (
+	calgAES128 = 0x660e
+	calgAES256 = 0x6610
+)
+
+// rsa2Magic is the RSAPUBKEY magic of a private key blob ("RSA2")
+const rsa2Magic = 0x32415352
+
+// reverse returns a reversed copy of b, CryptoAPI stores big integers and ciphertext little-endian
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for idx := range b {
+		r[len(b)-1-idx] = b[idx]
+	}
+	return r
+}
+
+// unseal decrypts a (little-endian) RSA PKCS #1 v1.5 sealed key
+func unseal(key *rsa.PrivateKey, sealed []byte) ([]byte, error) {
+	b, err := rsa.DecryptPKCS1v15(rand.Reader, key, reverse(sealed))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt sealed key")
+	}
+	return b, nil
+}
+
+// parseKeyBlob decodes a PLAINTEXTKEYBLOB containing an AES key
+func parseKeyBlob(b []byte) ([]byte, error) {
+	if len(b) < 12 {
+		return nil, errors.New("key blob is too short")
+	}
+	if b[0] != plaintextKeyBlob || b[1] != curBlobVersion {
+		return nil, errors.Errorf("unsupported key blob type %#x version %d", b[0], b[1])
+	}
+	switch alg := binary.LittleEndian.Uint32(b[4:8]); alg {
+	case calgAES128, calgAES256:
+	default:
+		return nil, errors.Errorf("unsupported key blob algorithm %#x", alg)
+	}
+	size := binary.LittleEndian.Uint32(b[8:12])
+	if uint64(size) > uint64(len(b)-12) {
+		return nil, errors.Errorf("key blob declares %d byte key but only has %d", size, len(b)-12)
+	}
+	return b[12 : 12+size], nil
+}
+
+// parsePrivateKeyBlob decodes a PRIVATEKEYBLOB containing an RSA key
+func parsePrivateKeyBlob(b []byte) (*rsa.PrivateKey, error) {
+	if len(b) < 20 {
+		return nil, errors.New("private key blob is too short")
+	}
+	if b[0] != privateKeyBlob || b[1] != curBlobVersion {
+		return nil, errors.Errorf("unsupported private key blob type %#x version %d", b[0], b[1])
+	}
+	if magic := binary.LittleEndian.Uint32(b[8:12]); magic != rsa2Magic {
+		return nil, errors.Errorf("unexpected private key blob magic %#x", magic)
+	}
+	bits := int(binary.LittleEndian.Uint32(b[12:16]))
+	e := int(binary.LittleEndian.Uint32(b[16:20]))
+	b = b[20:]
+	// modulus, prime1, prime2, exponent1, exponent2, coefficient, privateExponent
+	sizes := []int{bits / 8, bits / 16, bits / 16, bits / 16, bits / 16, bits / 16, bits / 8}
+	ints := make([]*big.Int, len(sizes))
+	for idx, size := range sizes {
+		if len(b) < size {
+			return nil, errors.New("private key blob is truncated")
+		}
+		ints[idx] = new(big.Int).SetBytes(reverse(b[:size]))
+		b = b[size:]
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: ints[0], E: e},
+		D:         ints[6],
+		Primes:    []*big.Int{ints[1], ints[2]},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid private key in blob")
+	}
+	key.Precompute()
+	return key, nil
+}
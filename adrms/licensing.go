@@ -0,0 +1,174 @@
+package adrms
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/xrml"
+
+	"github.com/pkg/errors"
+)
+
+// certificateChain is a list of XrML certificates in a request or response
+type certificateChain struct {
+	Certificates []string `xml:"Certificate"`
+}
+
+// splitCertificates splits concatenated XrML documents, dropping any XML declarations or padding
+func splitCertificates(b []byte) []string {
+	var certs []string
+	for {
+		start := bytes.Index(b, []byte("<XrML"))
+		if start == -1 {
+			return certs
+		}
+		end := bytes.Index(b[start:], []byte("</XrML>"))
+		if end == -1 {
+			return certs
+		}
+		end += start + len("</XrML>")
+		certs = append(certs, string(b[start:end]))
+		b = b[end:]
+	}
+}
+
+type certifyRequest struct {
+	XMLName           xml.Name         `xml:"http://microsoft.com/DRM/CertificationService Certify"`
+	MachineChain      certificateChain `xml:"RequestParams>MachineCertificateChain"`
+	PersistCredential bool             `xml:"RequestParams>PersistCredential"`
+}
+
+type certifyResponse struct {
+	Chain certificateChain `xml:"CertifyResult>CertificateChain"`
+}
+
+// Certify exchanges the machine certificate of id for a RAC bound to the authenticated user
+func (c *Client) Certify(ctx context.Context, id *Identity) error {
+	var resp certifyResponse
+	err := c.call(ctx, CertificationPath, "CertificationService", "Certify", &certifyRequest{
+		MachineChain:      certificateChain{Certificates: []string{id.MachineCertificate}},
+		PersistCredential: true,
+	}, &resp)
+	if err != nil {
+		return errors.Wrap(err, "failed to certify machine")
+	}
+	if len(resp.Chain.Certificates) == 0 {
+		return errors.New("Certify did not return a RAC")
+	}
+	id.RAC = resp.Chain.Certificates
+	return nil
+}
+
+type clientLicensorCertRequest struct {
+	XMLName     xml.Name         `xml:"http://microsoft.com/DRM/PublishingService GetClientLicensorCert"`
+	PersonaCert certificateChain `xml:"RequestParams>GetClientLicensorCertParams>PersonaCerts"`
+}
+
+type clientLicensorCertResponse struct {
+	Chain certificateChain `xml:"GetClientLicensorCertResult>CertificateChain"`
+}
+
+// GetClientLicensorCert fetches a CLC for the RAC of id, it is needed to publish content offline
+func (c *Client) GetClientLicensorCert(ctx context.Context, id *Identity) error {
+	if len(id.RAC) == 0 {
+		return errors.New("identity does not have a RAC, call Certify first")
+	}
+	var resp clientLicensorCertResponse
+	err := c.call(ctx, PublishingPath, "PublishingService", "GetClientLicensorCert", &clientLicensorCertRequest{
+		PersonaCert: certificateChain{Certificates: id.RAC},
+	}, &resp)
+	if err != nil {
+		return errors.Wrap(err, "failed to get client licensor certificate")
+	}
+	if len(resp.Chain.Certificates) == 0 {
+		return errors.New("GetClientLicensorCert did not return a CLC")
+	}
+	id.CLC = resp.Chain.Certificates
+	return nil
+}
+
+type acquireLicenseRequest struct {
+	XMLName         xml.Name         `xml:"http://microsoft.com/DRM/LicensingService AcquireLicense"`
+	LicenseeCerts   certificateChain `xml:"RequestParams>AcquireLicenseParams>LicenseeCerts"`
+	IssuanceLicense certificateChain `xml:"RequestParams>AcquireLicenseParams>IssuanceLicense"`
+	ApplicationData string           `xml:"RequestParams>AcquireLicenseParams>ApplicationData"`
+}
+
+type acquireLicenseResponse struct {
+	Chain certificateChain `xml:"AcquireLicenseResult>AcquireLicenseResponse>CertificateChain"`
+}
+
+// AcquireLicense requests a use license for the publishing license, the sealed content key is bound to the RAC of id
+func (c *Client) AcquireLicense(ctx context.Context, id *Identity, license []byte) ([]string, error) {
+	if len(id.RAC) == 0 {
+		return nil, errors.New("identity does not have a RAC, call Certify first")
+	}
+	issuance := splitCertificates(license)
+	if len(issuance) == 0 {
+		return nil, errors.New("publishing license does not contain any XrML")
+	}
+	var resp acquireLicenseResponse
+	err := c.call(ctx, LicensingPath, "LicensingService", "AcquireLicense", &acquireLicenseRequest{
+		LicenseeCerts:   certificateChain{Certificates: id.RAC},
+		IssuanceLicense: certificateChain{Certificates: issuance},
+	}, &resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to acquire license")
+	}
+	if len(resp.Chain.Certificates) == 0 {
+		return nil, errors.New("AcquireLicense did not return a use license")
+	}
+	return resp.Chain.Certificates, nil
+}
+
+// DecryptUseLicense unseals the content key of a use license with the RAC of id
+func (id *Identity) DecryptUseLicense(useLicense []string) (*aadrm.Key, error) {
+	racKey, err := id.RACKey()
+	if err != nil {
+		return nil, err
+	}
+	chain, err := parseChain(useLicense)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse use license")
+	}
+	_, key, err := unsealedKey(chain, racKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unseal content key")
+	}
+	return key, nil
+}
+
+// GetEndUserLicense acquires and decrypts a use license with Identity, it mirrors aadrm.Client.GetEndUserLicense
+// so either client can decrypt content, the returned *http.Response is always nil
+func (c *Client) GetEndUserLicense(ctx context.Context, license []byte) (*aadrm.EndUserLicense, []byte, *http.Response, error) {
+	if c.Identity == nil {
+		return nil, nil, nil, errors.New("client does not have an Identity")
+	}
+	useLicense, err := c.AcquireLicense(ctx, c.Identity, license)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	raw := []byte(strings.Join(useLicense, ""))
+	key, err := c.Identity.DecryptUseLicense(useLicense)
+	if err != nil {
+		return nil, raw, nil, err
+	}
+	status := aadrm.AccessGranted
+	l := &aadrm.EndUserLicense{
+		AccessStatus: &status,
+		Key:          key,
+	}
+	if pl, err := xrml.Parse(license); err == nil {
+		if contentID := pl.ContentID(); contentID != "" {
+			l.ContentID = &contentID
+		}
+		if owner := pl.Owner(); owner != "" {
+			l.Owner = &owner
+		}
+	}
+	return l, raw, nil, nil
+}
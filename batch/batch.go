@@ -0,0 +1,323 @@
+// Package batch decrypts trees of protected files (and the ZIP archives in them) with a bounded pool of
+// workers sharing one Licensor, mirroring the input tree in the output directory.
+//
+// Each content ID's license is requested once per run (again only after a transient failure) and
+// every file's outcome is reported as a Result, optionally written to a JSON-lines manifest.
+package batch
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/message"
+
+	"github.com/pkg/errors"
+)
+
+// Values of Result.Status
+const (
+	StatusDecrypted = "decrypted"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// Result is the outcome of a single file
+type Result struct {
+	// Input is the path of the file (or the ZIP archive containing it)
+	Input string
+	// Entry is the name of the file inside the ZIP archive Input
+	Entry string `json:",omitempty"`
+	// Output is the path the plaintext was written to
+	Output string `json:",omitempty"`
+	// Conflict is the output path an earlier file of the run already claimed, Output is numbered instead
+	Conflict  string `json:",omitempty"`
+	Format    string `json:",omitempty"`
+	ContentID string `json:",omitempty"`
+	Status    string
+	// Error is the reason the file failed or was skipped
+	Error string `json:",omitempty"`
+}
+
+// Summary counts the Results of a run
+type Summary struct {
+	Decrypted int
+	Failed    int
+	Skipped   int
+}
+
+// Decrypter decrypts every protected file under a set of inputs
+type Decrypter struct {
+	// Workers is the number of files decrypted concurrently, runtime.NumCPU() if zero
+	Workers int
+	// Manifest receives every Result as a line of JSON if set
+	Manifest io.Writer
+	// OnResult is called (from a single goroutine) with every Result if set
+	OnResult func(*Result)
+
+	client *dedupLicensor
+}
+
+// New creates a *Decrypter (the zero value is not usable) fetching licenses with client (ex: an *aadrm.Client or *adrms.Client)
+func New(client aadrm.Licensor) *Decrypter {
+	return &Decrypter{client: newDedupLicensor(client)}
+}
+
+// job is a single file to decrypt
+type job struct {
+	// input and entry are reported in the Result
+	input string
+	entry string
+	// rel is the slash separated path of the file relative to its input
+	rel  string
+	open func() (io.ReadCloser, error)
+	// done is called once the job has been processed if set
+	done func()
+}
+
+// isArchive reports if a file should be walked as a ZIP archive (OOXML packages are ZIP files too)
+func isArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// walkArchive queues every file in the ZIP archive at name, rel is the archive's own relative path
+func walkArchive(ctx context.Context, name string, rel string, jobs chan<- *job) error {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open archive %s", name)
+	}
+	// The archive is closed once every queued entry has been processed
+	var wg sync.WaitGroup
+	defer func() {
+		go func() {
+			wg.Wait()
+			zr.Close()
+		}()
+	}()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// Entries are relative to the archive, never let them escape it
+		entry := path.Clean("/" + f.Name)[1:]
+		wg.Add(1)
+		select {
+		case jobs <- &job{input: name, entry: f.Name, rel: path.Join(stem(rel), entry), open: f.Open, done: wg.Done}:
+		case <-ctx.Done():
+			wg.Done()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// walk queues every file under input, errors are reported as failed Results
+func walk(ctx context.Context, input string, output string, jobs chan<- *job, results chan<- *Result) error {
+	fail := func(name string, err error) {
+		results <- &Result{Input: name, Status: StatusFailed, Error: err.Error()}
+	}
+	queue := func(name string, rel string) error {
+		if isArchive(name) {
+			if err := walkArchive(ctx, name, rel, jobs); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				fail(name, err)
+			}
+			return nil
+		}
+		open := func() (io.ReadCloser, error) {
+			return os.Open(name)
+		}
+		select {
+		case jobs <- &job{input: name, rel: rel, open: open}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	info, err := os.Stat(input)
+	if err != nil {
+		fail(input, errors.Wrap(err, "failed to stat input"))
+		return nil
+	} else if !info.IsDir() {
+		return queue(input, filepath.Base(input))
+	}
+	return filepath.WalkDir(input, func(name string, entry os.DirEntry, err error) error {
+		if err != nil {
+			fail(name, err)
+			return nil
+		}
+		if entry.IsDir() {
+			// Don't decrypt our own output if it is inside the input
+			if abs, _ := filepath.Abs(name); abs == output && name != input {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(input, name)
+		if err != nil {
+			fail(name, err)
+			return nil
+		}
+		return queue(name, filepath.ToSlash(rel))
+	})
+}
+
+// claims tracks the output paths written by a run so files decrypting to the same name
+// (ex: a.rpmsg and a.msg both become a.eml) do not overwrite each other
+type claims struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// claim reserves dest, or the first free numbered variant of it (ex: "a (2).eml"), case-insensitively
+// as the output may be on a case-insensitive file system
+func (c *claims) claim(dest string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ext := filepath.Ext(dest)
+	name := dest
+	for n := 2; c.paths[strings.ToLower(name)]; n++ {
+		name = strings.TrimSuffix(dest, ext) + " (" + strconv.Itoa(n) + ")" + ext
+	}
+	c.paths[strings.ToLower(name)] = true
+	return name
+}
+
+// process decrypts a single file to output
+func (d *Decrypter) process(ctx context.Context, j *job, output string, claimed *claims) *Result {
+	res := &Result{Input: j.input, Entry: j.entry}
+	fail := func(err error) *Result {
+		res.Status = StatusFailed
+		res.Error = err.Error()
+		return res
+	}
+
+	rc, err := j.open()
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to open input"))
+	}
+	b, err := readAll(rc)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to read input"))
+	}
+	info, err := message.Inspect(b)
+	if errors.Is(err, message.ErrNotProtected) {
+		res.Status = StatusSkipped
+		res.Error = err.Error()
+		return res
+	} else if err != nil {
+		return fail(err)
+	}
+	res.Format = info.Format
+	res.ContentID = info.ContentID
+
+	dec, err := decrypt(ctx, d.client, info.Format, b, j.rel)
+	if err != nil {
+		return fail(err)
+	}
+	// The name is derived from the file (ex: the extension in a PFile header), never let it escape output
+	dest := filepath.Join(output, filepath.FromSlash(dec.name))
+	if rel, err := filepath.Rel(output, dest); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fail(errors.Errorf("output name %q is outside of the output directory", dec.name))
+	}
+	if claim := claimed.claim(dest); claim != dest {
+		res.Conflict = dest
+		dest = claim
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fail(errors.Wrapf(err, "failed to create directory for %s", dest))
+	}
+	if err := os.WriteFile(dest, dec.data, 0644); err != nil {
+		return fail(errors.Wrapf(err, "failed to write output file %s", dest))
+	}
+	res.Output = dest
+	res.Status = StatusDecrypted
+	return res
+}
+
+// Run decrypts every file under inputs (directories are walked, ZIP archives are expanded) into output,
+// each file's path relative to its input is mirrored, a failed file does not stop the run
+func (d *Decrypter) Run(ctx context.Context, inputs []string, output string) (*Summary, error) {
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve output directory")
+	}
+	workers := d.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs := make(chan *job, workers)
+	results := make(chan *Result, workers)
+	var pending sync.WaitGroup
+	pending.Add(1 + workers)
+	claimed := &claims{paths: make(map[string]bool)}
+	var walkErr error
+	go func() {
+		defer pending.Done()
+		defer close(jobs)
+		for _, input := range inputs {
+			if walkErr = walk(ctx, input, absOutput, jobs, results); walkErr != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer pending.Done()
+			for j := range jobs {
+				results <- d.process(ctx, j, output, claimed)
+				if j.done != nil {
+					j.done()
+				}
+			}
+		}()
+	}
+	go func() {
+		pending.Wait()
+		close(results)
+	}()
+
+	summary := &Summary{}
+	var manifestErr error
+	var enc *json.Encoder
+	if d.Manifest != nil {
+		enc = json.NewEncoder(d.Manifest)
+	}
+	for res := range results {
+		switch res.Status {
+		case StatusDecrypted:
+			summary.Decrypted++
+		case StatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+		if enc != nil && manifestErr == nil {
+			if err := enc.Encode(res); err != nil {
+				manifestErr = errors.Wrap(err, "failed to write manifest")
+			}
+		}
+		if d.OnResult != nil {
+			d.OnResult(res)
+		}
+	}
+	if walkErr != nil {
+		return summary, walkErr
+	}
+	return summary, manifestErr
+}
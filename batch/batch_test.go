@@ -0,0 +1,268 @@
+package batch
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/internal/rmstest"
+)
+
+// newLicensor creates a fake licensor which issues a CBC4K key
+func newLicensor(t *testing.T) *rmstest.Licensor {
+	return rmstest.NewLicensor(t, "MICROSOFT.CBC4K", 16)
+}
+
+// buildPFile creates a version 2 PFile of plaintext with the original extension ext
+func buildPFile(t *testing.T, key *aadrm.Key, contentID string, ext string, plaintext string) []byte {
+	return rmstest.BuildPFile(t, key, rmstest.PFile{Extension: ext, License: rmstest.License(contentID)}, []byte(plaintext))
+}
+
+// writeFiles creates every file in files under dir
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, b := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// zipFiles creates a ZIP archive of files
+func zipFiles(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, b := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(b)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// run decrypts inputs into a new output directory and returns the manifest keyed by input (and entry)
+func run(t *testing.T, client aadrm.Licensor, inputs ...string) (string, *Summary, map[string]*Result) {
+	t.Helper()
+	output := filepath.Join(t.TempDir(), "out")
+	var manifest bytes.Buffer
+	d := New(client)
+	d.Workers = 4
+	d.Manifest = &manifest
+	summary, err := d.Run(context.Background(), inputs, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[string]*Result)
+	for _, line := range strings.Split(strings.TrimSpace(manifest.String()), "\n") {
+		var res Result
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Fatalf("manifest line %q: %v", line, err)
+		}
+		name := res.Input
+		if res.Entry != "" {
+			name += ":" + res.Entry
+		}
+		results[name] = &res
+	}
+	return output, summary, results
+}
+
+// checkOutput makes sure the file at name under output holds want
+func checkOutput(t *testing.T, output string, name string, want string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(name)))
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != want {
+		t.Errorf("%s is %q, want %q", name, b, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	l := newLicensor(t)
+	l.Denied["denied"] = true
+	input := t.TempDir()
+	writeFiles(t, input, map[string][]byte{
+		"report.pdf.pfile":  buildPFile(t, l.Key, "shared", ".pdf", "report"),
+		"sub/notes.ptxt":    buildPFile(t, l.Key, "shared", ".txt", "notes"),
+		"sub/copy.ptxt":     buildPFile(t, l.Key, "shared", ".txt", "copy"),
+		"denied.pfile":      buildPFile(t, l.Key, "denied", ".txt", "denied"),
+		"also-denied.pfile": buildPFile(t, l.Key, "denied", ".txt", "denied"),
+		"plain.txt":         []byte("not protected"),
+		"truncated.pfile":   buildPFile(t, l.Key, "shared", ".txt", "truncated")[:20],
+		"archives/bundle.zip": zipFiles(t, map[string][]byte{
+			"inner/a.ptxt":   buildPFile(t, l.Key, "zipped", ".txt", "zipped"),
+			"../escape.ptxt": buildPFile(t, l.Key, "zipped", ".txt", "escape"),
+			"readme.txt":     []byte("not protected"),
+		}),
+	})
+
+	output, summary, results := run(t, l, input)
+	if *summary != (Summary{Decrypted: 5, Failed: 3, Skipped: 2}) {
+		t.Errorf("unexpected %+v", summary)
+	}
+	if len(results) != 10 {
+		t.Errorf("manifest has %d lines, want 10", len(results))
+	}
+	checkOutput(t, output, "report.pdf", "report")
+	checkOutput(t, output, "sub/notes.txt", "notes")
+	checkOutput(t, output, "sub/copy.txt", "copy")
+	// ZIP entries are written under the archive's stem and may not escape it
+	checkOutput(t, output, "archives/bundle/inner/a.txt", "zipped")
+	checkOutput(t, output, "archives/bundle/escape.txt", "escape")
+
+	archive := filepath.Join(input, "archives", "bundle.zip")
+	for name, status := range map[string]string{
+		filepath.Join(input, "report.pdf.pfile"): StatusDecrypted,
+		filepath.Join(input, "plain.txt"):        StatusSkipped,
+		filepath.Join(input, "truncated.pfile"):  StatusFailed,
+		filepath.Join(input, "denied.pfile"):     StatusFailed,
+		archive + ":inner/a.ptxt":                StatusDecrypted,
+		archive + ":readme.txt":                  StatusSkipped,
+	} {
+		if res, ok := results[name]; !ok {
+			t.Errorf("manifest is missing %s", name)
+		} else if res.Status != status {
+			t.Errorf("%s is %s (%s), want %s", name, res.Status, res.Error, status)
+		}
+	}
+	if res := results[filepath.Join(input, "report.pdf.pfile")]; res.Format != "pfile" || res.ContentID != "{shared}" || res.Output != filepath.Join(output, "report.pdf") {
+		t.Errorf("unexpected %+v", res)
+	}
+
+	// Every content ID is requested once, including the denied one
+	for _, id := range []string{"shared", "zipped", "denied"} {
+		if n := l.Calls(id); n != 1 {
+			t.Errorf("%s was requested %d times", id, n)
+		}
+	}
+}
+
+// TestRunCollisions decrypts files which have the same output name
+func TestRunCollisions(t *testing.T) {
+	l := newLicensor(t)
+	input1, input2 := t.TempDir(), t.TempDir()
+	writeFiles(t, input1, map[string][]byte{
+		"a.pdf.pfile": buildPFile(t, l.Key, "a", ".pdf", "one"),
+		"a.ppdf":      buildPFile(t, l.Key, "a", ".pdf", "two"),
+		"B.ptxt":      buildPFile(t, l.Key, "b", ".txt", "upper"),
+	})
+	writeFiles(t, input2, map[string][]byte{
+		"b.ptxt": buildPFile(t, l.Key, "b", ".txt", "lower"),
+	})
+
+	output, summary, results := run(t, l, input1, input2)
+	if *summary != (Summary{Decrypted: 4}) {
+		t.Fatalf("unexpected %+v", summary)
+	}
+	for _, files := range [][2]string{{"a.pdf", "a (2).pdf"}, {"B.txt", "b (2).txt"}} {
+		first := filepath.Join(output, files[0])
+		var outputs, conflicts int
+		for _, res := range results {
+			if strings.EqualFold(res.Output, first) {
+				outputs++
+			} else if strings.EqualFold(res.Output, filepath.Join(output, files[1])) {
+				conflicts++
+				if !strings.EqualFold(res.Conflict, first) {
+					t.Errorf("%s: Conflict is %q", res.Output, res.Conflict)
+				}
+			}
+		}
+		if outputs != 1 || conflicts != 1 {
+			t.Errorf("%s: got %d outputs and %d numbered outputs, want one of each", files[0], outputs, conflicts)
+		}
+	}
+	got := make(map[string]bool)
+	entries, _ := os.ReadDir(output)
+	for _, e := range entries {
+		b, _ := os.ReadFile(filepath.Join(output, e.Name()))
+		got[string(b)] = true
+	}
+	for _, want := range []string{"one", "two", "upper", "lower"} {
+		if !got[want] {
+			t.Errorf("%q was overwritten", want)
+		}
+	}
+}
+
+// TestRunEscape decrypts a PFile whose extension would write outside of the output directory
+func TestRunEscape(t *testing.T) {
+	l := newLicensor(t)
+	input := t.TempDir()
+	writeFiles(t, input, map[string][]byte{
+		"x.pfile": buildPFile(t, l.Key, "x", "/../../escape", "escape"),
+	})
+	output, summary, _ := run(t, l, input)
+	if *summary != (Summary{Decrypted: 1}) {
+		t.Errorf("unexpected %+v", summary)
+	}
+	checkOutput(t, output, "x", "escape")
+	if _, err := os.Stat(filepath.Join(filepath.Dir(output), "escape")); !os.IsNotExist(err) {
+		t.Errorf("file was written outside of the output directory: %v", err)
+	}
+}
+
+func TestDedupLicensor(t *testing.T) {
+	ctx := context.Background()
+
+	// A transient failure is not remembered
+	l := newLicensor(t)
+	l.Failures = 1
+	d := newDedupLicensor(l)
+	if _, _, _, err := d.GetEndUserLicense(ctx, rmstest.License("flaky")); err == nil {
+		t.Fatal("expected the first request to fail")
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := d.GetEndUserLicense(ctx, rmstest.License("flaky")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := l.Calls("flaky"); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+
+	// A denial is
+	l.Denied["denied"] = true
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := d.GetEndUserLicense(ctx, rmstest.License("denied")); !errors.Is(err, aadrm.ErrAccessDenied) {
+			t.Fatalf("got %v, want ErrAccessDenied", err)
+		}
+	}
+	if n := l.Calls("denied"); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+
+	// Concurrent requests share one request
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := d.GetEndUserLicense(ctx, rmstest.License("concurrent")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := l.Calls("concurrent"); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
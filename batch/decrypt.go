@@ -0,0 +1,111 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"path"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/message"
+	"github.com/bored-engineer/rms/outlook"
+	"github.com/bored-engineer/rms/pdf"
+	"github.com/bored-engineer/rms/pfile"
+
+	"github.com/pkg/errors"
+)
+
+// stem returns name without its extension
+func stem(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// decrypted is the plaintext of a file and the (slash separated) name to write it as
+type decrypted struct {
+	name string
+	data []byte
+}
+
+// decryptEnvelope decrypts an rpmsg or compound file, messages are converted to .eml
+func decryptEnvelope(ctx context.Context, client aadrm.Licensor, e *message.Envelope, header mail.Header, name string) (*decrypted, error) {
+	msg, err := e.Decrypt(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Package != nil {
+		ext, err := message.PackageExtension(msg.Package)
+		if err != nil {
+			// Protected documents usually keep the original extension
+			ext = path.Ext(name)
+		}
+		return &decrypted{name: stem(name) + ext, data: msg.Package}, nil
+	}
+	content, err := msg.Content()
+	if err != nil {
+		// Not a message, keep the decrypted compound file as-is
+		return &decrypted{name: stem(name) + ".compound", data: msg.Compound}, nil
+	}
+	var eml bytes.Buffer
+	if err := content.WriteEML(&eml, header); err != nil {
+		return nil, errors.Wrap(err, "failed to write message")
+	}
+	return &decrypted{name: stem(name) + ".eml", data: eml.Bytes()}, nil
+}
+
+// decrypt decrypts a protected file of format (see message.Inspect) named name
+func decrypt(ctx context.Context, client aadrm.Licensor, format string, b []byte, name string) (*decrypted, error) {
+	switch format {
+	case message.FormatPFile:
+		f, err := pfile.Open(ctx, bytes.NewReader(b), int64(len(b)), client)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt")
+		}
+		return &decrypted{name: f.Header.OriginalName(name), data: data}, nil
+	case message.FormatPDF:
+		doc, err := pdf.Open(ctx, b, client)
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if _, err := doc.File.WriteTo(&out); err != nil {
+			return nil, errors.Wrap(err, "failed to write PDF")
+		}
+		return &decrypted{name: stem(name) + ".pdf", data: out.Bytes()}, nil
+	case message.FormatRPMSG:
+		e, err := message.ReadEnvelope(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return decryptEnvelope(ctx, client, e, nil, name)
+	case message.FormatCompound, message.FormatOffice:
+		e, err := message.ReadCompoundEnvelope(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return decryptEnvelope(ctx, client, e, nil, name)
+	case message.FormatMSG, message.FormatEML:
+		w, err := outlook.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		e, err := message.ReadEnvelope(bytes.NewReader(w.RPMSG))
+		if err != nil {
+			return nil, err
+		}
+		return decryptEnvelope(ctx, client, e, w.Header, name)
+	}
+	return nil, errors.Errorf("unsupported format %s", format)
+}
+
+// readAll reads an opened input, closing it
+func readAll(rc io.ReadCloser) ([]byte, error) {
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,72 @@
+package batch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/pkg/errors"
+)
+
+// licenseCall is a (possibly still in flight) license request shared by every file with the same content ID
+type licenseCall struct {
+	done    chan struct{}
+	license *aadrm.EndUserLicense
+	raw     []byte
+	err     error
+	// retry is set if the request failed transiently, waiters request the license again
+	retry bool
+}
+
+// dedupLicensor requests each content ID's license once per run, concurrent requests wait for the first
+type dedupLicensor struct {
+	client aadrm.Licensor
+	mu     sync.Mutex
+	calls  map[string]*licenseCall
+}
+
+// newDedupLicensor wraps client
+func newDedupLicensor(client aadrm.Licensor) *dedupLicensor {
+	return &dedupLicensor{
+		client: client,
+		calls:  make(map[string]*licenseCall),
+	}
+}
+
+// GetEndUserLicense implements aadrm.Licensor, a denial is shared as well so a denied content ID
+// is not requested again for every copy of it but any other failure is forgotten
+func (d *dedupLicensor) GetEndUserLicense(ctx context.Context, license []byte) (*aadrm.EndUserLicense, []byte, *http.Response, error) {
+	key := aadrm.LicenseKey(license)
+	for {
+		d.mu.Lock()
+		call, ok := d.calls[key]
+		if !ok {
+			call = &licenseCall{done: make(chan struct{})}
+			d.calls[key] = call
+		}
+		d.mu.Unlock()
+
+		if !ok {
+			var resp *http.Response
+			call.license, call.raw, resp, call.err = d.client.GetEndUserLicense(ctx, license)
+			if call.err != nil && !errors.Is(call.err, aadrm.ErrAccessDenied) {
+				call.retry = true
+				d.mu.Lock()
+				delete(d.calls, key)
+				d.mu.Unlock()
+			}
+			close(call.done)
+			return call.license, call.raw, resp, call.err
+		}
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, nil, nil, ctx.Err()
+		}
+		if !call.retry {
+			return call.license, call.raw, nil, call.err
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Package cfb writes Compound File Binary files (MS-CFB), the read side is handled by mscfb.
+package cfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// Sizes of a version 3 compound file
+const (
+	sectorSize       = 512
+	miniSectorSize   = 64
+	miniStreamCutoff = 4096
+	dirEntrySize     = 128
+	headerDIFAT      = 109
+	sectorEntries    = sectorSize / 4
+	maxNameLength    = 31
+)
+
+// Special sector and stream IDs
+const (
+	freeSect   = 0xFFFFFFFF
+	endOfChain = 0xFFFFFFFE
+	fatSect    = 0xFFFFFFFD
+	difSect    = 0xFFFFFFFC
+	noStream   = 0xFFFFFFFF
+)
+
+// Object types of a directory entry
+const (
+	typeStorage = 1
+	typeStream  = 2
+	typeRoot    = 5
+)
+
+// Colors of a directory entry in its red-black tree
+const (
+	colorRed   = 0
+	colorBlack = 1
+)
+
+// signature starts every compound file
+var signature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// entry is a storage or stream in the tree
+type entry struct {
+	name     string
+	typ      byte
+	clsid    [16]byte
+	data     []byte
+	children []*entry
+
+	// Assigned by WriteTo
+	id    uint32
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	color byte
+}
+
+// Writer builds a compound file in memory, call WriteTo once every entry has been added
+type Writer struct {
+	root *entry
+}
+
+// NewWriter creates an empty compound file
+func NewWriter() *Writer {
+	return &Writer{root: &entry{name: "Root Entry", typ: typeRoot}}
+}
+
+// validName checks the restrictions MS-CFB places on entry names
+func validName(name string) error {
+	if name == "" {
+		return errors.New("empty entry name")
+	} else if len(utf16.Encode([]rune(name))) > maxNameLength {
+		return errors.Errorf("entry name %q is longer than %d characters", name, maxNameLength)
+	} else if strings.ContainsAny(name, `/\:!`) {
+		return errors.Errorf("entry name %q contains an illegal character", name)
+	}
+	return nil
+}
+
+// lookup finds the child of e named name (case-insensitively, like MS-CFB)
+func (e *entry) lookup(name string) *entry {
+	for _, child := range e.children {
+		if strings.EqualFold(child.name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// storage finds (creating if needed) the storage at the slash separated path p
+func (w *Writer) storage(p string) (*entry, error) {
+	e := w.root
+	if p == "" {
+		return e, nil
+	}
+	for _, name := range strings.Split(p, "/") {
+		if err := validName(name); err != nil {
+			return nil, err
+		}
+		child := e.lookup(name)
+		if child == nil {
+			child = &entry{name: name, typ: typeStorage}
+			e.children = append(e.children, child)
+		} else if child.typ != typeStorage {
+			return nil, errors.Errorf("%s is a stream, not a storage", name)
+		}
+		e = child
+	}
+	return e, nil
+}
+
+// split separates the parent storage path from the name of the last entry
+func split(p string) (string, string) {
+	if idx := strings.LastIndex(p, "/"); idx != -1 {
+		return p[:idx], p[idx+1:]
+	}
+	return "", p
+}
+
+// Create adds a stream at the slash separated path p, missing parent storages are created
+func (w *Writer) Create(p string, data []byte) error {
+	dir, name := split(p)
+	if err := validName(name); err != nil {
+		return err
+	}
+	parent, err := w.storage(dir)
+	if err != nil {
+		return err
+	}
+	if parent.lookup(name) != nil {
+		return errors.Errorf("entry %s already exists", p)
+	}
+	parent.children = append(parent.children, &entry{name: name, typ: typeStream, data: data})
+	return nil
+}
+
+// Mkdir adds a (possibly empty) storage at the slash separated path p
+func (w *Writer) Mkdir(p string) error {
+	_, err := w.storage(p)
+	return err
+}
+
+// SetCLSID sets the class ID of the storage at the slash separated path p ("" is the root)
+func (w *Writer) SetCLSID(p string, clsid [16]byte) error {
+	e, err := w.storage(p)
+	if err != nil {
+		return err
+	}
+	e.clsid = clsid
+	return nil
+}
+
+// less orders entry names the way MS-CFB requires, by length then by upper-cased UTF-16 code points
+func less(a string, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	if len(ua) != len(ub) {
+		return len(ua) < len(ub)
+	}
+	for idx := range ua {
+		ca := unicode.ToUpper(rune(ua[idx]))
+		cb := unicode.ToUpper(rune(ub[idx]))
+		if ca != cb {
+			return ca < cb
+		}
+	}
+	return false
+}
+
+// tree links the sorted children into a balanced binary tree and returns the ID of its root, the
+// levels which are full (black levels) are colored black and the incomplete bottom level red so
+// every path has the same number of black entries as MS-CFB requires
+func tree(children []*entry, depth int, black int) uint32 {
+	if len(children) == 0 {
+		return noStream
+	}
+	mid := len(children) / 2
+	children[mid].color = colorBlack
+	if depth >= black {
+		children[mid].color = colorRed
+	}
+	children[mid].left = tree(children[:mid], depth+1, black)
+	children[mid].right = tree(children[mid+1:], depth+1, black)
+	return children[mid].id
+}
+
+// flatten assigns IDs to every entry in depth-first order
+func flatten(e *entry, entries []*entry) []*entry {
+	e.id = uint32(len(entries))
+	e.left, e.right, e.child = noStream, noStream, noStream
+	// The root has no siblings, children are colored by tree
+	if e.typ == typeRoot {
+		e.color = colorBlack
+	}
+	entries = append(entries, e)
+	sort.Slice(e.children, func(i, j int) bool {
+		return less(e.children[i].name, e.children[j].name)
+	})
+	for _, child := range e.children {
+		entries = flatten(child, entries)
+	}
+	// Splitting at the median fills every level but the last, which are the black levels
+	e.child = tree(e.children, 0, bits.Len(uint(len(e.children)+1))-1)
+	return entries
+}
+
+// sectors returns how many units of size are needed for n bytes
+func sectors(n int, size int) int {
+	return (n + size - 1) / size
+}
+
+// chain appends a chain of n sectors starting at start to fat, returning the first sector
+func chain(fat []uint32, start int, n int) uint32 {
+	if n == 0 {
+		return endOfChain
+	}
+	for idx := start; idx < start+n-1; idx++ {
+		fat[idx] = uint32(idx + 1)
+	}
+	fat[start+n-1] = endOfChain
+	return uint32(start)
+}
+
+// WriteTo writes the compound file to out
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	entries := flatten(w.root, nil)
+
+	// Small streams are packed into the mini stream, in 64 byte mini sectors
+	var miniStream []byte
+	var miniFAT []uint32
+	var large []*entry
+	for _, e := range entries {
+		if e.typ != typeStream {
+			continue
+		}
+		switch {
+		case len(e.data) == 0:
+			e.start = endOfChain
+		case len(e.data) < miniStreamCutoff:
+			n := sectors(len(e.data), miniSectorSize)
+			start := len(miniFAT)
+			miniFAT = append(miniFAT, make([]uint32, n)...)
+			e.start = chain(miniFAT, start, n)
+			padded := make([]byte, n*miniSectorSize)
+			copy(padded, e.data)
+			miniStream = append(miniStream, padded...)
+		default:
+			large = append(large, e)
+		}
+	}
+	if pad := len(miniFAT) % sectorEntries; pad != 0 {
+		for idx := pad; idx < sectorEntries; idx++ {
+			miniFAT = append(miniFAT, freeSect)
+		}
+	}
+
+	// Lay out the data sectors: large streams, the mini stream, the mini FAT and the directory
+	dataSectors := 0
+	for _, e := range large {
+		dataSectors += sectors(len(e.data), sectorSize)
+	}
+	miniStreamSectors := sectors(len(miniStream), sectorSize)
+	miniFATSectors := sectors(len(miniFAT)*4, sectorSize)
+	dirSectors := sectors(len(entries)*dirEntrySize, sectorSize)
+	dataSectors += miniStreamSectors + miniFATSectors + dirSectors
+
+	// The FAT must also describe the FAT and DIFAT sectors themselves
+	fatSectors, difatSectors := 0, 0
+	for {
+		total := dataSectors + fatSectors + difatSectors
+		nextFAT := sectors(total, sectorEntries)
+		nextDIFAT := 0
+		if nextFAT > headerDIFAT {
+			nextDIFAT = sectors(nextFAT-headerDIFAT, sectorEntries-1)
+		}
+		if nextFAT == fatSectors && nextDIFAT == difatSectors {
+			break
+		}
+		fatSectors, difatSectors = nextFAT, nextDIFAT
+	}
+
+	fat := make([]uint32, fatSectors*sectorEntries)
+	for idx := range fat {
+		fat[idx] = freeSect
+	}
+	next := 0
+	for _, e := range large {
+		n := sectors(len(e.data), sectorSize)
+		e.start = chain(fat, next, n)
+		next += n
+	}
+	w.root.start = chain(fat, next, miniStreamSectors)
+	w.root.data = miniStream
+	next += miniStreamSectors
+	miniFATStart := chain(fat, next, miniFATSectors)
+	next += miniFATSectors
+	dirStart := chain(fat, next, dirSectors)
+	next += dirSectors
+	fatStart := next
+	for idx := 0; idx < fatSectors; idx++ {
+		fat[next] = fatSect
+		next++
+	}
+	difatStart := next
+	for idx := 0; idx < difatSectors; idx++ {
+		fat[next] = difSect
+		next++
+	}
+
+	bw := &countWriter{w: bufio.NewWriter(out)}
+
+	// Header
+	header := make([]byte, sectorSize)
+	copy(header, signature)
+	binary.LittleEndian.PutUint16(header[24:], 0x003E)
+	binary.LittleEndian.PutUint16(header[26:], 3)
+	binary.LittleEndian.PutUint16(header[28:], 0xFFFE)
+	binary.LittleEndian.PutUint16(header[30:], 9)
+	binary.LittleEndian.PutUint16(header[32:], 6)
+	binary.LittleEndian.PutUint32(header[44:], uint32(fatSectors))
+	binary.LittleEndian.PutUint32(header[48:], dirStart)
+	binary.LittleEndian.PutUint32(header[56:], miniStreamCutoff)
+	binary.LittleEndian.PutUint32(header[60:], miniFATStart)
+	binary.LittleEndian.PutUint32(header[64:], uint32(miniFATSectors))
+	if difatSectors > 0 {
+		binary.LittleEndian.PutUint32(header[68:], uint32(difatStart))
+	} else {
+		binary.LittleEndian.PutUint32(header[68:], endOfChain)
+	}
+	binary.LittleEndian.PutUint32(header[72:], uint32(difatSectors))
+	for idx := 0; idx < headerDIFAT; idx++ {
+		v := uint32(freeSect)
+		if idx < fatSectors {
+			v = uint32(fatStart + idx)
+		}
+		binary.LittleEndian.PutUint32(header[76+idx*4:], v)
+	}
+	bw.Write(header)
+
+	// Data sectors, each padded to a full sector
+	writePadded := func(b []byte) {
+		bw.Write(b)
+		if pad := len(b) % sectorSize; pad != 0 {
+			bw.Write(make([]byte, sectorSize-pad))
+		}
+	}
+	for _, e := range large {
+		writePadded(e.data)
+	}
+	writePadded(miniStream)
+	writePadded(uint32s(miniFAT))
+
+	dir := make([]byte, dirSectors*sectorSize)
+	for idx := len(entries); idx < len(dir)/dirEntrySize; idx++ {
+		emptyEntry(dir[idx*dirEntrySize:])
+	}
+	for _, e := range entries {
+		e.marshal(dir[e.id*dirEntrySize:])
+	}
+	bw.Write(dir)
+
+	bw.Write(uint32s(fat))
+
+	// DIFAT sectors list the FAT sectors which did not fit in the header, the last entry chains to the next
+	difat := make([]uint32, difatSectors*sectorEntries)
+	for idx := range difat {
+		difat[idx] = freeSect
+	}
+	for idx := headerDIFAT; idx < fatSectors; idx++ {
+		n := idx - headerDIFAT
+		difat[n/(sectorEntries-1)*sectorEntries+n%(sectorEntries-1)] = uint32(fatStart + idx)
+	}
+	for idx := 0; idx < difatSectors; idx++ {
+		last := idx*sectorEntries + sectorEntries - 1
+		if idx+1 < difatSectors {
+			difat[last] = uint32(difatStart + idx + 1)
+		} else {
+			difat[last] = endOfChain
+		}
+	}
+	bw.Write(uint32s(difat))
+
+	if bw.err != nil {
+		return bw.n, errors.Wrap(bw.err, "failed to write compound file")
+	}
+	if err := bw.w.Flush(); err != nil {
+		return bw.n, errors.Wrap(err, "failed to write compound file")
+	}
+	return bw.n, nil
+}
+
+// marshal encodes the directory entry into b
+func (e *entry) marshal(b []byte) {
+	name := utf16.Encode([]rune(e.name))
+	for idx, c := range name {
+		binary.LittleEndian.PutUint16(b[idx*2:], c)
+	}
+	binary.LittleEndian.PutUint16(b[64:], uint16((len(name)+1)*2))
+	b[66] = e.typ
+	b[67] = e.color
+	binary.LittleEndian.PutUint32(b[68:], e.left)
+	binary.LittleEndian.PutUint32(b[72:], e.right)
+	binary.LittleEndian.PutUint32(b[76:], e.child)
+	copy(b[80:96], e.clsid[:])
+	if e.typ == typeStorage {
+		return
+	}
+	binary.LittleEndian.PutUint32(b[116:], e.start)
+	binary.LittleEndian.PutUint64(b[120:], uint64(len(e.data)))
+}
+
+// emptyEntry encodes an unused directory entry into b
+func emptyEntry(b []byte) {
+	binary.LittleEndian.PutUint32(b[68:], noStream)
+	binary.LittleEndian.PutUint32(b[72:], noStream)
+	binary.LittleEndian.PutUint32(b[76:], noStream)
+}
+
+// uint32s encodes v little-endian
+func uint32s(v []uint32) []byte {
+	b := make([]byte, len(v)*4)
+	for idx, u := range v {
+		binary.LittleEndian.PutUint32(b[idx*4:], u)
+	}
+	return b
+}
+
+// countWriter remembers the first error and how many bytes were written
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
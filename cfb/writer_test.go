@@ -0,0 +1,212 @@
+package cfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/richardlehane/mscfb"
+)
+
+// write creates a compound file with every stream in streams
+func write(t *testing.T, streams map[string][]byte) []byte {
+	t.Helper()
+	w := NewWriter()
+	for p, data := range streams {
+		if err := w.Create(p, data); err != nil {
+			t.Fatalf("Create %s: %v", p, err)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d but wrote %d bytes", n, buf.Len())
+	}
+	if buf.Len()%sectorSize != 0 {
+		t.Fatalf("file size %d is not a multiple of the sector size", buf.Len())
+	}
+	return buf.Bytes()
+}
+
+// read reads every stream back with mscfb
+func read(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+	doc, err := mscfb.New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := make(map[string][]byte)
+	for f, err := doc.Next(); f != nil; f, err = doc.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[path.Join(path.Join(f.Path...), f.Name)] = data
+	}
+	return streams
+}
+
+func checkRoundTrip(t *testing.T, streams map[string][]byte) []byte {
+	t.Helper()
+	b := write(t, streams)
+	got := read(t, b)
+	if len(got) != len(streams) {
+		t.Errorf("read %d streams, want %d", len(got), len(streams))
+	}
+	for p, data := range streams {
+		// mscfb drops the control characters which prefix reserved names
+		p = strings.Map(func(r rune) rune {
+			if r < ' ' {
+				return -1
+			}
+			return r
+		}, p)
+		if !bytes.Equal(got[p], data) {
+			t.Errorf("%s: read %d bytes which do not match the %d written", p, len(got[p]), len(data))
+		}
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	checkRoundTrip(t, map[string][]byte{
+		"\x06DataSpaces/Version":                                []byte("version"),
+		"\x06DataSpaces/TransformInfo/DRMTransform/\x06Primary": bytes.Repeat([]byte("p"), 200),
+		"\x09DRMContent":                                        bytes.Repeat([]byte("0123456789"), 1000),
+		// Exactly the mini stream cutoff is a regular stream
+		"Cutoff": bytes.Repeat([]byte("c"), miniStreamCutoff),
+		"Small":  bytes.Repeat([]byte("s"), miniStreamCutoff-1),
+		"Empty":  {},
+	})
+}
+
+// TestRoundTripDIFAT writes enough sectors that the FAT no longer fits in the header
+func TestRoundTripDIFAT(t *testing.T) {
+	big := make([]byte, (headerDIFAT+2)*sectorEntries*sectorSize)
+	for i := range big {
+		big[i] = byte(i / sectorSize)
+	}
+	b := checkRoundTrip(t, map[string][]byte{"Big": big, "Small": []byte("small")})
+	if difat := binary.LittleEndian.Uint32(b[72:]); difat == 0 {
+		t.Error("expected DIFAT sectors")
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	w := NewWriter()
+	if err := w.Create("a/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a/B", "a/b/c", "", "a//b", "this name is longer than thirty-one characters", "bad:name"} {
+		if err := w.Create(p, nil); err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+}
+
+// dirEntry is the part of a directory entry needed to check the trees
+type dirEntry struct {
+	name               string
+	color              byte
+	left, right, child uint32
+}
+
+// directory parses the directory entries of a compound file written by Writer
+func directory(t *testing.T, b []byte) []dirEntry {
+	t.Helper()
+	fat := make([]uint32, 0)
+	for i := 0; i < headerDIFAT; i++ {
+		sect := binary.LittleEndian.Uint32(b[76+i*4:])
+		if sect == freeSect {
+			break
+		}
+		off := (int(sect) + 1) * sectorSize
+		for j := 0; j < sectorEntries; j++ {
+			fat = append(fat, binary.LittleEndian.Uint32(b[off+j*4:]))
+		}
+	}
+	var entries []dirEntry
+	for sect := binary.LittleEndian.Uint32(b[48:]); sect != endOfChain; sect = fat[sect] {
+		off := (int(sect) + 1) * sectorSize
+		for i := 0; i < sectorSize/dirEntrySize; i++ {
+			e := b[off+i*dirEntrySize:]
+			n := int(binary.LittleEndian.Uint16(e[64:]))
+			name := make([]byte, 0, n/2)
+			for j := 0; j+2 < n; j += 2 {
+				name = append(name, e[j])
+			}
+			entries = append(entries, dirEntry{
+				name:  string(name),
+				color: e[67],
+				left:  binary.LittleEndian.Uint32(e[68:]),
+				right: binary.LittleEndian.Uint32(e[72:]),
+				child: binary.LittleEndian.Uint32(e[76:]),
+			})
+		}
+	}
+	return entries
+}
+
+// checkTree validates the red-black tree rooted at id and returns its black height
+func checkTree(t *testing.T, entries []dirEntry, id uint32, parentRed bool) int {
+	if id == noStream {
+		return 1
+	}
+	e := entries[id]
+	red := e.color == colorRed
+	if red && parentRed {
+		t.Errorf("red entry %q has a red parent", e.name)
+	}
+	for _, child := range []uint32{e.left, e.right} {
+		if child != noStream {
+			c := entries[child]
+			if (child == e.left) != less(c.name, e.name) {
+				t.Errorf("%q is on the wrong side of %q", c.name, e.name)
+			}
+		}
+	}
+	left := checkTree(t, entries, e.left, red)
+	right := checkTree(t, entries, e.right, red)
+	if left != right {
+		t.Errorf("entry %q has black heights %d and %d", e.name, left, right)
+	}
+	if red {
+		return left
+	}
+	return left + 1
+}
+
+func TestRedBlackTree(t *testing.T) {
+	for n := 0; n <= 40; n++ {
+		streams := make(map[string][]byte, n)
+		for i := 0; i < n; i++ {
+			streams[fmt.Sprintf("Stream%d", i)] = []byte{byte(i)}
+		}
+		entries := directory(t, write(t, streams))
+		root := entries[0]
+		if root.name != "Root Entry" || root.color != colorBlack {
+			t.Fatalf("n=%d: unexpected root %+v", n, root)
+		}
+		if root.child != noStream && entries[root.child].color != colorBlack {
+			t.Errorf("n=%d: the root of the tree is red", n)
+		}
+		checkTree(t, entries, root.child, false)
+		if n > 0 {
+			if got := len(read(t, write(t, streams))); got != n {
+				t.Errorf("n=%d: read %d streams", n, got)
+			}
+		}
+	}
+}
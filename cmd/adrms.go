@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/adrms"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pkg/errors"
+)
+
+// Shared by every command which talks to AD RMS
+var adrmsURL string
+var adrmsIdentityFile string
+var adrmsUsername string
+var adrmsPasswordEnv string
+
+// defaultIdentityFile is where rms adrms activate stores the identity by default
+func defaultIdentityFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".rms-adrms.json"
+	}
+	return filepath.Join(dir, "rms", "adrms.json")
+}
+
+// addADRMSFlags registers the flags used by newADRMSClient
+func addADRMSFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&adrmsURL, "adrms-url", "", "URL of an on-premises AD RMS cluster to use instead of aadrm (ex: https://rms.example.com)")
+	flags.StringVar(&adrmsIdentityFile, "adrms-identity", defaultIdentityFile(), "File to store the AD RMS machine key and certificates in")
+	flags.StringVar(&adrmsUsername, "adrms-username", "", "Username for basic auth to AD RMS (ex: EXAMPLE\\user)")
+	flags.StringVar(&adrmsPasswordEnv, "adrms-password-env", "RMS_ADRMS_PASSWORD", "Environment variable containing the password for --adrms-username")
+}
+
+// basicAuthTransport adds basic auth to every request
+type basicAuthTransport struct {
+	username string
+	password string
+	base     http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, t.password)
+	return t.base.RoundTrip(req)
+}
+
+// newADRMSClient creates an AD RMS client for --adrms-url, the identity is not loaded
+func newADRMSClient() (*adrms.Client, error) {
+	if adrmsURL == "" {
+		return nil, errors.New("--adrms-url is required")
+	}
+	baseURL, err := url.Parse(adrmsURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse --adrms-url %s", adrmsURL)
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: clientInsecure,
+		},
+	}
+	if adrmsUsername != "" {
+		transport = &basicAuthTransport{
+			username: adrmsUsername,
+			password: os.Getenv(adrmsPasswordEnv),
+			base:     transport,
+		}
+	}
+	client := adrms.NewClient(&http.Client{Transport: transport}, baseURL)
+	client.UserAgent = clientUserAgent
+	return client, nil
+}
+
+// readIdentity reads the identity created by rms adrms activate
+func readIdentity() (*adrms.Identity, error) {
+	sealer, err := newSealer()
+	if err != nil {
+		return nil, err
+	}
+	id, err := adrms.ReadIdentity(adrmsIdentityFile, sealer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read AD RMS identity (run rms adrms activate)")
+	}
+	return id, nil
+}
+
+// writeIdentity stores the identity (sealed if configured)
+func writeIdentity(id *adrms.Identity) error {
+	sealer, err := newSealer()
+	if err != nil {
+		return err
+	}
+	return id.Write(adrmsIdentityFile, sealer)
+}
+
+// newLicensor creates an AD RMS client if --adrms-url is set, otherwise an aadrm client
+func newLicensor(ctx context.Context, accessToken string) (aadrm.Licensor, error) {
+	if adrmsURL == "" {
+		return newClient(ctx, accessToken)
+	}
+	client, err := newADRMSClient()
+	if err != nil {
+		return nil, err
+	}
+	if client.Identity, err = readIdentity(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// adrmsCmd represents the adrms command
+var adrmsCmd = &cobra.Command{
+	Use:   "adrms",
+	Short: "Commands to bootstrap a client of an on-premises AD RMS cluster",
+}
+
+// adrmsActivateCmd represents the activate command on adrms
+var adrmsActivateName string
+var adrmsActivateCmd = &cobra.Command{
+	Use:   "activate",
+	Args:  cobra.NoArgs,
+	Short: "Activate the machine (create its key pair) and certify it with --adrms-url",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		client, err := newADRMSClient()
+		if err != nil {
+			return err
+		}
+		id, err := adrms.ActivateMachine(adrmsActivateName)
+		if err != nil {
+			return err
+		}
+		if err := client.Certify(ctx, id); err != nil {
+			return err
+		}
+		if err := client.GetClientLicensorCert(ctx, id); err != nil {
+			return err
+		}
+		if err := writeIdentity(id); err != nil {
+			return err
+		}
+		fmt.Printf("Activated, identity stored in %s\n", adrmsIdentityFile)
+		return nil
+	},
+}
+
+// adrmsCertifyCmd represents the certify command on adrms
+var adrmsCertifyCmd = &cobra.Command{
+	Use:   "certify",
+	Args:  cobra.NoArgs,
+	Short: "Renew the RAC and CLC of an activated machine",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		client, err := newADRMSClient()
+		if err != nil {
+			return err
+		}
+		id, err := readIdentity()
+		if err != nil {
+			return err
+		}
+		if err := client.Certify(ctx, id); err != nil {
+			return err
+		}
+		if err := client.GetClientLicensorCert(ctx, id); err != nil {
+			return err
+		}
+		if err := writeIdentity(id); err != nil {
+			return err
+		}
+		fmt.Printf("Certified, identity stored in %s\n", adrmsIdentityFile)
+		return nil
+	},
+}
+
+func init() {
+	hostname, _ := os.Hostname()
+	adrmsActivateCmd.Flags().StringVar(&adrmsActivateName, "name", hostname, "Name of the machine in its certificate")
+	adrmsCmd.AddCommand(adrmsActivateCmd)
+	adrmsCmd.AddCommand(adrmsCertifyCmd)
+	adrmsCmd.PersistentFlags().BoolVar(&clientInsecure, "insecure", false, "Disable all x509/TLS verification")
+	adrmsCmd.PersistentFlags().StringVarP(&clientUserAgent, "user-agent", "u", "", "User Agent to present to AD RMS")
+	addADRMSFlags(adrmsCmd.PersistentFlags())
+	addSealFlags(adrmsCmd.PersistentFlags())
+	rootCmd.AddCommand(adrmsCmd)
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/seal"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pkg/errors"
+)
+
+// Shared by every command which talks to aadrm
+var clientUserAgent string
+var clientPlatformID string
+var clientInsecure bool
+var clientMaxAttempts int
+var clientCacheDir string
+var clientBaseURL string
+var clientDiscovery string
+
+// Shared by every command which stores secrets
+var sealKeyFile string
+var sealPassphraseEnv string
+
+// Shared by every command which uses the token from rms login
+var loginCloud string
+var loginAuthority string
+var loginClientID string
+var loginTokenFile string
+var loginCertificate string
+
+// clientSecretEnv is the environment variable holding the client secret of a service principal
+const clientSecretEnv = "RMS_CLIENT_SECRET"
+
+// addSealFlags registers the flags used by newSealer
+func addSealFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&sealKeyFile, "seal-key-file", "", "File containing a 256-bit key to seal stored user licenses and tokens with")
+	flags.StringVar(&sealPassphraseEnv, "seal-passphrase-env", "", "Environment variable containing a passphrase to seal stored user licenses and tokens with")
+}
+
+// defaultTokenFile is where rms login stores the token by default
+func defaultTokenFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".rms-token.json"
+	}
+	return filepath.Join(dir, "rms", "token.json")
+}
+
+// addLoginFlags registers the flags used by tokenSource
+func addLoginFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&loginCloud, "cloud", aadrm.PublicCloud.Name, "Azure cloud of the tenant (public, gcc, gcc-high, dod or china)")
+	flags.StringVar(&loginAuthority, "authority", "", "Azure AD authority to login with (default the authority of --cloud)")
+	flags.StringVar(&loginClientID, "client-id", aadrm.DefaultClientID, "Azure AD (public) client ID to login with")
+	flags.StringVar(&loginTokenFile, "token-file", defaultTokenFile(), "File to store the token from rms login in")
+	flags.StringVar(&loginCertificate, "client-certificate", os.Getenv("RMS_CLIENT_CERTIFICATE"), "PEM file with the certificate and key of a service principal (requires --client-id and a tenant --authority)")
+}
+
+// addClientFlags registers the flags used by newClient
+func addClientFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&clientInsecure, "insecure", false, "Disable all x509/TLS verification")
+	flags.StringVarP(&clientUserAgent, "user-agent", "u", "Outlook/16.35.20030802 CFNetwork/1121.1.2 Darwin/19.3.0 (x86_64)", "User Agent to present to aadrm")
+	flags.IntVar(&clientMaxAttempts, "max-attempts", aadrm.DefaultRetryPolicy.MaxAttempts, "Maximum attempts for each request to aadrm (1 disables retries)")
+	flags.StringVar(&clientCacheDir, "cache-dir", "", "Directory to cache user licenses in (disabled if empty)")
+	flags.StringVar(&clientBaseURL, "base-url", "", "aadrm endpoint to use (default the endpoint of --cloud)")
+	flags.StringVar(&clientDiscovery, "discovery", "", "How to find the aadrm endpoint of the tenant: license (licensing URL of each publishing license, the default without --base-url), service (service discovery) or none")
+	flags.StringVarP(&clientPlatformID, "platform-id", "p", "AppName=com.microsoft.Outlook;AppVersion=16.35;DevicePlatform=Mac;OSVersion=10.15.3;SDKVersion=4.2.21;ClientID=00000000-0000-0000-0000-000000000000", "X-MS-RMS-Platform-Id to present to aadrm")
+	addSealFlags(flags)
+	addLoginFlags(flags)
+	addADRMSFlags(flags)
+}
+
+// selectedCloud finds the aadrm.Cloud selected by --cloud
+func selectedCloud() (*aadrm.Cloud, error) {
+	c, ok := aadrm.Clouds[strings.ToLower(loginCloud)]
+	if !ok {
+		return nil, errors.Errorf("unknown cloud %q", loginCloud)
+	}
+	return c, nil
+}
+
+// selectedAuthority is --authority if set, otherwise the authority of --cloud
+func selectedAuthority() (string, error) {
+	if loginAuthority != "" {
+		return loginAuthority, nil
+	}
+	c, err := selectedCloud()
+	if err != nil {
+		return "", err
+	}
+	return c.Authority(), nil
+}
+
+// tokenArgs splits the optional leading access_token from the n other arguments
+func tokenArgs(args []string, n int) (string, []string) {
+	if len(args) > n {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+// newSealer creates the *seal.Sealer for stored secrets, nil if sealing is not configured
+func newSealer() (*seal.Sealer, error) {
+	if sealKeyFile != "" {
+		key, err := seal.ReadKeyFile(sealKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return seal.NewKeySealer(key)
+	}
+	if sealPassphraseEnv != "" {
+		passphrase := os.Getenv(sealPassphraseEnv)
+		if passphrase == "" {
+			return nil, errors.Errorf("environment variable %s is empty", sealPassphraseEnv)
+		}
+		return seal.NewPassphraseSealer([]byte(passphrase))
+	}
+	return nil, nil
+}
+
+// newTokenStore creates the store used by rms login
+func newTokenStore() (*aadrm.FileTokenStore, error) {
+	sealer, err := newSealer()
+	if err != nil {
+		return nil, err
+	}
+	return &aadrm.FileTokenStore{Path: loginTokenFile, Sealer: sealer}, nil
+}
+
+// servicePrincipalTokenSource uses the client certificate or secret of a service principal, nil if neither is configured
+func servicePrincipalTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	secret := os.Getenv(clientSecretEnv)
+	if loginCertificate == "" && secret == "" {
+		return nil, nil
+	}
+	if loginClientID == aadrm.DefaultClientID {
+		return nil, errors.New("--client-id of the service principal is required")
+	}
+	if loginAuthority == "" {
+		return nil, errors.New("--authority of the service principal's tenant is required")
+	}
+	if loginCertificate != "" {
+		b, err := ioutil.ReadFile(loginCertificate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read client certificate %s", loginCertificate)
+		}
+		cert, key, err := aadrm.ParseCertificate(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse client certificate %s", loginCertificate)
+		}
+		return aadrm.ClientCertificateTokenSource(ctx, loginAuthority, loginClientID, cert, key)
+	}
+	return aadrm.ClientSecretTokenSource(ctx, loginAuthority, loginClientID, secret), nil
+}
+
+// tokenSource uses accessToken if given, then a service principal, otherwise the (refreshing) token from rms login
+func tokenSource(ctx context.Context, accessToken string) (oauth2.TokenSource, error) {
+	if accessToken != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: strings.TrimSpace(accessToken),
+		}), nil
+	}
+	if src, err := servicePrincipalTokenSource(ctx); err != nil {
+		return nil, err
+	} else if src != nil {
+		return src, nil
+	}
+	store, err := newTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	authority, err := selectedAuthority()
+	if err != nil {
+		return nil, err
+	}
+	src, err := aadrm.NewStoredTokenSource(ctx, aadrm.OAuth2Config(authority, loginClientID), store)
+	if err != nil {
+		return nil, errors.Wrap(err, "no access_token given and not logged in (run rms login)")
+	}
+	return src, nil
+}
+
+// newClient creates an aadrm client authenticated with accessToken (or the token from rms login if empty)
+func newClient(ctx context.Context, accessToken string) (*aadrm.Client, error) {
+	src, err := tokenSource(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	cloud, err := selectedCloud()
+	if err != nil {
+		return nil, err
+	}
+	client, err := aadrm.NewCloudClient(&http.Client{
+		Transport: &oauth2.Transport{
+			Source: src,
+			Base: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: clientInsecure,
+					RootCAs:            aadrm.NewCertPool(),
+				},
+			},
+		},
+	}, cloud)
+	if err != nil {
+		return nil, err
+	}
+	client.RMSPlatformID = clientPlatformID
+	client.UserAgent = clientUserAgent
+	if clientCacheDir != "" {
+		sealer, err := newSealer()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := src.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get access_token")
+		}
+		// Cached licenses are only ever served back to the user they were issued to
+		identity, err := aadrm.TokenIdentity(tok)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to identify the user for --cache-dir")
+		}
+		cache := aadrm.NewFileCache(clientCacheDir)
+		cache.Sealer = sealer
+		client.Cache = cache
+		client.CacheIdentity = identity
+	}
+	policy := *aadrm.DefaultRetryPolicy
+	policy.MaxAttempts = clientMaxAttempts
+	client.RetryPolicy = &policy
+	if clientBaseURL != "" {
+		baseURL, err := url.Parse(clientBaseURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse --base-url %s", clientBaseURL)
+		}
+		client.BaseURL = baseURL
+	}
+	discovery := clientDiscovery
+	if discovery == "" {
+		// An explicit --base-url is never overridden by the licensing URLs
+		discovery = "license"
+		if clientBaseURL != "" {
+			discovery = "none"
+		}
+	} else if clientBaseURL != "" && discovery != "none" {
+		return nil, errors.New("--base-url and --discovery are mutually exclusive")
+	}
+	switch discovery {
+	case "license":
+		client.DiscoverLicenses = true
+	case "service":
+		if err := client.Discover(ctx); err != nil {
+			return nil, err
+		}
+	case "none":
+	default:
+		return nil, errors.Errorf("unknown --discovery %q", clientDiscovery)
+	}
+	return client, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+)
+
+// TestNewClientDiscovery checks how --cloud, --base-url and --discovery combine
+func TestNewClientDiscovery(t *testing.T) {
+	defer func(cloud, baseURL, discovery string) {
+		loginCloud, clientBaseURL, clientDiscovery = cloud, baseURL, discovery
+	}(loginCloud, clientBaseURL, clientDiscovery)
+
+	for _, tc := range []struct {
+		cloud, baseURL, discovery string
+		// wantBaseURL is empty if newClient fails
+		wantBaseURL      string
+		discoverLicenses bool
+	}{
+		{"public", "", "", "https://api.aadrm.com", true},
+		{"gcc-high", "", "", "https://api.aadrm.us", true},
+		{"public", "", "none", "https://api.aadrm.com", false},
+		{"public", "https://rms.example.com", "", "https://rms.example.com", false},
+		{"public", "https://rms.example.com", "none", "https://rms.example.com", false},
+		{"public", "https://rms.example.com", "license", "", false},
+		{"public", "https://rms.example.com", "service", "", false},
+		{"public", "", "bogus", "", false},
+		{"moon", "", "", "", false},
+	} {
+		loginCloud, clientBaseURL, clientDiscovery = tc.cloud, tc.baseURL, tc.discovery
+		client, err := newClient(context.Background(), "token")
+		if tc.wantBaseURL == "" {
+			if err == nil {
+				t.Errorf("%+v: expected an error", tc)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%+v: %v", tc, err)
+			continue
+		}
+		if got := client.BaseURL.String(); got != tc.wantBaseURL {
+			t.Errorf("%+v: BaseURL is %s", tc, got)
+		}
+		if client.DiscoverLicenses != tc.discoverLicenses {
+			t.Errorf("%+v: DiscoverLicenses is %v", tc, client.DiscoverLicenses)
+		}
+		// The library does not retry by default, the command always does (up to --max-attempts)
+		if client.RetryPolicy == nil || client.RetryPolicy.MaxAttempts != clientMaxAttempts {
+			t.Errorf("%+v: RetryPolicy is %+v", tc, client.RetryPolicy)
+		}
+		if client.Cloud != aadrm.Clouds[tc.cloud] {
+			t.Errorf("%+v: Cloud is %s", tc, client.Cloud.Name)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/bored-engineer/rms/dataspaces"
 
 	"github.com/richardlehane/mscfb"
 
@@ -13,6 +16,53 @@ import (
 	"github.com/pkg/errors"
 )
 
+// unpackCompound writes every non-empty entry of doc into dir
+func unpackCompound(doc *mscfb.Reader, dir string) error {
+	for {
+		entry, err := doc.Next()
+		if entry == nil {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read next compound file")
+		}
+
+		entryName := filepath.Join(filepath.Join(entry.Path...), entry.Name)
+
+		if entry.Size == 0 {
+			fmt.Printf("Skipping empty entry: %s\n", entryName)
+			continue
+		}
+
+		// Entry names come from the (possibly decrypted, attacker controlled) file, never let them escape dir
+		destPath := filepath.Join(dir, entryName)
+		if rel, err := filepath.Rel(dir, destPath); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return errors.Errorf("entry %q is outside of the output directory", entryName)
+		}
+		destDir := filepath.Dir(destPath)
+
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory for file %s", destPath)
+		}
+
+		output, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open output file %s", destPath)
+		}
+
+		n, err := io.Copy(output, entry)
+		if err != nil {
+			output.Close()
+			return errors.Wrapf(err, "failed to copy file %s", destPath)
+		}
+
+		if err := output.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close file %s", destPath)
+		}
+		fmt.Printf("Wrote %d bytes from entry: %s\n", n, entryName)
+	}
+	return nil
+}
+
 // compoundCmd represents the compound command
 var compoundCmd = &cobra.Command{
 	Use:   "compound",
@@ -38,51 +88,57 @@ var compoundUnpackCmd = &cobra.Command{
 			return errors.Wrap(err, "failed to start compound reader")
 		}
 
-		for {
-			entry, err := doc.Next()
-			if entry == nil {
-				break
-			} else if err != nil {
-				return errors.Wrap(err, "failed to read next compound file")
-			}
-
-			entryName := filepath.Join(filepath.Join(entry.Path...), entry.Name)
-
-			if entry.Size == 0 {
-				fmt.Printf("Skipping empty entry: %s\n", entryName)
-				continue
-			}
-
-			destPath := filepath.Join(compoundUnpackOutput, filepath.Clean(entryName))
-			destDir := filepath.Dir(destPath)
+		if err := unpackCompound(doc, compoundUnpackOutput); err != nil {
+			return err
+		}
 
-			if err := os.MkdirAll(destDir, 0755); err != nil {
-				return errors.Wrapf(err, "failed to create directory for file %s", destPath)
-			}
+		outputPath, err := filepath.Abs(compoundUnpackOutput)
+		if err != nil {
+			outputPath = compoundUnpackOutput
+		}
+		fmt.Printf("Unpacked %s to %s\n", args[0], outputPath)
 
-			output, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE, 0644)
-			if err != nil {
-				return errors.Wrapf(err, "failed to open output file %s", destPath)
-			}
+		return nil
+	},
+}
 
-			n, err := io.Copy(output, entry)
-			if err != nil {
-				output.Close()
-				return errors.Wrapf(err, "failed to copy file %s", destPath)
-			}
+// compoundDataSpacesCmd represents the dataspaces command on compound
+var compoundDataSpacesCmd = &cobra.Command{
+	Use:   "dataspaces [file.compound]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Print the DataSpaces structures of a compound file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to open input file")
+		}
+		defer input.Close()
 
-			if err := output.Close(); err != nil {
-				return errors.Wrapf(err, "failed to close file %s", destPath)
-			}
-			fmt.Printf("Wrote %d bytes from entry: %s\n", n, entryName)
+		doc, err := mscfb.New(input)
+		if err != nil {
+			return errors.Wrap(err, "failed to start compound reader")
 		}
 
-		outputPath, err := filepath.Abs(compoundUnpackOutput)
+		ds, err := dataspaces.Read(doc)
 		if err != nil {
-			outputPath = compoundUnpackOutput
+			return errors.Wrap(err, "failed to read DataSpaces")
 		}
-		fmt.Printf("Unpacked %s to %s\n", args[0], outputPath)
 
+		if ds.Version != nil {
+			fmt.Printf("Version: %s (reader %s, updater %s, writer %s)\n", ds.Version.FeatureIdentifier, ds.Version.Reader, ds.Version.Updater, ds.Version.Writer)
+		}
+		for _, e := range ds.Map.Entries {
+			fmt.Printf("Entry: %s -> %s\n", e.Path(), e.DataSpaceName)
+		}
+		for name, def := range ds.Definitions {
+			fmt.Printf("DataSpace: %s -> %v\n", name, def.TransformReferences)
+		}
+		for name, t := range ds.Transforms {
+			fmt.Printf("Transform: %s -> %s %s\n", name, t.ID, t.Name)
+		}
+		for _, p := range ds.Protected() {
+			fmt.Printf("Protected: %s (%s)\n", p.Path, p.DataSpaceName)
+		}
 		return nil
 	},
 }
@@ -90,5 +146,6 @@ var compoundUnpackCmd = &cobra.Command{
 func init() {
 	compoundUnpackCmd.Flags().StringVarP(&compoundUnpackOutput, "output", "o", "unpacked", "Output directory for the unpacked file")
 	compoundCmd.AddCommand(compoundUnpackCmd)
+	compoundCmd.AddCommand(compoundDataSpacesCmd)
 	rootCmd.AddCommand(compoundCmd)
 }
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/batch"
+	"github.com/bored-engineer/rms/message"
+	"github.com/bored-engineer/rms/pdf"
+	"github.com/bored-engineer/rms/pfile"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// openEnvelope reads the Envelope of a protected Office document (ex: .docx) or of a rpmsg (see openRPMSG)
+func openEnvelope(name string) (*message.Envelope, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open input file")
+	}
+	defer f.Close()
+	// A .msg is also a compound file, but without a DataSpaces storage
+	if e, err := message.ReadCompoundEnvelope(f); err == nil {
+		return e, nil
+	}
+	input, _, err := openRPMSG(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return message.ReadEnvelope(input)
+}
+
+// writePackage writes a decrypted Office document to dir, named after the protected input
+func writePackage(pkg []byte, input string, dir string) (string, error) {
+	ext, err := message.PackageExtension(pkg)
+	if err != nil {
+		// Protected documents usually keep the original extension
+		ext = filepath.Ext(input)
+	}
+	base := filepath.Base(input)
+	destPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+ext)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	if err := ioutil.WriteFile(destPath, pkg, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write output file %s", destPath)
+	}
+	return destPath, nil
+}
+
+// decryptPFile streams the plaintext of a PFile to dir, named after the original file
+func decryptPFile(ctx context.Context, client aadrm.Licensor, input string, dir string) (string, error) {
+	f, err := os.Open(input)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open input file")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to stat input file")
+	}
+	pf, err := pfile.Open(ctx, f, info.Size(), client)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	// The name is derived from the PFile header, never let it escape dir
+	name := pf.Header.OriginalName(filepath.Base(input))
+	destPath := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, destPath); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("output name %q is outside of the output directory", name)
+	}
+	output, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open output file %s", destPath)
+	}
+	if _, err := io.Copy(output, pf); err != nil {
+		output.Close()
+		return "", errors.Wrapf(err, "failed to write output file %s", destPath)
+	}
+	if err := output.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to close output file %s", destPath)
+	}
+	return destPath, nil
+}
+
+// decryptPDF decrypts a protected PDF to dir, named after the protected input
+func decryptPDF(ctx context.Context, client aadrm.Licensor, input string, dir string) (string, error) {
+	b, err := ioutil.ReadFile(input)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read input file")
+	}
+	doc, err := pdf.Open(ctx, b, client)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	base := filepath.Base(input)
+	destPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".pdf")
+	output, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open output file %s", destPath)
+	}
+	if _, err := doc.File.WriteTo(output); err != nil {
+		output.Close()
+		return "", errors.Wrapf(err, "failed to write output file %s", destPath)
+	}
+	if err := output.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to close output file %s", destPath)
+	}
+	return destPath, nil
+}
+
+// Kinds of protected input which are not decrypted through a message.Envelope
+const (
+	envelopeInput = iota
+	pfileInput
+	pdfInput
+)
+
+// inputKind sniffs the protected input file name
+func inputKind(name string) int {
+	f, err := os.Open(name)
+	if err != nil {
+		return envelopeInput
+	}
+	defer f.Close()
+	if pfile.IsPFile(f) {
+		return pfileInput
+	}
+	header := make([]byte, len(pdf.Magic))
+	if _, err := io.ReadFull(f, header); err == nil && pdf.IsPDF(header) {
+		return pdfInput
+	}
+	return envelopeInput
+}
+
+// decryptRecursive decrypts every protected file under input into decryptOutput using a batch.Decrypter
+func decryptRecursive(ctx context.Context, client aadrm.Licensor, input string) error {
+	if err := os.MkdirAll(decryptOutput, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", decryptOutput)
+	}
+	manifestPath := decryptManifest
+	if manifestPath == "" {
+		manifestPath = filepath.Join(decryptOutput, "manifest.jsonl")
+	}
+	manifest, err := os.OpenFile(manifestPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open manifest file")
+	}
+	defer manifest.Close()
+
+	d := batch.New(client)
+	d.Workers = decryptWorkers
+	d.Manifest = manifest
+	d.OnResult = func(res *batch.Result) {
+		name := res.Input
+		if res.Entry != "" {
+			name += ":" + res.Entry
+		}
+		if res.Status == batch.StatusFailed {
+			fmt.Fprintf(os.Stderr, "Failed to decrypt %s: %s\n", name, res.Error)
+		} else if res.Status == batch.StatusDecrypted && res.Conflict != "" {
+			fmt.Printf("Decrypted %s to %s (%s was already written by another file)\n", name, res.Output, res.Conflict)
+		} else if res.Status == batch.StatusDecrypted {
+			fmt.Printf("Decrypted %s to %s\n", name, res.Output)
+		}
+	}
+	summary, err := d.Run(ctx, []string{input}, decryptOutput)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Decrypted %d files (%d failed, %d skipped), manifest: %s\n", summary.Decrypted, summary.Failed, summary.Skipped, manifestPath)
+	if summary.Failed > 0 {
+		return errors.Errorf("%d files failed to decrypt", summary.Failed)
+	}
+	return nil
+}
+
+// decryptCmd represents the decrypt command
+var decryptOutput string
+var decryptRecursiveFlag bool
+var decryptWorkers int
+var decryptManifest string
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [access_token] [message.rpmsg|message.msg|message.eml|document.docx|document.pdf|file.pfile|directory]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Decode, fetch a license for and decrypt a rpmsg file, protected Office document, PDF or PFile in one step",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		accessToken, args := tokenArgs(args, 1)
+
+		if decryptRecursiveFlag {
+			client, err := newLicensor(ctx, accessToken)
+			if err != nil {
+				return err
+			}
+			return decryptRecursive(ctx, client, args[0])
+		}
+
+		var e *message.Envelope
+		kind := inputKind(args[0])
+		if kind == envelopeInput {
+			var err error
+			if e, err = openEnvelope(args[0]); err != nil {
+				return err
+			}
+		}
+
+		client, err := newLicensor(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+
+		outputPath := decryptOutput
+		switch kind {
+		case pfileInput:
+			// PFiles are decrypted as they are read instead of in memory
+			if outputPath, err = decryptPFile(ctx, client, args[0], decryptOutput); err != nil {
+				return err
+			}
+		case pdfInput:
+			if outputPath, err = decryptPDF(ctx, client, args[0], decryptOutput); err != nil {
+				return err
+			}
+		default:
+			msg, err := e.Decrypt(ctx, client)
+			if err != nil {
+				return err
+			}
+			// Office documents decrypt to an OOXML package instead of a compound file
+			if msg.Package != nil {
+				if outputPath, err = writePackage(msg.Package, args[0], decryptOutput); err != nil {
+					return err
+				}
+			} else {
+				doc, err := msg.Reader()
+				if err != nil {
+					return err
+				}
+				if err := unpackCompound(doc, decryptOutput); err != nil {
+					return err
+				}
+			}
+		}
+
+		if abs, err := filepath.Abs(outputPath); err == nil {
+			outputPath = abs
+		}
+		fmt.Printf("Decrypted %s to %s\n", args[0], outputPath)
+		return nil
+	},
+}
+
+func init() {
+	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "decrypted", "Output directory for the decrypted contents")
+	decryptCmd.Flags().BoolVarP(&decryptRecursiveFlag, "recursive", "r", false, "Decrypt every protected file in a directory (and the ZIP archives in it), mirroring the tree in the output directory")
+	decryptCmd.Flags().IntVar(&decryptWorkers, "workers", runtime.NumCPU(), "Number of files decrypted concurrently with --recursive")
+	decryptCmd.Flags().StringVar(&decryptManifest, "manifest", "", "JSON-lines manifest of every file with --recursive (default is manifest.jsonl in the output directory)")
+	addClientFlags(decryptCmd.Flags())
+	rootCmd.AddCommand(decryptCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bored-engineer/rms/internal/rmstest"
+)
+
+// TestDecryptPFileEscape decrypts PFiles whose extension would write outside of the output directory
+func TestDecryptPFileEscape(t *testing.T) {
+	l := rmstest.NewLicensor(t, "MICROSOFT.CBC4K", 16)
+	for _, ext := range []string{"/../../escape", "/../escape.txt", "..", `.txt\..\..\escape`} {
+		root := t.TempDir()
+		input := filepath.Join(root, "in", "notes.ptxt")
+		if err := os.MkdirAll(filepath.Dir(input), 0755); err != nil {
+			t.Fatal(err)
+		}
+		b := rmstest.BuildPFile(t, l.Key, rmstest.PFile{Extension: ext, License: rmstest.License("notes")}, []byte("notes"))
+		if err := ioutil.WriteFile(input, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := filepath.Join(root, "out")
+		destPath, err := decryptPFile(context.Background(), l, input, dir)
+		if err != nil {
+			t.Fatalf("%q: %v", ext, err)
+		}
+		if want := filepath.Join(dir, "notes"); destPath != want {
+			t.Errorf("%q: wrote %s, want %s", ext, destPath, want)
+		}
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("%q: %d entries next to the output directory", ext, len(entries))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/bored-engineer/rms/message"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// printInfo prints the non-empty fields of info as text
+func printInfo(info *message.Info) {
+	for _, field := range []struct {
+		name, value string
+	}{
+		{"Format", info.Format},
+		{"Original extension", info.Extension},
+		{"Encrypted stream", info.Stream},
+		{"Content ID", info.ContentID},
+		{"Owner", info.Owner},
+		{"Issuer", info.IssuerName},
+		{"Issuer URL", info.IssuerURL},
+		{"Intranet URL", info.IntranetURL},
+		{"Extranet URL", info.ExtranetURL},
+		{"Template ID", info.TemplateID},
+		{"Label ID", info.LabelID},
+		{"Cipher mode", info.CipherMode},
+	} {
+		if field.value != "" {
+			fmt.Printf("%s: %s\n", field.name, field.value)
+		}
+	}
+}
+
+// inspectCmd represents the inspect command
+var inspectJSON bool
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [file]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Identify a protected file and print its metadata without contacting aadrm",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to read input file")
+		}
+		info, err := message.Inspect(b)
+		if err != nil {
+			return errors.Wrapf(err, "failed to inspect %s", args[0])
+		}
+		if !inspectJSON {
+			printInfo(info)
+			return nil
+		}
+		out, err := json.MarshalIndent(info, "", "\t")
+		if err != nil {
+			return errors.Wrap(err, "failed to encode info")
+		}
+		fmt.Println(string(out))
+		return nil
+	},
+}
+
+func init() {
+	inspectCmd.Flags().BoolVar(&inspectJSON, "json", false, "Print the metadata as JSON")
+	rootCmd.AddCommand(inspectCmd)
+}
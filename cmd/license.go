@@ -3,16 +3,17 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"strings"
-
-	"golang.org/x/oauth2"
 
 	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/adrms"
+	"github.com/bored-engineer/rms/message"
+	"github.com/bored-engineer/rms/seal"
+	"github.com/bored-engineer/rms/xrml"
 
 	"github.com/spf13/cobra"
 
@@ -20,9 +21,6 @@ import (
 )
 
 // licenseCmd represents the license command
-var licenseUserAgent string
-var licensePlatformID string
-var licenseInsecure bool
 var licenseCmd = &cobra.Command{
 	Use:   "license",
 	Short: "Commmands to interact with licenses and aadrm",
@@ -34,58 +32,84 @@ var licenseShowCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Short: "Print the contents of a user license",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		f, err := os.Open(args[0])
+		userLicense, err := readLicense(args[0])
 		if err != nil {
-			return errors.Wrapf(err, "failed to open license file %s", args[0])
+			return err
 		}
-		defer f.Close()
 
-		userLicense, err := aadrm.DecodeEndUserLicense(f)
+		fmt.Println(userLicense.String())
+		return nil
+	},
+}
+
+// licenseParseCmd represents the parse command on license
+var licenseParseFull bool
+var licenseParseCmd = &cobra.Command{
+	Use:   "parse [content.license]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Print the contents of a publishing license without contacting aadrm",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		license, err := ioutil.ReadFile(args[0])
 		if err != nil {
-			return errors.Wrap(err, "failed to decode license")
+			return errors.Wrapf(err, "failed to read license file %s", args[0])
 		}
 
-		fmt.Println(userLicense.String())
+		pl, err := xrml.Parse(license)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse license")
+		}
+
+		if licenseParseFull {
+			fmt.Println(pl.String())
+			return nil
+		}
+		fmt.Printf("Content ID: %s\n", pl.ContentID())
+		fmt.Printf("Owner: %s\n", pl.Owner())
+		if issuer := pl.Issuer(); issuer != nil {
+			fmt.Printf("Issuer: %s (%s)\n", issuer.Name, issuer.URL())
+		}
+		fmt.Printf("Intranet URL: %s\n", pl.IntranetURL())
+		fmt.Printf("Extranet URL: %s\n", pl.ExtranetURL())
 		return nil
 	},
 }
 
+// redactKey returns a copy of l without the value of the content key
+func redactKey(l *aadrm.EndUserLicense) *aadrm.EndUserLicense {
+	redacted := *l
+	if l.Key != nil {
+		key := *l.Key
+		value := "REDACTED"
+		key.Value = &value
+		redacted.Key = &key
+	}
+	return &redacted
+}
+
 // licenseFetchCmd represents the fetch command on license
 var licenseFetchOutput string
 var licenseFetchCmd = &cobra.Command{
 	Use:   "fetch [access_token] [content.license]",
-	Args:  cobra.ExactArgs(2),
-	Short: "Fetch a user license using access_token",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Fetch a user license using access_token (or the token from rms login) or from AD RMS",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := context.Background()
+		accessToken, args := tokenArgs(args, 1)
 
 		// Create the client
-		client := aadrm.NewClient(&http.Client{
-			Transport: &oauth2.Transport{
-				Source: oauth2.StaticTokenSource(&oauth2.Token{
-					AccessToken: strings.TrimSpace(args[0]),
-				}),
-				Base: &http.Transport{
-					Proxy: http.ProxyFromEnvironment,
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: licenseInsecure,
-						RootCAs: aadrm.NewCertPool(),
-					},
-				},
-			},
-		})
-		client.RMSPlatformID = licensePlatformID
-		client.UserAgent = licenseUserAgent
+		client, err := newLicensor(ctx, accessToken)
+		if err != nil {
+			return err
+		}
 
 		// Read in the file and find the start (sometimes there's a random prefix)
-		license, err := ioutil.ReadFile(args[1])
+		license, err := ioutil.ReadFile(args[0])
 		if err != nil {
-			return errors.Wrapf(err, "failed to read license file %s", args[1])
+			return errors.Wrapf(err, "failed to read license file %s", args[0])
 		}
-		if idx := bytes.Index(license, []byte("<?xml")); idx == -1 {
-			return errors.New("license does not have xml prefix")
-		} else if idx > 0 {
-			license = license[idx:]
+		license, err = message.TrimLicense(license)
+		if err != nil {
+			return err
 		}
 
 		// Make the request
@@ -94,9 +118,28 @@ var licenseFetchCmd = &cobra.Command{
 			return errors.Wrap(err, "failed to request EndUserLicense")
 		}
 
-		// Print the license and write it to a file
-		fmt.Println(userLicense.String())
-		if err := ioutil.WriteFile(licenseFetchOutput, rawLicense, 0644); err != nil {
+		// AD RMS returns an XrML use license, store the decrypted license in the aadrm format instead
+		if _, ok := client.(*adrms.Client); ok {
+			if rawLicense, err = json.Marshal(userLicense); err != nil {
+				return errors.Wrap(err, "failed to encode license")
+			}
+		}
+
+		// Print the license and write it to a file (sealed if configured), it contains the content key
+		// so the key is redacted from the output when sealing
+		sealer, err := newSealer()
+		if err != nil {
+			return err
+		}
+		if sealer != nil {
+			fmt.Println(redactKey(userLicense).String())
+			if rawLicense, err = sealer.Seal(rawLicense); err != nil {
+				return errors.Wrap(err, "failed to seal license")
+			}
+		} else {
+			fmt.Println(userLicense.String())
+		}
+		if err := ioutil.WriteFile(licenseFetchOutput, rawLicense, 0600); err != nil {
 			return errors.Wrap(err, "failed to write license")
 		}
 
@@ -107,48 +150,77 @@ var licenseFetchCmd = &cobra.Command{
 // licenseDecryptCmd represents the fetch command on license
 var licenseDecryptOutput string
 var licenseDecryptCmd = &cobra.Command{
-	Use:   "decrypt [user.license] [content.encrypted]",
+	Use:   "decrypt [user.license] [DRMContent]",
 	Args:  cobra.ExactArgs(2),
-	Short: "Decrypt the contents of a file using the user license",
+	Short: "Decrypt a DRMContent (or EncryptedPackage) file using the user license",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		f, err := os.Open(args[0])
+		userLicense, err := readLicense(args[0])
 		if err != nil {
-			return errors.Wrapf(err, "failed to open license file %s", args[0])
+			return err
 		}
-		defer f.Close()
 
-		userLicense, err := aadrm.DecodeEndUserLicense(f)
+		input, err := os.Open(args[1])
 		if err != nil {
-			return errors.Wrap(err, "failed to decode license")
+			return errors.Wrapf(err, "failed to open encrypted file %s", args[1])
 		}
-
-		ciphertext, err := ioutil.ReadFile(args[1])
+		defer input.Close()
+		info, err := input.Stat()
 		if err != nil {
-			return errors.Wrapf(err, "failed to read license file %s", args[1])
+			return errors.Wrapf(err, "failed to stat encrypted file %s", args[1])
 		}
 
-		plaintext, err := userLicense.Key.Decrypt(ciphertext)
+		d, err := userLicense.Key.NewContentDecrypter(input, info.Size())
 		if err != nil {
 			return errors.Wrap(err, "failed to decrypt")
 		}
 
-		if err := ioutil.WriteFile(licenseDecryptOutput, plaintext, 0644); err != nil {
+		output, err := os.OpenFile(licenseDecryptOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open decrypted file %s", licenseDecryptOutput)
+		}
+		defer output.Close()
+		n, err := io.Copy(output, d)
+		if err != nil {
 			return errors.Wrapf(err, "failed to write decrypted file %s", licenseDecryptOutput)
 		}
 
-		fmt.Printf("Decrypted %d bytes from %s\n", len(plaintext), args[1])
+		fmt.Printf("Decrypted %d bytes from %s\n", n, args[1])
 		return nil
 	},
 }
 
+// readLicense reads a user license file, opening it first if it is sealed
+func readLicense(name string) (*aadrm.EndUserLicense, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read license file %s", name)
+	}
+	if seal.IsSealed(b) {
+		sealer, err := newSealer()
+		if err != nil {
+			return nil, err
+		} else if sealer == nil {
+			return nil, errors.Errorf("license file %s is sealed, use --seal-key-file or --seal-passphrase-env", name)
+		}
+		if b, err = sealer.Open(b); err != nil {
+			return nil, errors.Wrapf(err, "failed to open license file %s", name)
+		}
+	}
+	userLicense, err := aadrm.DecodeEndUserLicense(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode license")
+	}
+	return userLicense, nil
+}
+
 func init() {
 	licenseCmd.AddCommand(licenseShowCmd)
+	licenseParseCmd.Flags().BoolVar(&licenseParseFull, "full", false, "Print every certificate in the license as JSON")
+	licenseCmd.AddCommand(licenseParseCmd)
 	licenseFetchCmd.Flags().StringVarP(&licenseFetchOutput, "output", "o", "user.license", "Output file for the user license")
 	licenseCmd.AddCommand(licenseFetchCmd)
 	licenseCmd.AddCommand(licenseDecryptCmd)
 	licenseDecryptCmd.Flags().StringVarP(&licenseDecryptOutput, "output", "o", "decrypted.compound", "Output file for the decryption")
-	licenseCmd.PersistentFlags().BoolVar(&licenseInsecure, "insecure", false, "Disable all x509/TLS verification")
-	licenseCmd.PersistentFlags().StringVarP(&licenseUserAgent, "user-agent", "u", "Outlook/16.35.20030802 CFNetwork/1121.1.2 Darwin/19.3.0 (x86_64)", "User Agent to present to aadrm")
-	licenseCmd.PersistentFlags().StringVarP(&licensePlatformID, "platform-id", "p", "AppName=com.microsoft.Outlook;AppVersion=16.35;DevicePlatform=Mac;OSVersion=10.15.3;SDKVersion=4.2.21;ClientID=00000000-0000-0000-0000-000000000000", "X-MS-RMS-Platform-Id to present to aadrm")
+	addClientFlags(licenseCmd.PersistentFlags())
 	rootCmd.AddCommand(licenseCmd)
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pkg/errors"
+)
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Args:  cobra.NoArgs,
+	Short: "Login to aadrm using the device code flow, the token is used when access_token is omitted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		store, err := newTokenStore()
+		if err != nil {
+			return err
+		}
+
+		authority, err := selectedAuthority()
+		if err != nil {
+			return err
+		}
+		tok, err := aadrm.DeviceLogin(ctx, aadrm.OAuth2Config(authority, loginClientID), func(da *oauth2.DeviceAuthResponse) error {
+			if da.VerificationURIComplete != "" {
+				fmt.Fprintf(os.Stderr, "To sign in, open %s\n", da.VerificationURIComplete)
+			} else {
+				fmt.Fprintf(os.Stderr, "To sign in, open %s and enter the code %s\n", da.VerificationURI, da.UserCode)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := store.Save(tok); err != nil {
+			return errors.Wrap(err, "failed to store token")
+		}
+		fmt.Printf("Logged in, token stored in %s\n", loginTokenFile)
+		return nil
+	},
+}
+
+func init() {
+	addLoginFlags(loginCmd.Flags())
+	addSealFlags(loginCmd.Flags())
+	rootCmd.AddCommand(loginCmd)
+}
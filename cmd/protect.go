@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/message"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pkg/errors"
+)
+
+// Shared by every command which protects content
+var protectTemplateID string
+var protectUsers []string
+var protectRights []string
+var protectCipherMode string
+var protectKeySize int
+
+// addProtectFlags registers the flags used by newPublishingLicenseRequest
+func addProtectFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVarP(&protectTemplateID, "template", "t", "", "ID of the template to protect with (see rms template list)")
+	flags.StringSliceVar(&protectUsers, "user", nil, "Users (or groups) to grant --rights to instead of using a template")
+	flags.StringSliceVar(&protectRights, "rights", []string{"VIEW"}, "Rights to grant to --user (ex: VIEW,EDIT,PRINT,OWNER)")
+	flags.StringVar(&protectCipherMode, "cipher-mode", message.DefaultCipherMode, "CipherMode of the content key (MICROSOFT.CBC4K, MICROSOFT.CBC512 or MICROSOFT.ECB)")
+	flags.IntVar(&protectKeySize, "key-size", message.DefaultKeySize, "Size of the content key in bytes (16 or 32)")
+}
+
+// newPublishingLicenseRequest creates the request for --template or --user and --rights
+func newPublishingLicenseRequest() (*aadrm.PublishingLicenseRequest, error) {
+	key, err := aadrm.GenerateKey(protectCipherMode, protectKeySize)
+	if err != nil {
+		return nil, err
+	}
+	req := &aadrm.PublishingLicenseRequest{Key: key}
+	switch {
+	case protectTemplateID != "" && len(protectUsers) > 0:
+		return nil, errors.New("--template and --user are mutually exclusive")
+	case protectTemplateID != "":
+		req.TemplateID = &protectTemplateID
+	case len(protectUsers) > 0:
+		rights := make([]string, len(protectRights))
+		for idx, right := range protectRights {
+			rights[idx] = strings.ToUpper(strings.TrimSpace(right))
+		}
+		req.Policy = &aadrm.Policy{
+			UserRights: []aadrm.UserRight{{
+				Users:  protectUsers,
+				Rights: rights,
+			}},
+		}
+	default:
+		return nil, errors.New("either --template or --user is required")
+	}
+	return req, nil
+}
+
+// protectCmd represents the protect command
+var protectOutput string
+var protectCmd = &cobra.Command{
+	Use:   "protect [access_token] [input]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Encrypt a file into a DataSpaces + DRMContent compound file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		accessToken, args := tokenArgs(args, 1)
+
+		plaintext, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to read input file %s", args[0])
+		}
+		req, err := newPublishingLicenseRequest()
+		if err != nil {
+			return err
+		}
+		client, err := newClient(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+		envelope, err := message.Protect(ctx, client, plaintext, req)
+		if err != nil {
+			return err
+		}
+
+		output, err := os.OpenFile(protectOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to open output file")
+		}
+		defer output.Close()
+		if err := envelope.WriteCompound(output); err != nil {
+			return errors.Wrap(err, "failed to write compound file")
+		}
+		fmt.Printf("Protected %s to %s\n", args[0], protectOutput)
+		return nil
+	},
+}
+
+func init() {
+	protectCmd.Flags().StringVarP(&protectOutput, "output", "o", "protected.compound", "Output file for the protected compound file")
+	addProtectFlags(protectCmd)
+	addClientFlags(protectCmd.Flags())
+	rootCmd.AddCommand(protectCmd)
+}
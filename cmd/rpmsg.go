@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/mail"
 	"os"
 
+	"github.com/bored-engineer/rms/message"
+	"github.com/bored-engineer/rms/outlook"
 	"github.com/bored-engineer/rms/rpmsg"
 
 	"github.com/spf13/cobra"
@@ -48,8 +54,184 @@ var rpmsgDecodeCmd = &cobra.Command{
 	},
 }
 
+// rpmsgEncodeCmd represents the encode command on rpmsg
+var rpmsgEncodeOutput string
+var rpmsgEncodeCmd = &cobra.Command{
+	Use:   "encode [file.compound]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Encode a raw compound file into a rpmsg file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to open input file")
+		}
+		defer input.Close()
+		output, err := os.OpenFile(rpmsgEncodeOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to open output file")
+		}
+		defer output.Close()
+		w, err := rpmsg.NewWriter(output)
+		if err != nil {
+			return errors.Wrap(err, "failed to start rpmsg writer")
+		}
+		n, err := io.Copy(w, input)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode")
+		}
+		if err := w.Close(); err != nil {
+			return errors.Wrap(err, "failed to flush rpmsg writer")
+		}
+		fmt.Printf("Encoded %d bytes from compound file to: %s\n", n, rpmsgEncodeOutput)
+		return nil
+	},
+}
+
+// rpmsgExtractCmd represents the extract command on rpmsg
+var rpmsgExtractOutput string
+var rpmsgExtractCmd = &cobra.Command{
+	Use:   "extract [message.msg|message.eml]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Extract the message.rpmsg attachment from an Outlook .msg or RFC 5322 message",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to read input file")
+		}
+		w, err := outlook.Read(b)
+		if err != nil {
+			return errors.Wrap(err, "failed to extract rpmsg from wrapper")
+		}
+		if err := ioutil.WriteFile(rpmsgExtractOutput, w.RPMSG, 0644); err != nil {
+			return errors.Wrap(err, "failed to write output file")
+		}
+		fmt.Printf("Extracted %d bytes to rpmsg file: %s\n", len(w.RPMSG), rpmsgExtractOutput)
+		return nil
+	},
+}
+
+// rpmsgToEMLCmd represents the to-eml command on rpmsg
+var rpmsgToEMLOutput string
+var rpmsgToEMLCmd = &cobra.Command{
+	Use:   "to-eml [access_token] [message.rpmsg|message.msg|message.eml]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Decrypt a rpmsg file and convert it to a RFC 5322 (.eml) message",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		accessToken, args := tokenArgs(args, 1)
+
+		input, header, err := openRPMSG(args[0])
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		client, err := newLicensor(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+		msg, err := message.Open(ctx, input, client)
+		if err != nil {
+			return err
+		}
+		content, err := msg.Content()
+		if err != nil {
+			return errors.Wrap(err, "failed to parse decrypted message")
+		}
+
+		output, err := os.OpenFile(rpmsgToEMLOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to open output file")
+		}
+		defer output.Close()
+		if err := content.WriteEML(output, header); err != nil {
+			return errors.Wrap(err, "failed to write eml")
+		}
+		fmt.Printf("Converted %s with %d attachment(s) to: %s\n", args[0], len(content.Attachments), rpmsgToEMLOutput)
+		return nil
+	},
+}
+
+// openRPMSG opens a rpmsg file, extracting it from a .msg or .eml wrapper (and returning its header) if needed,
+// a raw rpmsg is streamed from the file instead of being read into memory
+func openRPMSG(name string) (io.ReadCloser, mail.Header, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open input file")
+	}
+	// The prefix only needs to be long enough for the rpmsg magic
+	var prefix [16]byte
+	n, _ := f.ReadAt(prefix[:], 0)
+	if rpmsg.IsRPMSG(prefix[:n]) {
+		return f, nil, nil
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read input file")
+	}
+	w, err := outlook.Read(b)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to extract rpmsg from wrapper")
+	}
+	return ioutil.NopCloser(bytes.NewReader(w.RPMSG)), w.Header, nil
+}
+
+// rpmsgProtectCmd represents the protect command on rpmsg
+var rpmsgProtectOutput string
+var rpmsgProtectCmd = &cobra.Command{
+	Use:   "protect [access_token] [message.eml]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Protect a RFC 5322 (.eml) message, producing a message with message.rpmsg attached",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		accessToken, args := tokenArgs(args, 1)
+
+		input, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to open input file %s", args[0])
+		}
+		defer input.Close()
+
+		req, err := newPublishingLicenseRequest()
+		if err != nil {
+			return err
+		}
+		client, err := newClient(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+		wrapper, err := message.ProtectEML(ctx, client, input, req)
+		if err != nil {
+			return err
+		}
+
+		output, err := os.OpenFile(rpmsgProtectOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to open output file")
+		}
+		defer output.Close()
+		if err := wrapper.WriteMIME(output); err != nil {
+			return errors.Wrap(err, "failed to write protected message")
+		}
+		fmt.Printf("Protected %s to %s\n", args[0], rpmsgProtectOutput)
+		return nil
+	},
+}
+
 func init() {
 	rpmsgDecodeCmd.Flags().StringVarP(&rpmsgDecodeOutput, "output", "o", "rpmsg.compound", "Output file for the decoded file")
 	rpmsgCmd.AddCommand(rpmsgDecodeCmd)
+	rpmsgEncodeCmd.Flags().StringVarP(&rpmsgEncodeOutput, "output", "o", "message.rpmsg", "Output file for the encoded file")
+	rpmsgCmd.AddCommand(rpmsgEncodeCmd)
+	rpmsgExtractCmd.Flags().StringVarP(&rpmsgExtractOutput, "output", "o", "message.rpmsg", "Output file for the extracted rpmsg")
+	rpmsgCmd.AddCommand(rpmsgExtractCmd)
+	rpmsgToEMLCmd.Flags().StringVarP(&rpmsgToEMLOutput, "output", "o", "message.eml", "Output file for the converted message")
+	addClientFlags(rpmsgToEMLCmd.Flags())
+	rpmsgCmd.AddCommand(rpmsgToEMLCmd)
+	rpmsgProtectCmd.Flags().StringVarP(&rpmsgProtectOutput, "output", "o", "protected.eml", "Output file for the protected message")
+	addProtectFlags(rpmsgProtectCmd)
+	addClientFlags(rpmsgProtectCmd.Flags())
+	rpmsgCmd.AddCommand(rpmsgProtectCmd)
 	rootCmd.AddCommand(rpmsgCmd)
 }
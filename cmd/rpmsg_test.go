@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bored-engineer/rms/dataspaces"
+	"github.com/bored-engineer/rms/internal/rmstest"
+	"github.com/bored-engineer/rms/message"
+	"github.com/bored-engineer/rms/outlook"
+)
+
+// TestOpenRPMSG checks that a raw rpmsg is streamed from the file and a wrapper is unwrapped
+func TestOpenRPMSG(t *testing.T) {
+	dir := t.TempDir()
+	e := &message.Envelope{PublishingLicense: rmstest.License("message"), Content: []byte("encrypted"), Stream: dataspaces.Content}
+	var raw, eml bytes.Buffer
+	if err := e.WriteRPMSG(&raw); err != nil {
+		t.Fatal(err)
+	}
+	w := &outlook.Wrapper{Header: mail.Header{"Subject": {"Protected"}}, RPMSG: raw.Bytes()}
+	if err := w.WriteMIME(&eml); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"message.rpmsg": raw.Bytes(), "message.eml": eml.Bytes(), "short": []byte{0x76}, "text": []byte("not a message")}
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input, header, err := openRPMSG(filepath.Join(dir, "message.rpmsg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := input.(*os.File); !ok || header != nil {
+		t.Errorf("raw rpmsg was opened as a %T with header %v", input, header)
+	}
+	got, err := message.ReadEnvelope(input)
+	input.Close()
+	if err != nil || !bytes.Equal(got.Content, e.Content) {
+		t.Errorf("raw rpmsg: got %v (%v)", got, err)
+	}
+
+	input, header, err = openRPMSG(filepath.Join(dir, "message.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(input)
+	input.Close()
+	if !bytes.Equal(b, raw.Bytes()) || header.Get("Subject") != "Protected" {
+		t.Errorf("wrapped rpmsg: got %d bytes with header %v", len(b), header)
+	}
+
+	for _, name := range []string{"short", "text", "missing"} {
+		if _, _, err := openRPMSG(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// openEnvelope reads Office documents from the file and falls back to openRPMSG
+	var document bytes.Buffer
+	doc := &message.Envelope{PublishingLicense: rmstest.License("document"), Content: []byte("encrypted"), Stream: dataspaces.EncryptedPackageStream}
+	if err := doc.WriteCompound(&document); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "document.docx"), document.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"document.docx": dataspaces.EncryptedPackageStream, "message.rpmsg": dataspaces.Content, "message.eml": dataspaces.Content} {
+		if got, err := openEnvelope(filepath.Join(dir, name)); err != nil || got.Stream != want {
+			t.Errorf("%s: got %v (%v)", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pkg/errors"
+)
+
+// templateCmd represents the template command
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Commands to interact with protection templates",
+}
+
+// templateListCmd represents the list command on template
+var templateListCmd = &cobra.Command{
+	Use:   "list [access_token]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "List the protection templates available to the user",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		accessToken, _ := tokenArgs(args, 0)
+
+		client, err := newClient(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+		templates, _, err := client.ListTemplates(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to list templates")
+		}
+		b, err := json.MarshalIndent(templates, "", "\t")
+		if err != nil {
+			return errors.Wrap(err, "failed to encode templates")
+		}
+		fmt.Println(string(b))
+		return nil
+	},
+}
+
+func init() {
+	templateCmd.AddCommand(templateListCmd)
+	addClientFlags(templateCmd.PersistentFlags())
+	rootCmd.AddCommand(templateCmd)
+}
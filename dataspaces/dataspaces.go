@@ -0,0 +1,347 @@
+package dataspaces
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/richardlehane/mscfb"
+
+	"github.com/pkg/errors"
+)
+
+// Storage is the name of the storage holding the DataSpaces structures (the \x06 prefix is stripped by mscfb)
+const Storage = "DataSpaces"
+
+// Content is the path of the protected stream of a rpmsg message (the \x09 prefix is stripped by mscfb)
+const Content = "DRMContent"
+
+// IRMTransformID identifies the transform which wraps an XrML publishing license
+const IRMTransformID = "{C73DFACD-061F-43B0-8B64-0C620D2A8B50}"
+
+// Version is a major/minor version pair
+type Version struct {
+	Major uint16
+	Minor uint16
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// VersionInfo is the DataSpaces/Version stream (DataSpaceVersionInfo)
+type VersionInfo struct {
+	FeatureIdentifier string
+	Reader            Version
+	Updater           Version
+	Writer            Version
+}
+
+// ParseVersionInfo parses a DataSpaces/Version stream
+func ParseVersionInfo(b []byte) (*VersionInfo, error) {
+	d := &decoder{b: b}
+	var v VersionInfo
+	var err error
+	if v.FeatureIdentifier, err = d.unicodeP4(); err != nil {
+		return nil, errors.Wrap(err, "failed to read FeatureIdentifier")
+	}
+	if v.Reader, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read ReaderVersion")
+	}
+	if v.Updater, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read UpdaterVersion")
+	}
+	if v.Writer, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read WriterVersion")
+	}
+	return &v, nil
+}
+
+// Types of a ReferenceComponent
+const (
+	StreamComponent  = 0
+	StorageComponent = 1
+)
+
+// ReferenceComponent is a single storage or stream in the path of a MapEntry
+type ReferenceComponent struct {
+	Type uint32
+	Name string
+}
+
+// MapEntry associates a stream (or storage) with the data space which transforms it
+type MapEntry struct {
+	ReferenceComponents []ReferenceComponent
+	DataSpaceName       string
+}
+
+// Path returns the slash separated path of the referenced stream (or storage)
+func (e *MapEntry) Path() string {
+	names := make([]string, len(e.ReferenceComponents))
+	for i, rc := range e.ReferenceComponents {
+		names[i] = trimInitial(rc.Name)
+	}
+	return path.Join(names...)
+}
+
+// DataSpaceMap is the DataSpaces/DataSpaceMap stream
+type DataSpaceMap struct {
+	Entries []MapEntry
+}
+
+// ParseDataSpaceMap parses a DataSpaces/DataSpaceMap stream
+func ParseDataSpaceMap(b []byte) (*DataSpaceMap, error) {
+	d := &decoder{b: b}
+	headerLength, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read HeaderLength")
+	} else if headerLength != 8 {
+		return nil, errors.Errorf("unexpected HeaderLength %d", headerLength)
+	}
+	count, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read EntryCount")
+	}
+	var m DataSpaceMap
+	for i := uint32(0); i < count; i++ {
+		start := d.off
+		length, err := d.uint32()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read Length of entry %d", i)
+		}
+		rcCount, err := d.uint32()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read ReferenceComponentCount of entry %d", i)
+		}
+		var e MapEntry
+		for j := uint32(0); j < rcCount; j++ {
+			var rc ReferenceComponent
+			if rc.Type, err = d.uint32(); err != nil {
+				return nil, errors.Wrapf(err, "failed to read ReferenceComponentType of entry %d", i)
+			}
+			if rc.Name, err = d.unicodeP4(); err != nil {
+				return nil, errors.Wrapf(err, "failed to read ReferenceComponent of entry %d", i)
+			}
+			e.ReferenceComponents = append(e.ReferenceComponents, rc)
+		}
+		if e.DataSpaceName, err = d.unicodeP4(); err != nil {
+			return nil, errors.Wrapf(err, "failed to read DataSpaceName of entry %d", i)
+		}
+		if d.off-start != int(length) {
+			return nil, errors.Errorf("entry %d has Length %d but used %d bytes", i, length, d.off-start)
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	return &m, nil
+}
+
+// DataSpaceDefinition is a DataSpaces/DataSpaceInfo/* stream, it lists the transforms applied in order
+type DataSpaceDefinition struct {
+	TransformReferences []string
+}
+
+// ParseDataSpaceDefinition parses a DataSpaces/DataSpaceInfo/* stream
+func ParseDataSpaceDefinition(b []byte) (*DataSpaceDefinition, error) {
+	d := &decoder{b: b}
+	headerLength, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read HeaderLength")
+	} else if headerLength != 8 {
+		return nil, errors.Errorf("unexpected HeaderLength %d", headerLength)
+	}
+	count, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read TransformReferenceCount")
+	}
+	var def DataSpaceDefinition
+	for i := uint32(0); i < count; i++ {
+		ref, err := d.unicodeP4()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read TransformReference %d", i)
+		}
+		def.TransformReferences = append(def.TransformReferences, ref)
+	}
+	return &def, nil
+}
+
+// TransformInfo is the DataSpaces/TransformInfo/*/Primary stream
+type TransformInfo struct {
+	Type    uint32
+	ID      string
+	Name    string
+	Reader  Version
+	Updater Version
+	Writer  Version
+	// Data is the transform specific data following the header
+	Data []byte
+}
+
+// ParseTransformInfo parses a DataSpaces/TransformInfo/*/Primary stream
+func ParseTransformInfo(b []byte) (*TransformInfo, error) {
+	d := &decoder{b: b}
+	length, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read TransformLength")
+	}
+	var t TransformInfo
+	if t.Type, err = d.uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to read TransformType")
+	}
+	if t.ID, err = d.unicodeP4(); err != nil {
+		return nil, errors.Wrap(err, "failed to read TransformID")
+	}
+	// TransformLength covers everything before TransformName
+	if int(length) < d.off {
+		return nil, errors.Errorf("TransformLength %d is shorter than the header", length)
+	} else if err := d.need(int(length) - d.off); err != nil {
+		return nil, errors.Wrap(err, "failed to skip to TransformName")
+	}
+	d.off = int(length)
+	if t.Name, err = d.unicodeP4(); err != nil {
+		return nil, errors.Wrap(err, "failed to read TransformName")
+	}
+	if t.Reader, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read ReaderVersion")
+	}
+	if t.Updater, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read UpdaterVersion")
+	}
+	if t.Writer, err = d.version(); err != nil {
+		return nil, errors.Wrap(err, "failed to read WriterVersion")
+	}
+	t.Data = d.rest()
+	return &t, nil
+}
+
+// IsIRM reports if this is the IRMDS transform
+func (t *TransformInfo) IsIRM() bool {
+	return strings.EqualFold(t.ID, IRMTransformID)
+}
+
+// License returns the XrML publishing license wrapped by an IRMDS transform
+func (t *TransformInfo) License() ([]byte, error) {
+	if !t.IsIRM() {
+		return nil, errors.Errorf("transform %s is not an IRMDS transform", t.ID)
+	}
+	d := &decoder{b: t.Data}
+	// The ExtensibilityHeader is always empty
+	extensibility, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read ExtensibilityHeader")
+	} else if extensibility != 4 {
+		return nil, errors.Errorf("unexpected ExtensibilityHeader length %d", extensibility)
+	}
+	license, err := d.bytesP4()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read XrMLLicense")
+	}
+	return license, nil
+}
+
+// DataSpaces is every structure in the DataSpaces storage of a compound file
+type DataSpaces struct {
+	Version *VersionInfo
+	Map     *DataSpaceMap
+	// Definitions are keyed by data space name
+	Definitions map[string]*DataSpaceDefinition
+	// Transforms are keyed by transform name (the storage under TransformInfo)
+	Transforms map[string]*TransformInfo
+}
+
+// Protected is a stream protected by an IRMDS transform
+type Protected struct {
+	// Path is the slash separated path of the protected stream (ex: "DRMContent")
+	Path string
+	// DataSpaceName is the data space applied to the stream
+	DataSpaceName string
+	// Transform is the IRMDS transform holding the publishing license
+	Transform *TransformInfo
+}
+
+// Protected returns every stream with an IRMDS transform in its data space
+func (ds *DataSpaces) Protected() []Protected {
+	var protected []Protected
+	for _, e := range ds.Map.Entries {
+		def, ok := ds.Definitions[trimInitial(e.DataSpaceName)]
+		if !ok {
+			continue
+		}
+		for _, ref := range def.TransformReferences {
+			if t, ok := ds.Transforms[trimInitial(ref)]; ok && t.IsIRM() {
+				protected = append(protected, Protected{
+					Path:          e.Path(),
+					DataSpaceName: e.DataSpaceName,
+					Transform:     t,
+				})
+				break
+			}
+		}
+	}
+	return protected
+}
+
+// trimInitial strips a non-printable first character (ex: \x06) the same way mscfb does for entry names
+func trimInitial(name string) string {
+	if r, size := utf8.DecodeRuneInString(name); size > 0 && !unicode.IsPrint(r) {
+		return name[size:]
+	}
+	return name
+}
+
+// entryPath returns the slash separated path of a compound entry
+func entryPath(f *mscfb.File) string {
+	return path.Join(path.Join(f.Path...), f.Name)
+}
+
+// Find returns the entry at the slash separated path p
+func Find(doc *mscfb.Reader, p string) (*mscfb.File, error) {
+	for _, f := range doc.File {
+		if entryPath(f) == p {
+			return f, nil
+		}
+	}
+	return nil, errors.Errorf("compound file is missing entry %s", p)
+}
+
+// Read parses the DataSpaces storage of doc
+func Read(doc *mscfb.Reader) (*DataSpaces, error) {
+	ds := &DataSpaces{
+		Definitions: make(map[string]*DataSpaceDefinition),
+		Transforms:  make(map[string]*TransformInfo),
+	}
+	for _, f := range doc.File {
+		if len(f.Path) == 0 || f.Path[0] != Storage || f.Size == 0 {
+			continue
+		}
+		p := entryPath(f)
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read entry %s", p)
+		}
+		switch {
+		case len(f.Path) == 1 && f.Name == "Version":
+			if ds.Version, err = ParseVersionInfo(b); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", p)
+			}
+		case len(f.Path) == 1 && f.Name == "DataSpaceMap":
+			if ds.Map, err = ParseDataSpaceMap(b); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", p)
+			}
+		case len(f.Path) == 2 && f.Path[1] == "DataSpaceInfo":
+			if ds.Definitions[f.Name], err = ParseDataSpaceDefinition(b); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", p)
+			}
+		case len(f.Path) == 3 && f.Path[1] == "TransformInfo" && f.Name == "Primary":
+			if ds.Transforms[f.Path[2]], err = ParseTransformInfo(b); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", p)
+			}
+		}
+	}
+	if ds.Map == nil {
+		return nil, errors.Errorf("compound file is missing entry %s/DataSpaceMap", Storage)
+	}
+	return ds, nil
+}
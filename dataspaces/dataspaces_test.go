@@ -0,0 +1,218 @@
+package dataspaces
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/bored-engineer/rms/cfb"
+	"github.com/richardlehane/mscfb"
+)
+
+// fixture builds little-endian structures by hand, independently of the package's own encoder
+type fixture []byte
+
+func (f fixture) u16(v uint16) fixture {
+	return binary.LittleEndian.AppendUint16(f, v)
+}
+
+func (f fixture) u32(v uint32) fixture {
+	return binary.LittleEndian.AppendUint32(f, v)
+}
+
+// str appends a UNICODE-LP-P4 string
+func (f fixture) str(s string) fixture {
+	u := utf16.Encode([]rune(s))
+	f = f.u32(uint32(len(u) * 2))
+	for _, c := range u {
+		f = f.u16(c)
+	}
+	return append(f, make([]byte, len(u)*2%4)...)
+}
+
+func TestParseVersionInfo(t *testing.T) {
+	b := fixture{}.str("Microsoft.Container.DataSpaces").u16(1).u16(0).u16(1).u16(0).u16(1).u16(0)
+	v, err := ParseVersionInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.FeatureIdentifier != "Microsoft.Container.DataSpaces" || v.Reader.String() != "1.0" || v.Writer != (Version{1, 0}) {
+		t.Errorf("unexpected %+v", v)
+	}
+	if _, err := ParseVersionInfo(b[:len(b)-1]); err == nil {
+		t.Error("expected an error for a truncated stream")
+	}
+}
+
+func TestParseDataSpaceMap(t *testing.T) {
+	entry := fixture{}.u32(1).u32(StreamComponent).str("\x09DRMContent").str("\x09DRMDataSpace")
+	b := fixture{}.u32(8).u32(1).u32(uint32(len(entry) + 4))
+	b = append(b, entry...)
+	m, err := ParseDataSpaceMap(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(m.Entries))
+	}
+	if e := m.Entries[0]; e.Path() != "DRMContent" || e.DataSpaceName != "\x09DRMDataSpace" {
+		t.Errorf("unexpected %+v", e)
+	}
+
+	// The Length of each entry must match what it contains
+	bad := append(fixture{}, b...)
+	binary.LittleEndian.PutUint32(bad[8:], uint32(len(entry)))
+	if _, err := ParseDataSpaceMap(bad); err == nil {
+		t.Error("expected an error for a mismatched entry Length")
+	}
+	bad = append(fixture{}, b...)
+	binary.LittleEndian.PutUint32(bad, 12)
+	if _, err := ParseDataSpaceMap(bad); err == nil {
+		t.Error("expected an error for an unexpected HeaderLength")
+	}
+	if _, err := ParseDataSpaceMap(b[:len(b)-2]); err == nil {
+		t.Error("expected an error for a truncated stream")
+	}
+}
+
+func TestParseDataSpaceDefinition(t *testing.T) {
+	b := fixture{}.u32(8).u32(2).str("\x09DRMTransform").str("Other")
+	def, err := ParseDataSpaceDefinition(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def.TransformReferences) != 2 || def.TransformReferences[0] != "\x09DRMTransform" || def.TransformReferences[1] != "Other" {
+		t.Errorf("unexpected %+v", def)
+	}
+}
+
+func TestParseTransformInfo(t *testing.T) {
+	license := []byte(`<?xml version="1.0"?><XrML/>`)
+	header := fixture{}.u32(1).str(IRMTransformID)
+	b := fixture{}.u32(uint32(len(header) + 4))
+	b = append(b, header...)
+	b = b.str("Microsoft.Metadata.DRMTransform").u16(1).u16(0).u16(1).u16(0).u16(1).u16(0)
+	// IRMDS data: an empty ExtensibilityHeader then the license
+	b = b.u32(4).u32(uint32(len(license)))
+	b = append(b, license...)
+
+	tr, err := ParseTransformInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.IsIRM() || tr.Type != 1 || tr.Name != "Microsoft.Metadata.DRMTransform" {
+		t.Errorf("unexpected %+v", tr)
+	}
+	got, err := tr.License()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, license) {
+		t.Errorf("License() is %q", got)
+	}
+
+	// TransformLength may not point before the TransformID
+	bad := append(fixture{}, b...)
+	binary.LittleEndian.PutUint32(bad, 4)
+	if _, err := ParseTransformInfo(bad); err == nil {
+		t.Error("expected an error for a short TransformLength")
+	}
+	other := &TransformInfo{ID: "{00000000-0000-0000-0000-000000000000}"}
+	if _, err := other.License(); err == nil {
+		t.Error("expected an error for a transform which is not IRMDS")
+	}
+}
+
+func TestProtected(t *testing.T) {
+	irm := &TransformInfo{ID: IRMTransformID}
+	ds := &DataSpaces{
+		Map: &DataSpaceMap{Entries: []MapEntry{
+			{ReferenceComponents: []ReferenceComponent{{Type: StreamComponent, Name: "\x09DRMContent"}}, DataSpaceName: "\x09DRMDataSpace"},
+			{ReferenceComponents: []ReferenceComponent{{Type: StreamComponent, Name: "Plain"}}, DataSpaceName: "Compressed"},
+		}},
+		Definitions: map[string]*DataSpaceDefinition{
+			"DRMDataSpace": {TransformReferences: []string{"\x09DRMTransform"}},
+			"Compressed":   {TransformReferences: []string{"Zip"}},
+		},
+		Transforms: map[string]*TransformInfo{
+			"DRMTransform": irm,
+			"Zip":          {ID: "{86DE7F2B-DDCE-486D-B016-405BBE82B8BC}"},
+		},
+	}
+	protected := ds.Protected()
+	if len(protected) != 1 || protected[0].Path != "DRMContent" || protected[0].Transform != irm {
+		t.Errorf("unexpected %+v", protected)
+	}
+}
+
+// TestBytesRoundTrip parses what each structure encodes
+func TestBytesRoundTrip(t *testing.T) {
+	ds := NewIRM(DRMContentStream, DRMDataSpace, DRMTransform, []byte("<XrML/>"))
+
+	v, err := ParseVersionInfo(ds.Version.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, ds.Version) {
+		t.Errorf("VersionInfo is %+v, want %+v", v, ds.Version)
+	}
+	m, err := ParseDataSpaceMap(ds.Map.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, ds.Map) {
+		t.Errorf("DataSpaceMap is %+v, want %+v", m, ds.Map)
+	}
+	def := ds.Definitions[DRMDataSpace]
+	if got, err := ParseDataSpaceDefinition(def.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, def) {
+		t.Errorf("DataSpaceDefinition is %+v, want %+v", got, def)
+	}
+	tr := ds.Transforms[DRMTransform]
+	got, err := ParseTransformInfo(tr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, tr) {
+		t.Errorf("TransformInfo is %+v, want %+v", got, tr)
+	}
+	if license, err := got.License(); err != nil || string(license) != "<XrML/>" {
+		t.Errorf("License() is %q %v", license, err)
+	}
+}
+
+// TestWriteRead writes an IRM DataSpaces storage to a compound file and reads it back
+func TestWriteRead(t *testing.T) {
+	license := []byte(`<?xml version="1.0"?><XrML/>`)
+	for _, tc := range [][3]string{
+		{DRMContentStream, DRMDataSpace, DRMTransform},
+		{EncryptedPackageStream, DRMEncryptedDataSpace, DRMEncryptedTransform},
+	} {
+		w := cfb.NewWriter()
+		if err := NewIRM(tc[0], tc[1], tc[2], license).Write(w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Create(tc[0], []byte("content")); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := mscfb.New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := Read(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protected := ds.Protected()
+		if len(protected) != 1 || protected[0].Path != trimInitial(tc[0]) {
+			t.Fatalf("%s: unexpected %+v", tc[0], protected)
+		}
+		if got, err := protected[0].Transform.License(); err != nil || !bytes.Equal(got, license) {
+			t.Errorf("%s: License() is %q %v", tc[0], got, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package dataspaces
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// decoder reads the little-endian primitives used by the DataSpaces structures
+type decoder struct {
+	b   []byte
+	off int
+}
+
+// need checks that n more bytes are available
+func (d *decoder) need(n int) error {
+	if n < 0 || len(d.b)-d.off < n {
+		return errors.Errorf("need %d bytes at offset %d but only have %d", n, d.off, len(d.b)-d.off)
+	}
+	return nil
+}
+
+func (d *decoder) uint16() (uint16, error) {
+	if err := d.need(2); err != nil {
+		return 0, err
+	}
+	v := binary.LittleEndian.Uint16(d.b[d.off:])
+	d.off += 2
+	return v, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.need(4); err != nil {
+		return 0, err
+	}
+	v := binary.LittleEndian.Uint32(d.b[d.off:])
+	d.off += 4
+	return v, nil
+}
+
+// bytesP4 reads a length-prefixed byte array padded to a multiple of 4 bytes
+func (d *decoder) bytesP4() ([]byte, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read length")
+	}
+	if err := d.need(int(n)); err != nil {
+		return nil, err
+	}
+	b := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	// Padding is optional at the very end of a stream
+	if pad := (4 - int(n)%4) % 4; len(d.b)-d.off >= pad {
+		d.off += pad
+	}
+	return b, nil
+}
+
+// unicodeP4 reads a UNICODE-LP-P4 string
+func (d *decoder) unicodeP4() (string, error) {
+	b, err := d.bytesP4()
+	if err != nil {
+		return "", err
+	}
+	if len(b)%2 != 0 {
+		return "", errors.Errorf("odd length %d for UTF-16 string", len(b))
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// version reads a major/minor version pair
+func (d *decoder) version() (Version, error) {
+	major, err := d.uint16()
+	if err != nil {
+		return Version{}, err
+	}
+	minor, err := d.uint16()
+	if err != nil {
+		return Version{}, err
+	}
+	return Version{Major: major, Minor: minor}, nil
+}
+
+// rest returns any unread bytes
+func (d *decoder) rest() []byte {
+	return d.b[d.off:]
+}
@@ -0,0 +1,42 @@
+package dataspaces
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// encoder writes the little-endian primitives used by the DataSpaces structures
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.b = binary.LittleEndian.AppendUint16(e.b, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.b = binary.LittleEndian.AppendUint32(e.b, v)
+}
+
+// bytesP4 writes a length-prefixed byte array padded to a multiple of 4 bytes
+func (e *encoder) bytesP4(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.b = append(e.b, b...)
+	e.b = append(e.b, make([]byte, (4-len(b)%4)%4)...)
+}
+
+// unicodeP4 writes a UNICODE-LP-P4 string
+func (e *encoder) unicodeP4(s string) {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	e.bytesP4(b)
+}
+
+// version writes a major/minor version pair
+func (e *encoder) version(v Version) {
+	e.uint16(v.Major)
+	e.uint16(v.Minor)
+}
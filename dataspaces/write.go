@@ -0,0 +1,161 @@
+package dataspaces
+
+import (
+	"path"
+
+	"github.com/bored-engineer/rms/cfb"
+
+	"github.com/pkg/errors"
+)
+
+// Names used when writing, unlike mscfb the \x06 (and \x09) prefixes are part of the entry names
+const (
+	storageName       = "\x06" + Storage
+	primaryStreamName = "\x06Primary"
+)
+
+// FeatureIdentifier of the DataSpaces/Version stream
+const FeatureIdentifier = "Microsoft.Container.DataSpaces"
+
+// IRMTransformName is the name of the IRMDS transform in its TransformInfo
+const IRMTransformName = "Microsoft.Metadata.DRMTransform"
+
+// Names of the entries protecting an rpmsg message (MS-OXORMMS)
+const (
+	DRMContentStream = "\x09DRMContent"
+	DRMDataSpace     = "\x09DRMDataSpace"
+	DRMTransform     = "\x09DRMTransform"
+)
+
+// Names of the entries protecting an Office document (MS-OFFCRYPTO)
+const (
+	EncryptedPackageStream = "EncryptedPackage"
+	DRMEncryptedDataSpace  = "DRMEncryptedDataSpace"
+	DRMEncryptedTransform  = "DRMEncryptedTransform"
+)
+
+// currentVersion is used for every version written
+var currentVersion = Version{Major: 1, Minor: 0}
+
+// Bytes encodes a DataSpaces/Version stream
+func (v *VersionInfo) Bytes() []byte {
+	e := &encoder{}
+	e.unicodeP4(v.FeatureIdentifier)
+	e.version(v.Reader)
+	e.version(v.Updater)
+	e.version(v.Writer)
+	return e.b
+}
+
+// Bytes encodes a DataSpaces/DataSpaceMap stream
+func (m *DataSpaceMap) Bytes() []byte {
+	e := &encoder{}
+	e.uint32(8)
+	e.uint32(uint32(len(m.Entries)))
+	for _, entry := range m.Entries {
+		me := &encoder{}
+		me.uint32(uint32(len(entry.ReferenceComponents)))
+		for _, rc := range entry.ReferenceComponents {
+			me.uint32(rc.Type)
+			me.unicodeP4(rc.Name)
+		}
+		me.unicodeP4(entry.DataSpaceName)
+		// Length includes itself
+		e.uint32(uint32(len(me.b) + 4))
+		e.b = append(e.b, me.b...)
+	}
+	return e.b
+}
+
+// Bytes encodes a DataSpaces/DataSpaceInfo/* stream
+func (def *DataSpaceDefinition) Bytes() []byte {
+	e := &encoder{}
+	e.uint32(8)
+	e.uint32(uint32(len(def.TransformReferences)))
+	for _, ref := range def.TransformReferences {
+		e.unicodeP4(ref)
+	}
+	return e.b
+}
+
+// Bytes encodes a DataSpaces/TransformInfo/*/Primary stream
+func (t *TransformInfo) Bytes() []byte {
+	header := &encoder{}
+	header.uint32(t.Type)
+	header.unicodeP4(t.ID)
+	e := &encoder{}
+	// TransformLength covers everything before TransformName
+	e.uint32(uint32(len(header.b) + 4))
+	e.b = append(e.b, header.b...)
+	e.unicodeP4(t.Name)
+	e.version(t.Reader)
+	e.version(t.Updater)
+	e.version(t.Writer)
+	e.b = append(e.b, t.Data...)
+	return e.b
+}
+
+// NewIRMTransform creates the IRMDS transform wrapping an XrML publishing license
+func NewIRMTransform(license []byte) *TransformInfo {
+	e := &encoder{}
+	// The ExtensibilityHeader is always empty
+	e.uint32(4)
+	e.bytesP4(license)
+	return &TransformInfo{
+		Type:    1,
+		ID:      IRMTransformID,
+		Name:    IRMTransformName,
+		Reader:  currentVersion,
+		Updater: currentVersion,
+		Writer:  currentVersion,
+		Data:    e.b,
+	}
+}
+
+// NewIRM creates the DataSpaces protecting the root stream named stream with license,
+// dataSpace and transform name the data space and transform (ex: DRMDataSpace and DRMTransform)
+func NewIRM(stream string, dataSpace string, transform string, license []byte) *DataSpaces {
+	return &DataSpaces{
+		Version: &VersionInfo{
+			FeatureIdentifier: FeatureIdentifier,
+			Reader:            currentVersion,
+			Updater:           currentVersion,
+			Writer:            currentVersion,
+		},
+		Map: &DataSpaceMap{
+			Entries: []MapEntry{{
+				ReferenceComponents: []ReferenceComponent{{Type: StreamComponent, Name: stream}},
+				DataSpaceName:       dataSpace,
+			}},
+		},
+		Definitions: map[string]*DataSpaceDefinition{
+			dataSpace: {TransformReferences: []string{transform}},
+		},
+		Transforms: map[string]*TransformInfo{
+			transform: NewIRMTransform(license),
+		},
+	}
+}
+
+// Write adds the DataSpaces storage to w, the keys of Definitions and Transforms are used as entry names as is
+func (ds *DataSpaces) Write(w *cfb.Writer) error {
+	if ds.Version == nil || ds.Map == nil {
+		return errors.New("DataSpaces must have a Version and Map")
+	}
+	streams := map[string][]byte{
+		path.Join(storageName, "Version"):      ds.Version.Bytes(),
+		path.Join(storageName, "DataSpaceMap"): ds.Map.Bytes(),
+	}
+	for name, def := range ds.Definitions {
+		streams[path.Join(storageName, "DataSpaceInfo", name)] = def.Bytes()
+	}
+	for name, t := range ds.Transforms {
+		streams[path.Join(storageName, "TransformInfo", name, primaryStreamName)] = t.Bytes()
+	}
+	for p, b := range streams {
+		if err := w.Create(p, b); err != nil {
+			return errors.Wrapf(err, "failed to create %s", p)
+		}
+	}
+	return nil
+}
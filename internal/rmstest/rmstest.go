@@ -0,0 +1,130 @@
+// Package rmstest provides a fake aadrm.Licensor and fixture builders for testing the packages
+// which decrypt protected files.
+package rmstest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+)
+
+// License returns a publishing license for contentID
+func License(contentID string) []byte {
+	return []byte(`<?xml version="1.0"?><XrML><BODY type="Microsoft Rights Label"><WORK><OBJECT><ID type="MS-GUID">{` + contentID + `}</ID></OBJECT></WORK></BODY></XrML>`)
+}
+
+// Licensor issues Key for every publishing license made by License except the content IDs in Denied,
+// it counts the requests for each content ID
+type Licensor struct {
+	Key *aadrm.Key
+	// Denied content IDs fail with aadrm.ErrAccessDenied
+	Denied map[string]bool
+	// Failures is the number of requests which fail transiently before any succeed
+	Failures int32
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// NewLicensor creates a *Licensor with a new key for cipherMode of size bytes
+func NewLicensor(t testing.TB, cipherMode string, size int) *Licensor {
+	t.Helper()
+	key, err := aadrm.GenerateKey(cipherMode, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Licensor{Key: key, Denied: make(map[string]bool)}
+}
+
+// GetEndUserLicense implements aadrm.Licensor
+func (l *Licensor) GetEndUserLicense(ctx context.Context, license []byte) (*aadrm.EndUserLicense, []byte, *http.Response, error) {
+	id := aadrm.LicenseKey(license)
+	l.mu.Lock()
+	if l.calls == nil {
+		l.calls = make(map[string]int)
+	}
+	l.calls[id]++
+	l.mu.Unlock()
+	if !strings.HasPrefix(id, "{") {
+		return nil, nil, nil, errors.New("unexpected publishing license")
+	}
+	if atomic.AddInt32(&l.Failures, -1) >= 0 {
+		return nil, nil, nil, errors.New("service unavailable")
+	}
+	if l.Denied[strings.Trim(id, "{}")] {
+		return nil, nil, nil, aadrm.ErrAccessDenied
+	}
+	return &aadrm.EndUserLicense{Key: l.Key}, nil, nil, nil
+}
+
+// Calls returns the number of requests made for contentID
+func (l *Licensor) Calls(contentID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls["{"+contentID+"}"]
+}
+
+// PFile describes a PFile for BuildPFile, the zero value is a version 2 PFile without an extension
+type PFile struct {
+	MajorVersion uint32
+	Redirect     string
+	Extension    string
+	License      []byte
+	// Metadata is only written as a field by version 3, earlier versions leave it as padding
+	Metadata []byte
+}
+
+// BuildPFile encrypts plaintext with key into a PFile, the fields follow the fixed header in order
+func BuildPFile(t testing.TB, key *aadrm.Key, p PFile, plaintext []byte) []byte {
+	t.Helper()
+	ciphertext, err := key.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	major := p.MajorVersion
+	if major == 0 {
+		major = 2
+	}
+	magic := ".pfile"
+
+	fixed := len(magic) + 4*3 + len(p.Redirect) + 4*6 + 8
+	if major >= 3 {
+		fixed += 4 * 2
+	}
+	extensionOffset := fixed
+	licenseOffset := extensionOffset + len(p.Extension)
+	metadataOffset := licenseOffset + len(p.License)
+	contentOffset := metadataOffset + len(p.Metadata)
+
+	b := []byte(magic)
+	u32 := func(v int) { b = binary.LittleEndian.AppendUint32(b, uint32(v)) }
+	u32(int(major))
+	u32(0)
+	u32(len(p.Redirect))
+	b = append(b, p.Redirect...)
+	u32(fixed)
+	u32(extensionOffset)
+	u32(len(p.Extension))
+	u32(licenseOffset)
+	u32(len(p.License))
+	if major >= 3 {
+		u32(metadataOffset)
+		u32(len(p.Metadata))
+	}
+	u32(contentOffset)
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(plaintext)))
+	if len(b) != fixed {
+		t.Fatalf("fixed header is %d bytes, expected %d", len(b), fixed)
+	}
+	b = append(b, p.Extension...)
+	b = append(b, p.License...)
+	b = append(b, p.Metadata...)
+	return append(b, ciphertext...)
+}
@@ -0,0 +1,192 @@
+package message
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// attachDesc is the (partially parsed) AttachDesc stream of an attachment storage
+type attachDesc struct {
+	LongPathName string
+	PathName     string
+	DisplayName  string
+	LongFileName string
+	FileName     string
+	Extension    string
+	ContentID    string
+	// Unicode variants, only present in newer versions
+	UnicodeLongFileName string
+	UnicodeDisplayName  string
+}
+
+// name returns the best available file name of the attachment
+func (d *attachDesc) name() string {
+	for _, name := range []string{d.UnicodeLongFileName, d.LongFileName, d.UnicodeDisplayName, d.DisplayName, d.FileName} {
+		if name != "" {
+			return name
+		}
+	}
+	if d.Extension != "" {
+		return "attachment" + d.Extension
+	}
+	return ""
+}
+
+// descReader reads the primitives used by AttachDesc
+type descReader struct {
+	b   []byte
+	err error
+}
+
+func (r *descReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if len(r.b) < n {
+		r.err = errors.Errorf("need %d bytes but only have %d", n, len(r.b))
+		return
+	}
+	r.b = r.b[n:]
+}
+
+// ansi reads a string prefixed by a 1 byte length (which counts the NUL terminator)
+func (r *descReader) ansi() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.b) < 1 || len(r.b) < 1+int(r.b[0]) {
+		r.err = errors.New("truncated ANSI string")
+		return ""
+	}
+	s := r.b[1 : 1+int(r.b[0])]
+	r.b = r.b[1+int(r.b[0]):]
+	for len(s) > 0 && s[len(s)-1] == 0 {
+		s = s[:len(s)-1]
+	}
+	return string(s)
+}
+
+// unicode reads a UTF-16 string prefixed by a 1 byte character count (which counts the NUL terminator)
+func (r *descReader) unicode() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.b) < 1 || len(r.b) < 1+2*int(r.b[0]) {
+		r.err = errors.New("truncated Unicode string")
+		return ""
+	}
+	u := make([]uint16, int(r.b[0]))
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(r.b[1+2*i:])
+	}
+	r.b = r.b[1+2*len(u):]
+	for len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+// parseAttachDesc parses an AttachDesc stream, the Unicode names are best effort
+func parseAttachDesc(b []byte) (*attachDesc, error) {
+	r := &descReader{b: b}
+	var d attachDesc
+	// Version
+	r.skip(2)
+	d.LongPathName = r.ansi()
+	d.PathName = r.ansi()
+	d.DisplayName = r.ansi()
+	d.LongFileName = r.ansi()
+	d.FileName = r.ansi()
+	d.Extension = r.ansi()
+	// FileTimeCreated, FileTimeModified, AttachMethod
+	r.skip(8 + 8 + 4)
+	d.ContentID = r.ansi()
+	// ContentLocation
+	r.ansi()
+	// RenderingPosition, Flags
+	r.skip(2 + 4)
+	if r.err != nil {
+		return nil, r.err
+	}
+	// Older versions stop here, ignore any failures parsing the Unicode names
+	// UnicodeLongPathName, UnicodePathName
+	r.unicode()
+	r.unicode()
+	displayName := r.unicode()
+	longFileName := r.unicode()
+	if r.err == nil {
+		d.UnicodeDisplayName = displayName
+		d.UnicodeLongFileName = longFileName
+	}
+	return &d, nil
+}
+
+// attachDescVersion is written by Outlook versions which include the Unicode names
+const attachDescVersion = 0x0203
+
+// attachByValue is the AttachMethod of an attachment stored in AttachContents
+const attachByValue = 1
+
+// descWriter writes the primitives used by AttachDesc
+type descWriter struct {
+	b []byte
+}
+
+// ansi writes a string prefixed by a 1 byte length (which counts the NUL terminator), non-ASCII is replaced
+func (w *descWriter) ansi(s string) {
+	b := make([]byte, 0, len(s)+1)
+	for _, r := range s {
+		if r > 0x7F {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	if len(b) > 0xFE {
+		b = b[:0xFE]
+	}
+	w.b = append(w.b, byte(len(b)+1))
+	w.b = append(w.b, b...)
+	w.b = append(w.b, 0)
+}
+
+// unicode writes a UTF-16 string prefixed by a 1 byte character count (which counts the NUL terminator)
+func (w *descWriter) unicode(s string) {
+	u := utf16.Encode([]rune(s))
+	if len(u) > 0xFE {
+		u = u[:0xFE]
+	}
+	w.b = append(w.b, byte(len(u)+1))
+	for _, c := range u {
+		w.b = binary.LittleEndian.AppendUint16(w.b, c)
+	}
+	w.b = append(w.b, 0, 0)
+}
+
+// bytes encodes an AttachDesc stream, the inverse of parseAttachDesc
+func (d *attachDesc) bytes() []byte {
+	w := &descWriter{}
+	w.b = binary.LittleEndian.AppendUint16(w.b, attachDescVersion)
+	w.ansi(d.LongPathName)
+	w.ansi(d.PathName)
+	w.ansi(d.DisplayName)
+	w.ansi(d.LongFileName)
+	w.ansi(d.FileName)
+	w.ansi(d.Extension)
+	// FileTimeCreated, FileTimeModified
+	w.b = append(w.b, make([]byte, 16)...)
+	w.b = binary.LittleEndian.AppendUint32(w.b, attachByValue)
+	w.ansi(d.ContentID)
+	// ContentLocation
+	w.ansi("")
+	// RenderingPosition (none), Flags
+	w.b = binary.LittleEndian.AppendUint16(w.b, 0xFFFF)
+	w.b = binary.LittleEndian.AppendUint32(w.b, 0)
+	// UnicodeLongPathName, UnicodePathName
+	w.unicode("")
+	w.unicode("")
+	w.unicode(d.UnicodeDisplayName)
+	w.unicode(d.UnicodeLongFileName)
+	return w.b
+}
@@ -0,0 +1,216 @@
+package message
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/bored-engineer/rms/cfb"
+
+	"github.com/pkg/errors"
+)
+
+// BodyFormat values of OutlookBodyStreamInfo
+const (
+	TextBodyFormat = 1
+	RTFBodyFormat  = 2
+	HTMLBodyFormat = 3
+)
+
+// Entries written for attachments, each attachment is a storage under AttachmentListStorage
+const (
+	RpmsgStorageInfoEntry   = "RpmsgStorageInfo"
+	AttachmentListStorage   = "Attachment List"
+	attachmentStoragePrefix = "MailAttachment "
+)
+
+// rpmsgStorageInfoVersion is the major version of the storage layout written to RpmsgStorageInfo
+const rpmsgStorageInfoVersion = 1
+
+// codePages maps MIME charsets to Windows code pages, the inverse of Content.Charset
+var codePages = map[string]uint32{
+	"utf-8":          65001,
+	"us-ascii":       20127,
+	"iso-8859-1":     28591,
+	"shift_jis":      932,
+	"gb2312":         936,
+	"ks_c_5601-1987": 949,
+	"big5":           950,
+}
+
+// codePage returns the Windows code page of a MIME charset, UTF-8 if unknown
+func codePage(charset string) uint32 {
+	charset = strings.ToLower(charset)
+	if cp, ok := codePages[charset]; ok {
+		return cp
+	}
+	var cp uint32
+	if _, err := fmt.Sscanf(charset, "windows-%d", &cp); err == nil {
+		return cp
+	}
+	return 65001
+}
+
+// decodeTransfer undoes the Content-Transfer-Encoding of a MIME part
+func decodeTransfer(header textproto.MIMEHeader, body io.Reader) ([]byte, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))); encoding {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "", "7bit", "8bit", "binary":
+	default:
+		return nil, errors.Errorf("unsupported Content-Transfer-Encoding %s", encoding)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode MIME part")
+	}
+	return b, nil
+}
+
+// addPart adds the bodies and attachments of a (possibly nested) MIME entity
+func (c *Content) addPart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "failed to read MIME part")
+			}
+			if err := c.addPart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := decodeTransfer(header, body)
+	if err != nil {
+		return err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := params["name"]
+	if dispositionParams["filename"] != "" {
+		name = dispositionParams["filename"]
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	if disposition != "attachment" && name == "" {
+		switch {
+		case mediaType == "text/html" && c.HTML == nil:
+			c.HTML = b
+			c.CodePage = codePage(params["charset"])
+			return nil
+		case mediaType == "text/plain" && c.Text == nil:
+			c.Text = b
+			if c.HTML == nil {
+				c.CodePage = codePage(params["charset"])
+			}
+			return nil
+		}
+	}
+	if name == "" {
+		name = fmt.Sprintf("attachment%d", len(c.Attachments))
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	c.Attachments = append(c.Attachments, Attachment{
+		Name:        name,
+		ContentID:   strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>"),
+		ContentType: mediaType,
+		Data:        b,
+	})
+	return nil
+}
+
+// ParseEML parses a RFC 5322 message into its header and Content, the inverse of WriteEML
+func ParseEML(r io.Reader) (mail.Header, *Content, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read message")
+	}
+	c := &Content{}
+	if err := c.addPart(textproto.MIMEHeader(m.Header), m.Body); err != nil {
+		return nil, nil, err
+	}
+	return m.Header, c, nil
+}
+
+// WriteCompound writes the content as the (inner) compound file of a rpmsg, the inverse of ReadContent
+func (c *Content) WriteCompound(w io.Writer) error {
+	doc := cfb.NewWriter()
+	codePage := c.CodePage
+	if codePage == 0 {
+		codePage = 65001
+	}
+
+	// Outlook always renders an HTML body, plain text is escaped into one
+	format, bodyEntry, body := HTMLBodyFormat, HTMLBodyEntry, c.HTML
+	if body == nil {
+		format, bodyEntry = TextBodyFormat, TextAsHTMLBodyEntry
+		body = []byte("<html><body><pre>" + html.EscapeString(string(c.Text)) + "</pre></body></html>")
+	}
+	info := binary.LittleEndian.AppendUint16(nil, uint16(format))
+	info = binary.LittleEndian.AppendUint32(info, codePage)
+
+	// Version of the storage layout followed by reserved fields
+	storageInfo := make([]byte, 16)
+	binary.LittleEndian.PutUint32(storageInfo, rpmsgStorageInfoVersion)
+
+	streams := []struct {
+		name string
+		data []byte
+	}{
+		{BodyStreamInfoEntry, info},
+		{bodyEntry, body},
+		{RpmsgStorageInfoEntry, storageInfo},
+	}
+	for _, s := range streams {
+		if err := doc.Create(s.name, s.data); err != nil {
+			return errors.Wrapf(err, "failed to create %s", s.name)
+		}
+	}
+
+	for idx := range c.Attachments {
+		a := &c.Attachments[idx]
+		storage := path.Join(AttachmentListStorage, fmt.Sprintf("%s%d", attachmentStoragePrefix, idx))
+		ext := path.Ext(a.Name)
+		desc := &attachDesc{
+			DisplayName:         a.Name,
+			LongFileName:        a.Name,
+			FileName:            a.Name,
+			Extension:           ext,
+			ContentID:           a.ContentID,
+			UnicodeDisplayName:  a.Name,
+			UnicodeLongFileName: a.Name,
+		}
+		if err := doc.Create(path.Join(storage, AttachDescEntry), desc.bytes()); err != nil {
+			return errors.Wrapf(err, "failed to create %s of attachment %s", AttachDescEntry, a.Name)
+		}
+		if err := doc.Create(path.Join(storage, AttachContentsEntry), a.Data); err != nil {
+			return errors.Wrapf(err, "failed to create %s of attachment %s", AttachContentsEntry, a.Name)
+		}
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
@@ -0,0 +1,167 @@
+package message
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/richardlehane/mscfb"
+
+	"github.com/pkg/errors"
+)
+
+// Well known entries in the decrypted (inner) compound file of a rpmsg
+const (
+	BodyStreamInfoEntry = "OutlookBodyStreamInfo"
+	HTMLBodyEntry       = "BodyPT-HTML"
+	TextAsHTMLBodyEntry = "BodyPTAsHTML"
+	RTFBodyEntry        = "BodyRTF"
+	TextBodyEntry       = "BodyText"
+	AttachDescEntry     = "AttachDesc"
+	AttachContentsEntry = "AttachContents"
+)
+
+// Attachment is an attachment of a decrypted message
+type Attachment struct {
+	// Name is the original (long) file name of the attachment
+	Name string
+	// ContentID is set for inline attachments (ex: images in the HTML body)
+	ContentID string
+	// ContentType is guessed from the extension of Name
+	ContentType string
+	// Data is the contents of the attachment
+	Data []byte
+}
+
+// Content is the parsed contents of a decrypted message
+type Content struct {
+	// CodePage of the bodies (from OutlookBodyStreamInfo), 0 if unknown
+	CodePage uint32
+	// HTML body if present
+	HTML []byte
+	// Text body if present
+	Text []byte
+	// RTF body (decompressed) if present
+	RTF []byte
+	// Attachments in the order they are stored
+	Attachments []Attachment
+}
+
+// Charset returns the MIME charset of the bodies
+func (c *Content) Charset() string {
+	switch c.CodePage {
+	case 0, 65001:
+		return "utf-8"
+	case 20127:
+		return "us-ascii"
+	case 28591:
+		return "iso-8859-1"
+	case 932:
+		return "shift_jis"
+	case 936:
+		return "gb2312"
+	case 949:
+		return "ks_c_5601-1987"
+	case 950:
+		return "big5"
+	default:
+		return "windows-" + strconv.FormatUint(uint64(c.CodePage), 10)
+	}
+}
+
+// Content parses the decrypted compound file of the message
+func (m *Message) Content() (*Content, error) {
+	doc, err := m.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return ReadContent(doc)
+}
+
+// ReadContent parses a decrypted (inner) compound file
+func ReadContent(doc *mscfb.Reader) (*Content, error) {
+	var c Content
+	// Attachments are storages holding (at least) AttachContents, keyed by storage path
+	descs := make(map[string][]byte)
+	contents := make(map[string][]byte)
+	for _, f := range doc.File {
+		if f.Size == 0 {
+			continue
+		}
+		entryName := path.Join(path.Join(f.Path...), f.Name)
+		switch {
+		case len(f.Path) == 0 && f.Name == BodyStreamInfoEntry,
+			len(f.Path) == 0 && f.Name == HTMLBodyEntry,
+			len(f.Path) == 0 && f.Name == TextAsHTMLBodyEntry,
+			len(f.Path) == 0 && f.Name == RTFBodyEntry,
+			len(f.Path) == 0 && f.Name == TextBodyEntry,
+			f.Name == AttachDescEntry,
+			f.Name == AttachContentsEntry:
+		default:
+			continue
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read entry %s", entryName)
+		}
+		switch f.Name {
+		case BodyStreamInfoEntry:
+			// BodyFormat (2 bytes) followed by CodePage (4 bytes)
+			if len(b) >= 6 {
+				c.CodePage = binary.LittleEndian.Uint32(b[2:6])
+			}
+		case HTMLBodyEntry:
+			c.HTML = b
+		case TextAsHTMLBodyEntry:
+			if c.HTML == nil {
+				c.HTML = b
+			}
+		case TextBodyEntry:
+			c.Text = b
+		case RTFBodyEntry:
+			if c.RTF, err = decompressRTF(b); err != nil {
+				return nil, errors.Wrapf(err, "failed to decompress %s", entryName)
+			}
+		case AttachDescEntry:
+			descs[path.Join(f.Path...)] = b
+		case AttachContentsEntry:
+			contents[path.Join(f.Path...)] = b
+		}
+	}
+
+	storages := make([]string, 0, len(contents))
+	for storage := range contents {
+		storages = append(storages, storage)
+	}
+	// Shorter names first so "MailAttachment 2" comes before "MailAttachment 10"
+	sort.Slice(storages, func(i, j int) bool {
+		if len(storages[i]) != len(storages[j]) {
+			return len(storages[i]) < len(storages[j])
+		}
+		return storages[i] < storages[j]
+	})
+	for i, storage := range storages {
+		a := Attachment{Data: contents[storage]}
+		if b, ok := descs[storage]; ok {
+			desc, err := parseAttachDesc(b)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s/%s", storage, AttachDescEntry)
+			}
+			a.Name = desc.name()
+			a.ContentID = desc.ContentID
+		}
+		if a.Name == "" {
+			a.Name = "attachment" + strconv.Itoa(i)
+		}
+		a.ContentType = mime.TypeByExtension(strings.ToLower(path.Ext(a.Name)))
+		if a.ContentType == "" {
+			a.ContentType = "application/octet-stream"
+		}
+		c.Attachments = append(c.Attachments, a)
+	}
+	return &c, nil
+}
@@ -0,0 +1,126 @@
+package message
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/bored-engineer/rms/outlook"
+
+	"github.com/pkg/errors"
+)
+
+// writeQuotedPrintable writes b as quoted-printable
+func writeQuotedPrintable(w io.Writer, b []byte) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write(b); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// textPart is a single alternative body
+type textPart struct {
+	contentType string
+	data        []byte
+}
+
+// bodies returns the alternative bodies from least to most preferred (RFC 2046)
+func (c *Content) bodies() []textPart {
+	charset := c.Charset()
+	var parts []textPart
+	if c.Text != nil || (c.HTML == nil && c.RTF == nil) {
+		parts = append(parts, textPart{mime.FormatMediaType("text/plain", map[string]string{"charset": charset}), c.Text})
+	}
+	if c.RTF != nil {
+		parts = append(parts, textPart{"application/rtf", c.RTF})
+	}
+	if c.HTML != nil {
+		parts = append(parts, textPart{mime.FormatMediaType("text/html", map[string]string{"charset": charset}), c.HTML})
+	}
+	return parts
+}
+
+// writeAlternative writes the bodies as a multipart/alternative using boundary
+func (c *Content) writeAlternative(w io.Writer, boundary string) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return errors.Wrap(err, "failed to set boundary")
+	}
+	for _, body := range c.bodies() {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to create body part")
+		}
+		if err := writeQuotedPrintable(pw, body.data); err != nil {
+			return errors.Wrap(err, "failed to write body part")
+		}
+	}
+	return mw.Close()
+}
+
+// attachmentHeader returns the MIME header of an attachment part
+func attachmentHeader(a *Attachment) textproto.MIMEHeader {
+	disposition := "attachment"
+	if a.ContentID != "" {
+		disposition = "inline"
+	}
+	h := textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Name})},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": a.Name})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if a.ContentID != "" {
+		h.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	}
+	return h
+}
+
+// WriteEML writes the content as a RFC 5322 message, header (ex: from the outer .msg or MIME wrapper) is merged in
+func (c *Content) WriteEML(w io.Writer, header mail.Header) error {
+	// The wrapper's Content-* headers describe the rpmsg, not the decrypted message
+	h := outlook.MessageHeader(header)
+	h.Set("MIME-Version", "1.0")
+
+	alternative := multipart.NewWriter(nil).Boundary()
+	if len(c.Attachments) == 0 {
+		h.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative}))
+		if err := outlook.WriteHeader(w, h); err != nil {
+			return errors.Wrap(err, "failed to write header")
+		}
+		return c.writeAlternative(w, alternative)
+	}
+
+	mw := multipart.NewWriter(w)
+	h.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	if err := outlook.WriteHeader(w, h); err != nil {
+		return errors.Wrap(err, "failed to write header")
+	}
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative})},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create alternative part")
+	}
+	if err := c.writeAlternative(pw, alternative); err != nil {
+		return err
+	}
+	for i := range c.Attachments {
+		a := &c.Attachments[i]
+		pw, err := mw.CreatePart(attachmentHeader(a))
+		if err != nil {
+			return errors.Wrapf(err, "failed to create attachment part %s", a.Name)
+		}
+		if err := outlook.WriteBase64(pw, a.Data); err != nil {
+			return errors.Wrapf(err, "failed to write attachment part %s", a.Name)
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,98 @@
+package message
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// boundaryPattern matches the random boundaries multipart.Writer generates
+var boundaryPattern = regexp.MustCompile(`[0-9a-f]{60}`)
+
+// stableBoundaries replaces the random boundaries in b with boundary-1, boundary-2... in order of appearance
+func stableBoundaries(b []byte) []byte {
+	names := make(map[string]string)
+	return boundaryPattern.ReplaceAllFunc(b, func(boundary []byte) []byte {
+		name, ok := names[string(boundary)]
+		if !ok {
+			name = "boundary-" + strconv.Itoa(len(names)+1)
+			names[string(boundary)] = name
+		}
+		return []byte(name)
+	})
+}
+
+// checkGolden compares got to testdata/name, rewriting it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := "testdata/" + name
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s (run with -update to see the difference):\n%s", path, got)
+	}
+}
+
+// emlHeader is the header of the wrapper, the Content-* headers describe the rpmsg and must be dropped
+var emlHeader = mail.Header{
+	"From":                      {"Zoë Adams <zoe@contoso.com>"},
+	"To":                        {"bob@contoso.com"},
+	"Subject":                   {"Réunion trimestrielle – résultats"},
+	"Date":                      {"Mon, 2 Mar 2020 10:00:00 +0000"},
+	"Message-Id":                {"<1234@contoso.com>"},
+	"Content-Type":              {"application/x-microsoft-rpmsg-message"},
+	"Content-Transfer-Encoding": {"base64"},
+}
+
+func TestWriteEML(t *testing.T) {
+	c := &Content{
+		CodePage: 65001,
+		Text:     []byte("Bonjour, voici les résultats.\r\nUne ligne qui est bien plus longue que soixante-seize caractères afin de tester le quoted-printable.\r\n"),
+		HTML:     []byte("<p>Bonjour, voici les <b>résultats</b>.</p><img src=\"cid:logo@contoso.com\">"),
+		Attachments: []Attachment{
+			{Name: "résumé 2020.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.7 not really a PDF")},
+			{Name: "logo.png", ContentID: "logo@contoso.com", ContentType: "image/png", Data: bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 30)},
+		},
+	}
+	var buf bytes.Buffer
+	if err := c.WriteEML(&buf, emlHeader); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "attachments.eml", stableBoundaries(buf.Bytes()))
+
+	// The output must parse back to the same content
+	header, parsed, err := ParseEML(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject")); err != nil || subject != emlHeader.Get("Subject") {
+		t.Errorf("Subject is %q (%v)", subject, err)
+	}
+	if !reflect.DeepEqual(parsed, c) {
+		t.Errorf("parsed %+v, want %+v", parsed, c)
+	}
+}
+
+func TestWriteEMLWithoutAttachments(t *testing.T) {
+	c := &Content{Text: []byte("Plain text only\r\n")}
+	var buf bytes.Buffer
+	if err := c.WriteEML(&buf, mail.Header{"Subject": {"Hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "text.eml", stableBoundaries(buf.Bytes()))
+}
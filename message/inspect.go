@@ -0,0 +1,137 @@
+package message
+
+import (
+	"bytes"
+
+	"github.com/bored-engineer/rms/outlook"
+	"github.com/bored-engineer/rms/pdf"
+	"github.com/bored-engineer/rms/pfile"
+	"github.com/bored-engineer/rms/rpmsg"
+	"github.com/bored-engineer/rms/xrml"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of protected files recognized by Inspect
+const (
+	FormatRPMSG    = "rpmsg"
+	FormatMSG      = "msg"
+	FormatEML      = "eml"
+	FormatCompound = "compound"
+	FormatOffice   = "office"
+	FormatPFile    = "pfile"
+	FormatPDF      = "pdf"
+)
+
+// ErrNotProtected is returned by Inspect for a file which is not in a recognized protected format
+var ErrNotProtected = errors.New("file is not a recognized protected format")
+
+// Info is what can be learned about a protected file without a license
+type Info struct {
+	// Format is one of the Format constants
+	Format string
+	// Extension is the extension of the original file (PFile only)
+	Extension string `json:",omitempty"`
+	// Stream is the path of the encrypted stream (compound files only)
+	Stream     string `json:",omitempty"`
+	ContentID  string `json:",omitempty"`
+	Owner      string `json:",omitempty"`
+	IssuerName string `json:",omitempty"`
+	IssuerURL  string `json:",omitempty"`
+	// IntranetURL and ExtranetURL are the license acquisition URLs
+	IntranetURL string `json:",omitempty"`
+	ExtranetURL string `json:",omitempty"`
+	TemplateID  string `json:",omitempty"`
+	LabelID     string `json:",omitempty"`
+	// CipherMode is only known if the license or container declares it
+	CipherMode string `json:",omitempty"`
+	// PublishingLicense is the XrML license, it is not included in JSON
+	PublishingLicense []byte `json:"-"`
+}
+
+// fill copies the metadata from the publishing license
+func (info *Info) fill(license []byte) error {
+	info.PublishingLicense = license
+	pl, err := xrml.Parse(license)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse publishing license")
+	}
+	info.ContentID = pl.ContentID()
+	info.Owner = pl.Owner()
+	if issuer := pl.Issuer(); issuer != nil {
+		info.IssuerName = issuer.Name
+		info.IssuerURL = issuer.URL()
+	}
+	info.IntranetURL = pl.IntranetURL()
+	info.ExtranetURL = pl.ExtranetURL()
+	info.TemplateID = pl.TemplateID()
+	info.LabelID = pl.LabelID()
+	if info.CipherMode == "" {
+		info.CipherMode = pl.CipherMode()
+	}
+	return nil
+}
+
+// inspectEnvelope identifies an Envelope read from a compound file
+func inspectEnvelope(format string, e *Envelope) (*Info, error) {
+	if format == FormatCompound && e.IsPackage() {
+		format = FormatOffice
+	}
+	info := &Info{Format: format, Stream: e.Stream}
+	if err := info.fill(e.PublishingLicense); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Inspect identifies a protected file by its contents and reads its metadata without contacting any server
+func Inspect(b []byte) (*Info, error) {
+	switch {
+	case pfile.IsPFile(bytes.NewReader(b)):
+		h, err := pfile.ReadHeader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		info := &Info{Format: FormatPFile, Extension: h.Extension}
+		if err := info.fill(h.PublishingLicense); err != nil {
+			return nil, err
+		}
+		return info, nil
+	case rpmsg.IsRPMSG(b):
+		e, err := ReadEnvelope(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return inspectEnvelope(FormatRPMSG, e)
+	case pdf.IsPDF(b):
+		p, err := pdf.ReadProtected(b)
+		if errors.Is(err, pdf.ErrNotProtected) {
+			return nil, ErrNotProtected
+		} else if err != nil {
+			return nil, err
+		}
+		info := &Info{Format: FormatPDF, CipherMode: p.CryptFilter()}
+		if err := info.fill(p.PublishingLicense); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	// A .msg is also a compound file, but without a DataSpaces storage
+	if e, err := ReadCompoundEnvelope(bytes.NewReader(b)); err == nil {
+		return inspectEnvelope(FormatCompound, e)
+	}
+	w, err := outlook.Read(b)
+	if err != nil {
+		return nil, ErrNotProtected
+	}
+	e, err := ReadEnvelope(bytes.NewReader(w.RPMSG))
+	if err != nil {
+		return nil, err
+	}
+	format := FormatEML
+	if outlook.IsMSG(b) {
+		format = FormatMSG
+	}
+	return inspectEnvelope(format, e)
+}
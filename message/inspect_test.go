@@ -0,0 +1,206 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/mail"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/bored-engineer/rms/cfb"
+	"github.com/bored-engineer/rms/dataspaces"
+	"github.com/bored-engineer/rms/internal/rmstest"
+	"github.com/bored-engineer/rms/outlook"
+	"github.com/bored-engineer/rms/pdf"
+
+	"github.com/pkg/errors"
+)
+
+// inspectLicense is a publishing license with every field Inspect reports
+const inspectLicense = `<?xml version="1.0"?>
+<XrML version="1.2" purpose="publish">
+	<BODY type="Microsoft Rights Label" version="3.0">
+		<ISSUER>
+			<OBJECT type="MS-DRM-Server">
+				<ID type="MS-GUID">{0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0}</ID>
+				<NAME>Contoso</NAME>
+				<ADDRESS type="URL">https://contoso.rms.na.aadrm.com/_wmcs/licensing</ADDRESS>
+			</OBJECT>
+		</ISSUER>
+		<DISTRIBUTIONPOINT>
+			<OBJECT type="License-Acquisition-URL">
+				<ADDRESS type="URL">https://contoso.rms.na.aadrm.com/_wmcs/licensing</ADDRESS>
+			</OBJECT>
+		</DISTRIBUTIONPOINT>
+		<DISTRIBUTIONPOINT>
+			<OBJECT type="Extranet-License-Acquisition-URL">
+				<ADDRESS type="URL">https://extranet.contoso.com/_wmcs/licensing</ADDRESS>
+			</OBJECT>
+		</DISTRIBUTIONPOINT>
+		<WORK>
+			<OBJECT type="MS-RM-ID">
+				<ID type="MS-GUID">{8f3c1a2b-5d6e-4f70-8192-a3b4c5d6e7f8}</ID>
+			</OBJECT>
+			<METADATA>
+				<OWNER>
+					<OBJECT>
+						<ID type="Unspecified">alice@contoso.com</ID>
+					</OBJECT>
+				</OWNER>
+			</METADATA>
+		</WORK>
+		<AUTHENTICATEDDATA id="APPSPECIFIC" name="TemplateID">{a1b2c3d4-e5f6-4071-8293-a4b5c6d7e8f9}</AUTHENTICATEDDATA>
+		<AUTHENTICATEDDATA id="APPSPECIFIC" name="MSIP_Label_11111111-2222-3333-4444-555555555555_Enabled">true</AUTHENTICATEDDATA>
+	</BODY>
+</XrML>`
+
+// inspectedLicense is the Info filled from inspectLicense
+var inspectedLicense = Info{
+	ContentID:   "{8f3c1a2b-5d6e-4f70-8192-a3b4c5d6e7f8}",
+	Owner:       "alice@contoso.com",
+	IssuerName:  "Contoso",
+	IssuerURL:   "https://contoso.rms.na.aadrm.com/_wmcs/licensing",
+	IntranetURL: "https://contoso.rms.na.aadrm.com/_wmcs/licensing",
+	ExtranetURL: "https://extranet.contoso.com/_wmcs/licensing",
+	TemplateID:  "a1b2c3d4-e5f6-4071-8293-a4b5c6d7e8f9",
+	LabelID:     "11111111-2222-3333-4444-555555555555",
+}
+
+// utf16LE encodes s as a NUL terminated PtypString
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// buildMSG creates a .msg with a single attachment named name
+func buildMSG(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	w := cfb.NewWriter()
+	for p, b := range map[string][]byte{
+		"__substg1.0_007D001F":                               utf16LE("From: alice@contoso.com\r\nSubject: Protected\r\n"),
+		"__attach_version1.0_#00000000/__substg1.0_3707001F": utf16LE(name),
+		"__attach_version1.0_#00000000/__substg1.0_37010102": data,
+	} {
+		if err := w.Create(p, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildPDF writes a PDF encrypted by the MicrosoftIRMServices handler, only the encryption dictionary matters
+func buildPDF(t *testing.T, encrypt pdf.Dict) []byte {
+	t.Helper()
+	f := &pdf.File{
+		Version: "1.7",
+		Objects: map[int]*pdf.Indirect{
+			1: {Value: pdf.Dict{"Type": pdf.Name("Catalog"), "Pages": pdf.Ref{Num: 2}}},
+			2: {Value: pdf.Dict{"Type": pdf.Name("Pages"), "Kids": pdf.Array{}, "Count": pdf.Raw("0")}},
+		},
+		Trailer: pdf.Dict{"Root": pdf.Ref{Num: 1}},
+	}
+	if encrypt != nil {
+		f.Objects[3] = &pdf.Indirect{Value: encrypt}
+		f.Trailer["Encrypt"] = pdf.Ref{Num: 3}
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// inspectFixtures builds a file of every format Inspect recognizes
+func inspectFixtures(t *testing.T) map[string][]byte {
+	key := rmstest.NewLicensor(t, "MICROSOFT.CBC4K", 32).Key
+	message := &Envelope{PublishingLicense: []byte(inspectLicense), Content: []byte("encrypted"), Stream: dataspaces.Content}
+	var compound, rpmsg, office bytes.Buffer
+	if err := message.WriteCompound(&compound); err != nil {
+		t.Fatal(err)
+	}
+	if err := message.WriteRPMSG(&rpmsg); err != nil {
+		t.Fatal(err)
+	}
+	document := &Envelope{PublishingLicense: []byte(inspectLicense), Content: []byte("encrypted"), Stream: dataspaces.EncryptedPackageStream}
+	if err := document.WriteCompound(&office); err != nil {
+		t.Fatal(err)
+	}
+	var eml bytes.Buffer
+	wrapper := &outlook.Wrapper{Header: mail.Header{"From": {"alice@contoso.com"}, "Subject": {"Protected"}}, RPMSG: rpmsg.Bytes()}
+	if err := wrapper.WriteMIME(&eml); err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		FormatRPMSG:    rpmsg.Bytes(),
+		FormatEML:      eml.Bytes(),
+		FormatMSG:      buildMSG(t, outlook.RPMSGFileName, rpmsg.Bytes()),
+		FormatCompound: compound.Bytes(),
+		FormatOffice:   office.Bytes(),
+		FormatPFile:    rmstest.BuildPFile(t, key, rmstest.PFile{Extension: ".docx", License: []byte(inspectLicense)}, []byte("plaintext")),
+		FormatPDF: buildPDF(t, pdf.Dict{
+			"Filter":            pdf.Name(pdf.SecurityHandler),
+			"V":                 pdf.Raw("4"),
+			"CF":                pdf.Dict{"StdCF": pdf.Dict{"CFM": pdf.Name("AESV2")}},
+			"StmF":              pdf.Name("StdCF"),
+			"StrF":              pdf.Name("StdCF"),
+			"PublishingLicense": pdf.String(inspectLicense),
+		}),
+	}
+}
+
+func TestInspect(t *testing.T) {
+	for format, b := range inspectFixtures(t) {
+		info, err := Inspect(b)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		want := inspectedLicense
+		want.Format = format
+		switch format {
+		case FormatPFile:
+			want.Extension = ".docx"
+		case FormatPDF:
+			want.CipherMode = "AESV2"
+		case FormatOffice:
+			want.Stream = dataspaces.EncryptedPackageStream
+		default:
+			want.Stream = dataspaces.Content
+		}
+		got := *info
+		got.PublishingLicense = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format, got, want)
+		}
+		if !bytes.Contains(info.PublishingLicense, []byte("<XrML")) {
+			t.Errorf("%s: PublishingLicense is %q", format, info.PublishingLicense)
+		}
+	}
+}
+
+func TestInspectNotProtected(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"empty":           nil,
+		"text":            []byte("just some text\r\n"),
+		"eml":             []byte("From: alice@contoso.com\r\nSubject: plain\r\n\r\nno attachment\r\n"),
+		"msg":             buildMSG(t, "notes.txt", []byte("unrelated")),
+		"unencrypted pdf": buildPDF(t, nil),
+	} {
+		if _, err := Inspect(b); !errors.Is(err, ErrNotProtected) {
+			t.Errorf("%s: got %v, want ErrNotProtected", name, err)
+		}
+	}
+	// A recognized format with a broken license is an error, but not ErrNotProtected
+	b := buildPDF(t, pdf.Dict{"Filter": pdf.Name(pdf.SecurityHandler), "PublishingLicense": pdf.String("not XML")})
+	if _, err := Inspect(b); err == nil || errors.Is(err, ErrNotProtected) {
+		t.Errorf("got %v for a broken license", err)
+	}
+}
@@ -0,0 +1,157 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/dataspaces"
+	"github.com/bored-engineer/rms/rpmsg"
+
+	"github.com/richardlehane/mscfb"
+
+	"github.com/pkg/errors"
+)
+
+// TrimLicense finds the start of the license (sometimes there's a random prefix)
+func TrimLicense(license []byte) ([]byte, error) {
+	idx := bytes.Index(license, []byte("<?xml"))
+	if idx == -1 {
+		return nil, errors.New("license does not have xml prefix")
+	}
+	return license[idx:], nil
+}
+
+// Envelope is the still encrypted contents of a protected message
+type Envelope struct {
+	// PublishingLicense is the XrML license from the IRMDS transform
+	PublishingLicense []byte
+	// Content is the encrypted stream (usually DRMContent)
+	Content []byte
+	// Stream is the slash separated path of the encrypted stream (ex: "DRMContent" or "EncryptedPackage")
+	Stream string
+}
+
+// IsPackage reports if the Envelope is a protected Office document, its plaintext is an OOXML package
+func (e *Envelope) IsPackage() bool {
+	return e.Stream == dataspaces.EncryptedPackageStream
+}
+
+// primaryStream picks the protected stream holding the content, preferring the well known names
+func primaryStream(protected []dataspaces.Protected) dataspaces.Protected {
+	for _, p := range protected {
+		switch p.Path {
+		case dataspaces.EncryptedPackageStream, dataspaces.Content:
+			return p
+		}
+	}
+	return protected[0]
+}
+
+// ReadCompoundEnvelope reads the Envelope from an (outer) compound file
+func ReadCompoundEnvelope(ra io.ReaderAt) (*Envelope, error) {
+	doc, err := mscfb.New(ra)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start compound reader")
+	}
+	ds, err := dataspaces.Read(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read DataSpaces")
+	}
+	protected := ds.Protected()
+	if len(protected) == 0 {
+		return nil, errors.New("compound file does not contain any IRM protected streams")
+	}
+	primary := primaryStream(protected)
+	license, err := primary.Transform.License()
+	if err != nil {
+		return nil, err
+	}
+	license, err = TrimLicense(license)
+	if err != nil {
+		return nil, err
+	}
+	f, err := dataspaces.Find(doc, primary.Path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read entry %s", primary.Path)
+	}
+	return &Envelope{
+		PublishingLicense: license,
+		Content:           content,
+		Stream:            primary.Path,
+	}, nil
+}
+
+// ReadEnvelope decodes a rpmsg streamed from r and reads the Envelope from it, only the decoded compound file is held in memory
+func ReadEnvelope(r io.Reader) (*Envelope, error) {
+	rr, err := rpmsg.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start rpmsg reader")
+	}
+	compound, err := ioutil.ReadAll(rr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode rpmsg")
+	}
+	return ReadCompoundEnvelope(bytes.NewReader(compound))
+}
+
+// Message is a decrypted message
+type Message struct {
+	// PublishingLicense is the XrML license the message was protected with
+	PublishingLicense []byte
+	// EndUserLicense is the license aadrm issued for the message
+	EndUserLicense *aadrm.EndUserLicense
+	// Compound is the decrypted (inner) compound file, nil for a protected Office document
+	Compound []byte
+	// Package is the decrypted OOXML package (ex: a .docx) of a protected Office document
+	Package []byte
+}
+
+// Reader creates a *mscfb.Reader for the decrypted compound file
+func (m *Message) Reader() (*mscfb.Reader, error) {
+	if m.Compound == nil {
+		return nil, errors.New("decrypted content is an Office document, not a compound file")
+	}
+	doc, err := mscfb.New(bytes.NewReader(m.Compound))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start compound reader")
+	}
+	return doc, nil
+}
+
+// Decrypt fetches a user license using client and decrypts the Envelope
+func (e *Envelope) Decrypt(ctx context.Context, client aadrm.Licensor) (*Message, error) {
+	userLicense, _, _, err := client.GetEndUserLicense(ctx, e.PublishingLicense)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request EndUserLicense")
+	}
+	plaintext, err := userLicense.Key.DecryptContent(e.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	m := &Message{
+		PublishingLicense: e.PublishingLicense,
+		EndUserLicense:    userLicense,
+	}
+	if e.IsPackage() {
+		m.Package = plaintext
+	} else {
+		m.Compound = plaintext
+	}
+	return m, nil
+}
+
+// Open decodes, fetches a user license for and decrypts a rpmsg streamed from r (see ReadEnvelope)
+func Open(ctx context.Context, r io.Reader, client aadrm.Licensor) (*Message, error) {
+	e, err := ReadEnvelope(r)
+	if err != nil {
+		return nil, err
+	}
+	return e.Decrypt(ctx, client)
+}
@@ -0,0 +1,76 @@
+package message
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/pkg/errors"
+)
+
+// packageExtensions maps the content type of the main part of an OOXML package to its file extension
+var packageExtensions = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml":   ".docx",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml":   ".dotx",
+	"application/vnd.ms-word.document.macroEnabled.main+xml":                             ".docm",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml":         ".xlsx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml":      ".xltx",
+	"application/vnd.ms-excel.sheet.macroEnabled.main+xml":                               ".xlsm",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml": ".pptx",
+	"application/vnd.openxmlformats-officedocument.presentationml.slideshow.main+xml":    ".ppsx",
+	"application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml":                   ".pptm",
+}
+
+// contentTypes is the [Content_Types].xml part of an OOXML package
+type contentTypes struct {
+	Overrides []struct {
+		PartName    string `xml:"PartName,attr"`
+		ContentType string `xml:"ContentType,attr"`
+	} `xml:"Override"`
+}
+
+// PackageExtension returns the file extension (ex: ".docx") of a decrypted OOXML package
+func PackageExtension(pkg []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read package")
+	}
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, "[Content_Types].xml") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to open [Content_Types].xml")
+		}
+		defer r.Close()
+		var types contentTypes
+		if err := xml.NewDecoder(r).Decode(&types); err != nil {
+			return "", errors.Wrap(err, "failed to parse [Content_Types].xml")
+		}
+		for _, o := range types.Overrides {
+			if ext, ok := packageExtensions[o.ContentType]; ok {
+				return ext, nil
+			}
+		}
+		return "", errors.New("package does not have a known main part")
+	}
+	return "", errors.New("package is missing [Content_Types].xml")
+}
+
+// OpenDocument fetches a user license for and decrypts a protected Office document (ex: .docx)
+func OpenDocument(ctx context.Context, ra io.ReaderAt, client aadrm.Licensor) (*Message, error) {
+	e, err := ReadCompoundEnvelope(ra)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsPackage() {
+		return nil, errors.Errorf("compound file protects %s, not an Office document", e.Stream)
+	}
+	return e.Decrypt(ctx, client)
+}
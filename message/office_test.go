@@ -0,0 +1,142 @@
+package message
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/cfb"
+	"github.com/bored-engineer/rms/dataspaces"
+	"github.com/bored-engineer/rms/internal/rmstest"
+
+	"github.com/pkg/errors"
+)
+
+var documentLicense = rmstest.License("document")
+
+// buildPackage creates an OOXML package whose main part has contentType, padded to span several segments
+func buildPackage(t *testing.T, contentType string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="xml" ContentType="application/xml"/><Override PartName="/main.xml" ContentType="` + contentType + `"/></Types>`,
+		"main.xml": `<document>` + string(bytes.Repeat([]byte("x"), 10000)) + `</document>`,
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildDocument writes a protected Office document by hand, encrypted is the EncryptedPackage stream
+func buildDocument(t *testing.T, encrypted []byte) []byte {
+	t.Helper()
+	w := cfb.NewWriter()
+	ds := dataspaces.NewIRM(dataspaces.EncryptedPackageStream, dataspaces.DRMEncryptedDataSpace, dataspaces.DRMEncryptedTransform, documentLicense)
+	if err := ds.Write(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Create(dataspaces.EncryptedPackageStream, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenDocument(t *testing.T) {
+	for _, tc := range []struct {
+		cipherMode string
+		size       int
+	}{
+		{"MICROSOFT.ECB", 16},
+		{"MICROSOFT.CBC4K", 32},
+	} {
+		l := rmstest.NewLicensor(t, tc.cipherMode, tc.size)
+		pkg := buildPackage(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml")
+		encrypted, err := l.Key.EncryptContent(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := buildDocument(t, encrypted)
+		m, err := OpenDocument(context.Background(), bytes.NewReader(b), l)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.cipherMode, err)
+		}
+		if !bytes.Equal(m.Package, pkg) || m.Compound != nil {
+			t.Errorf("%s: decrypted %d bytes of package and %d bytes of compound file", tc.cipherMode, len(m.Package), len(m.Compound))
+		}
+		if !bytes.Equal(m.PublishingLicense, documentLicense) {
+			t.Errorf("%s: PublishingLicense is %q", tc.cipherMode, m.PublishingLicense)
+		}
+		if ext, err := PackageExtension(m.Package); err != nil || ext != ".docx" {
+			t.Errorf("%s: PackageExtension is %q (%v)", tc.cipherMode, ext, err)
+		}
+		if n := l.Calls("document"); n != 1 {
+			t.Errorf("%s: requested %d licenses", tc.cipherMode, n)
+		}
+	}
+}
+
+func TestOpenDocumentErrors(t *testing.T) {
+	ctx := context.Background()
+	l := rmstest.NewLicensor(t, "MICROSOFT.CBC4K", 32)
+	encrypted, err := l.Key.EncryptContent(buildPackage(t, "application/vnd.ms-excel.sheet.macroEnabled.main+xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated EncryptedPackage no longer has the ciphertext its header declares
+	for _, n := range []int{len(encrypted) - 16, len(encrypted) / 2, 8} {
+		b := buildDocument(t, encrypted[:n])
+		var sizeErr *aadrm.ContentSizeError
+		if _, err := OpenDocument(ctx, bytes.NewReader(b), l); !errors.As(err, &sizeErr) {
+			t.Errorf("truncated to %d bytes: got %v, want a ContentSizeError", n, err)
+		}
+	}
+	if _, err := OpenDocument(ctx, bytes.NewReader(buildDocument(t, encrypted[:4])), l); err == nil {
+		t.Error("expected an error without an EncryptedPackage header")
+	}
+
+	l.Denied["document"] = true
+	if _, err := OpenDocument(ctx, bytes.NewReader(buildDocument(t, encrypted)), l); !errors.Is(err, aadrm.ErrAccessDenied) {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+
+	// A protected message is a compound file too, but not an Office document
+	var message bytes.Buffer
+	e := &Envelope{PublishingLicense: documentLicense, Content: encrypted, Stream: dataspaces.Content}
+	if err := e.WriteCompound(&message); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDocument(ctx, bytes.NewReader(message.Bytes()), l); err == nil {
+		t.Error("expected an error for a protected message")
+	}
+	if _, err := OpenDocument(ctx, bytes.NewReader(encrypted), l); err == nil {
+		t.Error("expected an error for a file which is not a compound file")
+	}
+}
+
+func TestPackageExtension(t *testing.T) {
+	if ext, err := PackageExtension(buildPackage(t, "application/vnd.ms-excel.sheet.macroEnabled.main+xml")); err != nil || ext != ".xlsm" {
+		t.Errorf("got %q (%v), want .xlsm", ext, err)
+	}
+	if _, err := PackageExtension(buildPackage(t, "application/xml")); err == nil {
+		t.Error("expected an error without a known main part")
+	}
+	if _, err := PackageExtension([]byte("not a zip")); err == nil {
+		t.Error("expected an error for a file which is not a package")
+	}
+}
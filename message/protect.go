@@ -0,0 +1,115 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/cfb"
+	"github.com/bored-engineer/rms/dataspaces"
+	"github.com/bored-engineer/rms/outlook"
+	"github.com/bored-engineer/rms/rpmsg"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCipherMode and DefaultKeySize are used by Protect if the request does not include a Key
+const (
+	DefaultCipherMode = "MICROSOFT.CBC4K"
+	DefaultKeySize    = 32
+)
+
+// Protect requests a publishing license with req (which must set TemplateID or Policy) and encrypts plaintext,
+// a content key is generated unless req has one (the service may also return its own)
+func Protect(ctx context.Context, client *aadrm.Client, plaintext []byte, req *aadrm.PublishingLicenseRequest) (*Envelope, error) {
+	r := *req
+	if r.Key == nil {
+		key, err := aadrm.GenerateKey(DefaultCipherMode, DefaultKeySize)
+		if err != nil {
+			return nil, err
+		}
+		r.Key = key
+	}
+	pl, _, err := client.CreatePublishingLicense(ctx, &r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request PublishingLicense")
+	}
+	license, err := pl.License()
+	if err != nil {
+		return nil, err
+	}
+	license, err = TrimLicense(license)
+	if err != nil {
+		return nil, err
+	}
+	key := r.Key
+	if pl.Key != nil {
+		key = pl.Key
+	}
+	content, err := key.EncryptContent(plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt")
+	}
+	return &Envelope{
+		PublishingLicense: license,
+		Content:           content,
+		Stream:            dataspaces.Content,
+	}, nil
+}
+
+// WriteCompound writes the Envelope as an (outer) compound file, the inverse of ReadCompoundEnvelope
+func (e *Envelope) WriteCompound(w io.Writer) error {
+	doc := cfb.NewWriter()
+	stream, dataSpace, transform := dataspaces.DRMContentStream, dataspaces.DRMDataSpace, dataspaces.DRMTransform
+	if e.IsPackage() {
+		stream, dataSpace, transform = dataspaces.EncryptedPackageStream, dataspaces.DRMEncryptedDataSpace, dataspaces.DRMEncryptedTransform
+	}
+	ds := dataspaces.NewIRM(stream, dataSpace, transform, e.PublishingLicense)
+	if err := ds.Write(doc); err != nil {
+		return err
+	}
+	if err := doc.Create(stream, e.Content); err != nil {
+		return errors.Wrap(err, "failed to create protected stream")
+	}
+	if _, err := doc.WriteTo(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteRPMSG writes the Envelope as a rpmsg, the inverse of ReadEnvelope
+func (e *Envelope) WriteRPMSG(w io.Writer) error {
+	rw, err := rpmsg.NewWriter(w)
+	if err != nil {
+		return errors.Wrap(err, "failed to start rpmsg writer")
+	}
+	if err := e.WriteCompound(rw); err != nil {
+		return err
+	}
+	if err := rw.Close(); err != nil {
+		return errors.Wrap(err, "failed to close rpmsg writer")
+	}
+	return nil
+}
+
+// ProtectEML protects a RFC 5322 message, the result can be written with WriteMIME
+func ProtectEML(ctx context.Context, client *aadrm.Client, r io.Reader, req *aadrm.PublishingLicenseRequest) (*outlook.Wrapper, error) {
+	header, content, err := ParseEML(r)
+	if err != nil {
+		return nil, err
+	}
+	var compound bytes.Buffer
+	if err := content.WriteCompound(&compound); err != nil {
+		return nil, errors.Wrap(err, "failed to write message compound file")
+	}
+	envelope, err := Protect(ctx, client, compound.Bytes(), req)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped bytes.Buffer
+	if err := envelope.WriteRPMSG(&wrapped); err != nil {
+		return nil, err
+	}
+	return &outlook.Wrapper{Header: header, RPMSG: wrapped.Bytes()}, nil
+}
@@ -0,0 +1,153 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/internal/rmstest"
+	"github.com/bored-engineer/rms/outlook"
+)
+
+var protectedLicense = rmstest.License("protected")
+
+// publishingServer issues protectedLicense for every request and records them, if key is set it is
+// returned instead of the requested key
+func publishingServer(t *testing.T, key *aadrm.Key) (*aadrm.Client, func() []aadrm.PublishingLicenseRequest) {
+	var mu sync.Mutex
+	var requests []aadrm.PublishingLicenseRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/my/v2/publishinglicenses" {
+			http.NotFound(w, r)
+			return
+		}
+		var req aadrm.PublishingLicenseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		serialized := base64.StdEncoding.EncodeToString(protectedLicense)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&aadrm.PublishingLicense{SerializedPublishingLicense: &serialized, Key: key})
+	}))
+	t.Cleanup(s.Close)
+	c := aadrm.NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL)
+	c.RetryPolicy = nil
+	return c, func() []aadrm.PublishingLicenseRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// protectEML is a message with a non-ASCII subject and an attachment
+const protectEML = "From: Alice <alice@contoso.com>\r\n" +
+	"To: bob@contoso.com\r\n" +
+	"Subject: =?utf-8?q?R=C3=A9union?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"The numbers are attached.\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=numbers.csv\r\n" +
+	"Content-Disposition: attachment; filename=numbers.csv\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"cXVhcnRlcixyZXZlbnVlCjEsMTAwCg==\r\n" +
+	"--outer--\r\n"
+
+func TestProtectEML(t *testing.T) {
+	ctx := context.Background()
+	serviceKey, err := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		// serviceKey is returned by the service instead of the generated key
+		serviceKey *aadrm.Key
+	}{
+		{"generated key", nil},
+		{"service key", serviceKey},
+	} {
+		client, requests := publishingServer(t, tc.serviceKey)
+		templateID := "template"
+		wrapper, err := ProtectEML(ctx, client, strings.NewReader(protectEML), &aadrm.PublishingLicenseRequest{TemplateID: &templateID})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var protected bytes.Buffer
+		if err := wrapper.WriteMIME(&protected); err != nil {
+			t.Fatal(err)
+		}
+		if len(requests()) != 1 {
+			t.Fatalf("%s: got %d requests", tc.name, len(requests()))
+		}
+		req := requests()[0]
+		if req.TemplateID == nil || *req.TemplateID != templateID || req.Key == nil {
+			t.Errorf("%s: unexpected request %+v", tc.name, req)
+		}
+
+		// The plaintext must not leak into the protected message
+		if bytes.Contains(protected.Bytes(), []byte("numbers")) || bytes.Contains(protected.Bytes(), []byte("cXVhcnRlcixyZXZlbnVl")) {
+			t.Errorf("%s: protected message contains the plaintext", tc.name)
+		}
+
+		w, err := outlook.Read(protected.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(w.Header.Get("Subject"))
+		if err != nil || subject != "Réunion" {
+			t.Errorf("%s: Subject is %q (%v)", tc.name, subject, err)
+		}
+		if from := w.Header.Get("From"); from != "Alice <alice@contoso.com>" {
+			t.Errorf("%s: From is %q", tc.name, from)
+		}
+		if class := w.Header.Get("Content-Class"); class != outlook.RPMSGContentClass {
+			t.Errorf("%s: Content-Class is %q", tc.name, class)
+		}
+
+		key := req.Key
+		if tc.serviceKey != nil {
+			key = tc.serviceKey
+		}
+		l := &rmstest.Licensor{Key: key}
+		m, err := Open(ctx, bytes.NewReader(w.RPMSG), l)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(m.PublishingLicense, protectedLicense) || l.Calls("protected") != 1 {
+			t.Errorf("%s: PublishingLicense is %q", tc.name, m.PublishingLicense)
+		}
+		content, err := m.Content()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A plain text body is stored escaped into an HTML body
+		if !bytes.Contains(content.HTML, []byte("<pre>The numbers are attached.")) {
+			t.Errorf("%s: HTML is %q", tc.name, content.HTML)
+		}
+		if len(content.Attachments) != 1 {
+			t.Fatalf("%s: got %d attachments", tc.name, len(content.Attachments))
+		}
+		if a := content.Attachments[0]; a.Name != "numbers.csv" || string(a.Data) != "quarter,revenue\n1,100\n" {
+			t.Errorf("%s: attachment is %s %q", tc.name, a.Name, a.Data)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// https://docs.microsoft.com/en-us/openspecs/exchange_server_protocols/ms-oxrtfcp/
+const (
+	rtfCompressed   = 0x75465a4c // "LZFu"
+	rtfUncompressed = 0x414c454d // "MELA"
+)
+
+// rtfPrebuffer initializes the LZFu dictionary
+const rtfPrebuffer = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman \\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+// maxRTFPrealloc caps the buffer allocated up front from the (untrusted) RawSize, append grows it if needed
+const maxRTFPrealloc = 1 << 20
+
+// decompressRTF decompresses a (usually LZFu compressed) RTF body
+func decompressRTF(b []byte) ([]byte, error) {
+	if len(b) < 16 {
+		return nil, errors.New("compressed RTF is too short for the header")
+	}
+	compSize := binary.LittleEndian.Uint32(b[0:4])
+	rawSize := binary.LittleEndian.Uint32(b[4:8])
+	compType := binary.LittleEndian.Uint32(b[8:12])
+	// CompSize does not include itself
+	if int64(compSize)+4 < int64(len(b)) {
+		b = b[:compSize+4]
+	}
+	data := b[16:]
+
+	switch compType {
+	case rtfUncompressed:
+		if int64(rawSize) > int64(len(data)) {
+			return nil, errors.Errorf("uncompressed RTF declares %d bytes but only has %d", rawSize, len(data))
+		}
+		return data[:rawSize], nil
+	case rtfCompressed:
+	default:
+		return nil, errors.Errorf("unknown RTF compression type %#x", compType)
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrebuffer)
+	write := len(rtfPrebuffer)
+	prealloc := int64(rawSize)
+	if prealloc > maxRTFPrealloc {
+		prealloc = maxRTFPrealloc
+	}
+	out := make([]byte, 0, prealloc)
+	for len(data) > 0 {
+		control := data[0]
+		data = data[1:]
+		for bit := uint(0); bit < 8 && len(data) > 0; bit++ {
+			if control&(1<<bit) == 0 {
+				// Literal byte
+				dict[write%len(dict)] = data[0]
+				write++
+				out = append(out, data[0])
+				data = data[1:]
+				continue
+			}
+			// Dictionary reference
+			if len(data) < 2 {
+				return nil, errors.New("truncated dictionary reference in compressed RTF")
+			}
+			ref := binary.BigEndian.Uint16(data)
+			data = data[2:]
+			offset := int(ref >> 4)
+			length := int(ref&0xF) + 2
+			if offset == write%len(dict) {
+				return out, nil
+			}
+			for i := 0; i < length; i++ {
+				c := dict[(offset+i)%len(dict)]
+				dict[write%len(dict)] = c
+				write++
+				out = append(out, c)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package message
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"runtime"
+	"testing"
+)
+
+// The examples from MS-OXRTFCP section 3.1
+var rtfExamples = []struct {
+	compressed string
+	raw        string
+}{
+	{"1a0000001c0000004c5a4675e2d44b51410004205758595a0d6e7d010eb0", "{\\rtf1 WXYZWXYZWXYZWXYZWXYZ}"},
+	{"2d0000002b0000004c5a4675f1c5c7a703000a007263706731323542320af32068656c090020627705b06c647d0a800fa0", "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"},
+}
+
+func TestDecompressRTF(t *testing.T) {
+	for _, ex := range rtfExamples {
+		b, err := hex.DecodeString(ex.compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decompressRTF(b)
+		if err != nil {
+			t.Fatalf("%q: %v", ex.raw, err)
+		}
+		if string(got) != ex.raw {
+			t.Errorf("got %q, want %q", got, ex.raw)
+		}
+	}
+}
+
+func TestDecompressRTFUncompressed(t *testing.T) {
+	b := make([]byte, 16, 32)
+	binary.LittleEndian.PutUint32(b[0:], 12+5)
+	binary.LittleEndian.PutUint32(b[4:], 5)
+	binary.LittleEndian.PutUint32(b[8:], rtfUncompressed)
+	b = append(b, "{\\rtf}"...)
+	got, err := decompressRTF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{\\rtf" {
+		t.Errorf("got %q", got)
+	}
+	binary.LittleEndian.PutUint32(b[4:], 100)
+	if _, err := decompressRTF(b); err == nil {
+		t.Error("expected an error for a RawSize larger than the data")
+	}
+}
+
+// TestDecompressRTFRawSize makes sure a huge RawSize is not trusted for the allocation
+func TestDecompressRTFRawSize(t *testing.T) {
+	b, _ := hex.DecodeString(rtfExamples[0].compressed)
+	binary.LittleEndian.PutUint32(b[4:], 0xFFFFFFFF)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := decompressRTF(b); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 4*maxRTFPrealloc {
+		t.Errorf("allocated %d bytes for a 30 byte body", n)
+	}
+	if _, err := decompressRTF(b[:10]); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
@@ -0,0 +1,106 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// findPart searches a (possibly nested) MIME entity for the rpmsg attachment
+func findPart(header textproto.MIMEHeader, body io.Reader) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil, nil
+			} else if err != nil {
+				return nil, errors.Wrap(err, "failed to read MIME part")
+			}
+			if b, err := findPart(part.Header, part); err != nil || b != nil {
+				return b, err
+			}
+		}
+	}
+
+	name := params["name"]
+	if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispositionParams["filename"] != "" {
+		name = dispositionParams["filename"]
+	}
+	if !isRPMSG(name, mediaType) {
+		return nil, nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+	case "", "7bit", "8bit", "binary":
+	default:
+		return nil, errors.Errorf("unsupported Content-Transfer-Encoding %s", header.Get("Content-Transfer-Encoding"))
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode attachment")
+	}
+	return b, nil
+}
+
+// newlineStripper removes whitespace so base64 decoding tolerates line breaks
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	out := p[:0]
+	for _, c := range p[:n] {
+		if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+			out = append(out, c)
+		}
+	}
+	return len(out), err
+}
+
+// ReadMIME reads the rpmsg attachment and headers from a RFC 5322 (.eml) message
+func ReadMIME(r io.Reader) (*Wrapper, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read message")
+	}
+	b, err := findPart(textproto.MIMEHeader(m.Header), m.Body)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, errNoRPMSG
+	}
+	return &Wrapper{Header: m.Header, RPMSG: b}, nil
+}
+
+// compoundMagic is the signature of a compound (ex: .msg) file
+var compoundMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// IsMSG reports if b is a compound file, as an Outlook .msg file is
+func IsMSG(b []byte) bool {
+	return bytes.HasPrefix(b, compoundMagic)
+}
+
+// Read reads the rpmsg attachment from either an Outlook .msg file or a RFC 5322 message
+func Read(b []byte) (*Wrapper, error) {
+	if IsMSG(b) {
+		return ReadMSG(bytes.NewReader(b))
+	}
+	return ReadMIME(bytes.NewReader(b))
+}
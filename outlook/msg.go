@@ -0,0 +1,151 @@
+package outlook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/richardlehane/mscfb"
+
+	"github.com/pkg/errors"
+)
+
+// https://docs.microsoft.com/en-us/openspecs/exchange_server_protocols/ms-oxmsg/
+const (
+	propertyStreamPrefix = "__substg1.0_"
+	attachStoragePrefix  = "__attach_version1.0_#"
+)
+
+// Property IDs used to find the rpmsg and the headers
+const (
+	PidTagSubject                 = 0x0037
+	PidTagTransportMessageHeaders = 0x007D
+	PidTagAttachDataBinary        = 0x3701
+	PidTagAttachLongFilename      = 0x3707
+	PidTagAttachMimeTag           = 0x370E
+)
+
+// Property types of the streams we read
+const (
+	ptypString8 = 0x001E
+	ptypString  = 0x001F
+	ptypBinary  = 0x0102
+)
+
+// property is the type and raw value of a variable length property
+type property struct {
+	typ  uint16
+	data []byte
+}
+
+// properties are the variable length properties of a message or attachment keyed by ID
+type properties map[uint16]property
+
+// string decodes a PtypString (UTF-16) or PtypString8 property
+func (p properties) string(id uint16) string {
+	prop, ok := p[id]
+	if !ok {
+		return ""
+	}
+	b := prop.data
+	if prop.typ == ptypString8 {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+// parseTag parses the property ID and type from a __substg1.0_ stream name
+func parseTag(name string) (uint16, uint16, bool) {
+	if !strings.HasPrefix(name, propertyStreamPrefix) || len(name) != len(propertyStreamPrefix)+8 {
+		return 0, 0, false
+	}
+	tag, err := strconv.ParseUint(name[len(propertyStreamPrefix):], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint16(tag >> 16), uint16(tag), true
+}
+
+// ReadMSG reads the rpmsg attachment and headers from an Outlook .msg file
+func ReadMSG(ra io.ReaderAt) (*Wrapper, error) {
+	doc, err := mscfb.New(ra)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start compound reader")
+	}
+
+	message := make(properties)
+	attachments := make(map[string]properties)
+	var order []string
+	for _, f := range doc.File {
+		id, typ, ok := parseTag(f.Name)
+		if !ok || (typ != ptypString && typ != ptypString8 && typ != ptypBinary) {
+			continue
+		}
+		// Only the top-level message and its direct attachments, not embedded messages
+		var props properties
+		switch {
+		case len(f.Path) == 0:
+			props = message
+		case len(f.Path) == 1 && strings.HasPrefix(f.Path[0], attachStoragePrefix):
+			props, ok = attachments[f.Path[0]]
+			if !ok {
+				props = make(properties)
+				attachments[f.Path[0]] = props
+				order = append(order, f.Path[0])
+			}
+		default:
+			continue
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read property stream %s", f.Name)
+		}
+		props[id] = property{typ: typ, data: b}
+	}
+
+	for _, storage := range order {
+		props := attachments[storage]
+		if !isRPMSG(props.string(PidTagAttachLongFilename), props.string(PidTagAttachMimeTag)) {
+			continue
+		}
+		data, ok := props[PidTagAttachDataBinary]
+		if !ok || data.typ != ptypBinary {
+			return nil, errors.Errorf("attachment %s does not have any data", storage)
+		}
+		header, err := parseHeader(message.string(PidTagTransportMessageHeaders))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse transport message headers")
+		}
+		if header.Get("Subject") == "" {
+			if subject := message.string(PidTagSubject); subject != "" {
+				header["Subject"] = []string{subject}
+			}
+		}
+		return &Wrapper{Header: header, RPMSG: data.data}, nil
+	}
+	return nil, errNoRPMSG
+}
+
+// parseHeader parses RFC 5322 headers (ex: PidTagTransportMessageHeaders)
+func parseHeader(s string) (mail.Header, error) {
+	if strings.TrimSpace(s) == "" {
+		return make(mail.Header), nil
+	}
+	r := textproto.NewReader(bufio.NewReader(io.MultiReader(strings.NewReader(s), bytes.NewReader([]byte("\r\n\r\n")))))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return mail.Header(h), nil
+}
@@ -0,0 +1,30 @@
+package outlook
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Well known name and MIME type of the rpmsg attachment
+const (
+	RPMSGFileName    = "message.rpmsg"
+	RPMSGContentType = "application/x-microsoft-rpmsg-message"
+)
+
+// Wrapper is the outer (unprotected) message a rpmsg is attached to
+type Wrapper struct {
+	// Header of the outer message (ex: From, To, Subject)
+	Header mail.Header
+	// RPMSG is the contents of the message.rpmsg attachment
+	RPMSG []byte
+}
+
+// isRPMSG reports if an attachment is the rpmsg
+func isRPMSG(name string, contentType string) bool {
+	return strings.EqualFold(name, RPMSGFileName) || strings.EqualFold(contentType, RPMSGContentType)
+}
+
+// errNoRPMSG is returned when the wrapper does not have a rpmsg attachment
+var errNoRPMSG = errors.Errorf("message does not have a %s attachment", RPMSGFileName)
@@ -0,0 +1,182 @@
+package outlook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/bored-engineer/rms/cfb"
+)
+
+// fixtureRPMSG is the attachment in testdata/wrapper.eml
+var fixtureRPMSG = append([]byte{0x76, 0xe8, 0x04, 0x60, 0xc4, 0x11, 0xe3, 0x86, 1, 2, 3, 4}, "rpmsg payload for tests"...)
+
+func TestReadMIME(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/wrapper.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.RPMSG, fixtureRPMSG) {
+		t.Errorf("RPMSG is %q", w.RPMSG)
+	}
+	if got := w.Header.Get("From"); got != "Alice <alice@contoso.com>" {
+		t.Errorf("From is %q", got)
+	}
+
+	if _, err := ReadMIME(bytes.NewReader([]byte("Subject: plain\r\n\r\nno attachment\r\n"))); err != errNoRPMSG {
+		t.Errorf("got %v, want errNoRPMSG", err)
+	}
+}
+
+// utf16LE encodes s as a NUL terminated PtypString
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// buildMSG creates a minimal .msg with an unrelated attachment followed by the rpmsg
+func buildMSG(t *testing.T, headers string) []byte {
+	t.Helper()
+	w := cfb.NewWriter()
+	streams := map[string][]byte{
+		"__substg1.0_0037001F":                               utf16LE("Réunion"),
+		"__attach_version1.0_#00000000/__substg1.0_3707001F": utf16LE("notes.txt"),
+		"__attach_version1.0_#00000000/__substg1.0_37010102": []byte("unrelated"),
+		"__attach_version1.0_#00000001/__substg1.0_3707001E": []byte("message.rpmsg\x00"),
+		"__attach_version1.0_#00000001/__substg1.0_37010102": fixtureRPMSG,
+	}
+	if headers != "" {
+		streams["__substg1.0_007D001F"] = utf16LE(headers)
+	}
+	for p, data := range streams {
+		if err := w.Create(p, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadMSG(t *testing.T) {
+	b := buildMSG(t, "From: alice@contoso.com\r\nTo: bob@contoso.com\r\n")
+	if !IsMSG(b) {
+		t.Fatal("IsMSG is false for a compound file")
+	}
+	w, err := Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.RPMSG, fixtureRPMSG) {
+		t.Errorf("RPMSG is %q", w.RPMSG)
+	}
+	// The subject falls back to PidTagSubject when the transport headers don't have one
+	if got := w.Header.Get("Subject"); got != "Réunion" {
+		t.Errorf("Subject is %q", got)
+	}
+	if got := w.Header.Get("To"); got != "bob@contoso.com" {
+		t.Errorf("To is %q", got)
+	}
+
+	w, err = Read(buildMSG(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header.Get("Subject"); got != "Réunion" {
+		t.Errorf("Subject without transport headers is %q", got)
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	for name, want := range map[string][3]uint32{
+		"__substg1.0_37010102":  {PidTagAttachDataBinary, ptypBinary, 1},
+		"__substg1.0_0037001F":  {PidTagSubject, ptypString, 1},
+		"__substg1.0_0037001":   {0, 0, 0},
+		"__properties_version1": {0, 0, 0},
+		"__substg1.0_XYZW001F":  {0, 0, 0},
+	} {
+		id, typ, ok := parseTag(name)
+		if uint32(id) != want[0] || uint32(typ) != want[1] || ok != (want[2] == 1) {
+			t.Errorf("%s: got %#x %#x %v", name, id, typ, ok)
+		}
+	}
+}
+
+func TestWriteHeader(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteHeader(&buf, textproto.MIMEHeader{
+		"Subject": {"Réunion"},
+		"To":      {"Zoë <zoe@contoso.com>, bob@contoso.com"},
+		"X-Plain": {"ascii"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range buf.String() {
+		if c >= 0x80 {
+			t.Fatalf("header is not ASCII:\n%s", buf.String())
+		}
+	}
+	if !strings.HasSuffix(buf.String(), "X-Plain: ascii\r\n\r\n") {
+		t.Errorf("headers are not sorted:\n%s", buf.String())
+	}
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec mime.WordDecoder
+	if subject, err := dec.DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != "Réunion" {
+		t.Errorf("Subject is %q %v", subject, err)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(to) != 2 || to[0].Name != "Zoë" || to[0].Address != "zoe@contoso.com" || to[1].Address != "bob@contoso.com" {
+		t.Errorf("To is %v", to)
+	}
+}
+
+func TestWriteMIME(t *testing.T) {
+	rpmsg := bytes.Repeat(fixtureRPMSG, 10)
+	in := &Wrapper{
+		Header: mail.Header{
+			"Subject":      {"Réunion"},
+			"Content-Type": {"text/plain"},
+		},
+		RPMSG: rpmsg,
+	}
+	var buf bytes.Buffer
+	if err := in.WriteMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadMIME(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.RPMSG, rpmsg) {
+		t.Errorf("RPMSG is %q", out.RPMSG)
+	}
+	if got := out.Header.Get("Content-Class"); got != RPMSGContentClass {
+		t.Errorf("Content-Class is %q", got)
+	}
+	var dec mime.WordDecoder
+	if subject, err := dec.DecodeHeader(out.Header.Get("Subject")); err != nil || subject != "Réunion" {
+		t.Errorf("Subject is %q %v", subject, err)
+	}
+}
@@ -0,0 +1,143 @@
+package outlook
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// RPMSGContentClass marks a RFC 5322 message as a protected (rpmsg) message
+const RPMSGContentClass = "rpmsg.message"
+
+// NoticeText is the body shown by clients which cannot open protected messages
+const NoticeText = "This message is protected with Rights Management. Open it with a client that supports Rights Management to read it.\r\n"
+
+// addressHeaders hold address lists, only the display names may be encoded
+var addressHeaders = map[string]bool{
+	"From":     true,
+	"Sender":   true,
+	"Reply-To": true,
+	"To":       true,
+	"Cc":       true,
+	"Bcc":      true,
+}
+
+// isASCII reports if s can be written in a header as is
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeHeader RFC 2047 encodes a header value with non-ASCII characters (ex: a subject read from a .msg)
+func encodeHeader(k string, v string) string {
+	if isASCII(v) {
+		return v
+	}
+	if addressHeaders[k] {
+		if list, err := mail.ParseAddressList(v); err == nil {
+			addrs := make([]string, len(list))
+			for i, addr := range list {
+				addrs[i] = addr.String()
+			}
+			return strings.Join(addrs, ", ")
+		}
+	}
+	return mime.QEncoding.Encode("utf-8", v)
+}
+
+// MessageHeader copies header without the Content-* and MIME-Version headers, which describe the body being replaced
+func MessageHeader(header map[string][]string) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	for k, v := range header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if strings.HasPrefix(k, "Content-") || k == "Mime-Version" {
+			continue
+		}
+		h[k] = v
+	}
+	return h
+}
+
+// WriteHeader writes h in a stable order followed by a blank line, non-ASCII values are RFC 2047 encoded
+func WriteHeader(w io.Writer, h textproto.MIMEHeader) error {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, encodeHeader(k, v)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// WriteBase64 writes b as base64 wrapped at 76 characters per line
+func WriteBase64(w io.Writer, b []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(b)
+	for len(encoded) > 0 {
+		n := 76
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// WriteMIME writes the wrapper as a RFC 5322 message with the rpmsg attached as message.rpmsg
+func (w *Wrapper) WriteMIME(out io.Writer) error {
+	mw := multipart.NewWriter(out)
+
+	// The Content-* headers of the protected message describe the inner message
+	h := MessageHeader(w.Header)
+	h.Set("MIME-Version", "1.0")
+	h.Set("Content-Class", RPMSGContentClass)
+	h.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	if err := WriteHeader(out, h); err != nil {
+		return errors.Wrap(err, "failed to write header")
+	}
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("text/plain", map[string]string{"charset": "us-ascii"})},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create notice part")
+	}
+	if _, err := io.WriteString(pw, NoticeText); err != nil {
+		return errors.Wrap(err, "failed to write notice part")
+	}
+
+	pw, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(RPMSGContentType, map[string]string{"name": RPMSGFileName})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": RPMSGFileName})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create rpmsg part")
+	}
+	if err := WriteBase64(pw, w.RPMSG); err != nil {
+		return errors.Wrap(err, "failed to write rpmsg part")
+	}
+	return mw.Close()
+}
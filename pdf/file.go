@@ -0,0 +1,313 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Indirect is an object along with its generation number
+type Indirect struct {
+	Gen   int
+	Value Object
+}
+
+// File is a parsed PDF
+type File struct {
+	// Version is the version from the header (ex: "1.7")
+	Version string
+	// Objects are keyed by object number, later definitions (incremental updates) win
+	Objects map[int]*Indirect
+	// Trailer is every trailer (or cross-reference stream) dictionary merged in order
+	Trailer Dict
+}
+
+// headerPattern matches the version in the PDF header
+var headerPattern = regexp.MustCompile(`%PDF-(\d\.\d)`)
+
+// objectPattern matches the start of an indirect object or a trailer
+var objectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b|trailer\b`)
+
+// streamData returns the data of a stream starting at l.pos (after the stream keyword)
+func (l *lexer) streamData(d Dict) ([]byte, error) {
+	// The stream keyword is followed by CRLF or LF (but some writers use CR)
+	if l.pos < len(l.b) && l.b[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+	// Trust a direct /Length if endstream follows it, otherwise search for endstream
+	if length, ok := d["Length"].(Raw); ok {
+		if n, ok := length.Int(); ok && n >= 0 && int64(start)+n <= int64(len(l.b)) {
+			l.pos = start + int(n)
+			if l.keyword("endstream") {
+				return l.b[start : start+int(n)], nil
+			}
+		}
+	}
+	end := bytes.Index(l.b[start:], []byte("endstream"))
+	if end == -1 {
+		return nil, errors.Errorf("stream at offset %d is missing endstream", start)
+	}
+	l.pos = start + end + len("endstream")
+	data := l.b[start : start+end]
+	if bytes.HasSuffix(data, []byte("\r\n")) {
+		data = data[:len(data)-2]
+	} else if bytes.HasSuffix(data, []byte("\n")) || bytes.HasSuffix(data, []byte("\r")) {
+		data = data[:len(data)-1]
+	}
+	return data, nil
+}
+
+// Parse parses every object of a PDF by scanning it from start to end, the cross-reference
+// table is ignored so damaged or incrementally updated files still parse
+func Parse(b []byte) (*File, error) {
+	f := &File{
+		Version: "1.7",
+		Objects: make(map[int]*Indirect),
+		Trailer: make(Dict),
+	}
+	m := headerPattern.FindSubmatch(b)
+	if m == nil {
+		return nil, errors.New("file does not have a PDF header")
+	}
+	f.Version = string(m[1])
+
+	l := &lexer{b: b}
+	for {
+		loc := objectPattern.FindSubmatchIndex(b[l.pos:])
+		if loc == nil {
+			break
+		}
+		base := l.pos
+		start := base + loc[0]
+		l.pos = base + loc[1]
+		if loc[2] == -1 {
+			v, err := l.value()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse trailer at offset %d", start)
+			}
+			if d, ok := v.(Dict); ok {
+				f.merge(d)
+			}
+			continue
+		}
+
+		num, _ := strconv.Atoi(string(b[base+loc[2] : base+loc[3]]))
+		gen, _ := strconv.Atoi(string(b[base+loc[4] : base+loc[5]]))
+		v, err := l.value()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse object %d at offset %d", num, start)
+		}
+		if d, ok := v.(Dict); ok && l.keyword("stream") {
+			data, err := l.streamData(d)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse object %d", num)
+			}
+			v = &Stream{Dict: d, Data: data}
+			// Cross-reference streams double as the trailer
+			if d.Name("Type") == "XRef" {
+				f.merge(d)
+			}
+		}
+		l.keyword("endobj")
+		f.Objects[num] = &Indirect{Gen: gen, Value: v}
+	}
+	if len(f.Objects) == 0 {
+		return nil, errors.New("PDF does not have any objects")
+	}
+	return f, nil
+}
+
+// trailerKeys are the trailer entries which describe the document rather than the file layout
+var trailerKeys = []Name{"Root", "Info", "ID", "Encrypt"}
+
+// merge adds the document entries of a trailer dictionary, later trailers win
+func (f *File) merge(d Dict) {
+	for _, k := range trailerKeys {
+		if v, ok := d[k]; ok {
+			f.Trailer[k] = v
+		}
+	}
+}
+
+// Resolve follows o if it is an indirect reference
+func (f *File) Resolve(o Object) Object {
+	for i := 0; i < 32; i++ {
+		ref, ok := o.(Ref)
+		if !ok {
+			return o
+		}
+		obj, ok := f.Objects[ref.Num]
+		if !ok {
+			return nil
+		}
+		o = obj.Value
+	}
+	return nil
+}
+
+// Decode returns the decoded data of a stream, only FlateDecode without predictors is supported
+func (f *File) Decode(s *Stream) ([]byte, error) {
+	var filters Array
+	switch v := f.Resolve(s.Dict["Filter"]).(type) {
+	case nil:
+	case Name:
+		filters = Array{v}
+	case Array:
+		filters = v
+	default:
+		return nil, errors.Errorf("unexpected /Filter %T", v)
+	}
+	params := f.Resolve(s.Dict["DecodeParms"])
+	data := s.Data
+	for i, filter := range filters {
+		switch f.Resolve(filter) {
+		case Name("FlateDecode"), Name("Fl"):
+		default:
+			return nil, errors.Errorf("unsupported filter %v", filter)
+		}
+		p := params
+		if a, ok := params.(Array); ok && i < len(a) {
+			p = f.Resolve(a[i])
+		}
+		if d, ok := p.(Dict); ok {
+			if predictor, ok := intValue(d["Predictor"]); ok && predictor > 1 {
+				return nil, errors.Errorf("unsupported predictor %d", predictor)
+			}
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to start zlib reader")
+		}
+		// Many writers truncate the final checksum, keep whatever was inflated
+		decoded, err := ioutil.ReadAll(zr)
+		if err != nil && len(decoded) == 0 {
+			return nil, errors.Wrap(err, "failed to inflate stream")
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+// ExpandObjectStreams moves the objects out of every object stream (and drops cross-reference
+// streams) so the file can be written with a plain cross-reference table
+func (f *File) ExpandObjectStreams() error {
+	for num, obj := range f.Objects {
+		s, ok := obj.Value.(*Stream)
+		if !ok {
+			continue
+		}
+		switch s.Dict.Name("Type") {
+		case "XRef":
+			delete(f.Objects, num)
+		case "ObjStm":
+			if err := f.expand(s); err != nil {
+				return errors.Wrapf(err, "failed to expand object stream %d", num)
+			}
+			delete(f.Objects, num)
+		}
+	}
+	return nil
+}
+
+// expand adds the objects of an object stream, objects already defined outside it win
+func (f *File) expand(s *Stream) error {
+	data, err := f.Decode(s)
+	if err != nil {
+		return err
+	}
+	n, _ := intValue(f.Resolve(s.Dict["N"]))
+	first, _ := intValue(f.Resolve(s.Dict["First"]))
+	if first < 0 || first > int64(len(data)) {
+		return errors.Errorf("/First %d is outside of the stream", first)
+	}
+	header := &lexer{b: data[:first]}
+	for i := int64(0); i < n; i++ {
+		num, err := header.value()
+		if err != nil {
+			return errors.Wrap(err, "failed to read object number")
+		}
+		off, err := header.value()
+		if err != nil {
+			return errors.Wrap(err, "failed to read object offset")
+		}
+		objNum, ok1 := intValue(num)
+		objOff, ok2 := intValue(off)
+		if !ok1 || !ok2 || objOff < 0 || first+objOff > int64(len(data)) {
+			return errors.Errorf("invalid entry %d in object stream header", i)
+		}
+		if _, ok := f.Objects[int(objNum)]; ok {
+			continue
+		}
+		l := &lexer{b: data, pos: int(first + objOff)}
+		v, err := l.value()
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse object %d", objNum)
+		}
+		f.Objects[int(objNum)] = &Indirect{Value: v}
+	}
+	return nil
+}
+
+// WriteTo writes the file with a rebuilt cross-reference table
+func (f *File) WriteTo(out io.Writer) (int64, error) {
+	nums := make([]int, 0, len(f.Objects))
+	for num := range f.Objects {
+		if num > 0 {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+
+	w := newWriter(out)
+	// The binary comment marks the file as binary for transfer programs
+	w.WriteString("%PDF-" + f.Version + "\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make(map[int]int64, len(nums))
+	for _, num := range nums {
+		obj := f.Objects[num]
+		offsets[num] = w.off
+		w.WriteString(strconv.Itoa(num) + " " + strconv.Itoa(obj.Gen) + " obj\n")
+		w.object(obj.Value)
+		w.WriteString("\nendobj\n")
+	}
+
+	size := 1
+	if len(nums) > 0 {
+		size = nums[len(nums)-1] + 1
+	}
+	xref := w.off
+	w.WriteString("xref\n0 " + strconv.Itoa(size) + "\n")
+	for num := 0; num < size; num++ {
+		if off, ok := offsets[num]; ok {
+			w.WriteString(padInt(off, 10) + " " + padInt(int64(f.Objects[num].Gen), 5) + " n\r\n")
+		} else {
+			w.WriteString("0000000000 65535 f\r\n")
+		}
+	}
+	trailer := Dict{"Size": Raw(strconv.Itoa(size))}
+	for k, v := range f.Trailer {
+		trailer[k] = v
+	}
+	w.WriteString("trailer\n")
+	w.object(trailer)
+	w.WriteString("\nstartxref\n" + strconv.FormatInt(xref, 10) + "\n%%EOF\n")
+	return w.off, w.flush()
+}
+
+// padInt formats i with leading zeros to width digits
+func padInt(i int64, width int) string {
+	s := strconv.FormatInt(i, 10)
+	for len(s) < width {
+		s = "0" + s
+	}
+	return s
+}
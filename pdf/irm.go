@@ -0,0 +1,349 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/base64"
+	"io/ioutil"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the MicrosoftIRMServices security handler and the encrypted payload it protects
+const (
+	SecurityHandler  = "MicrosoftIRMServices"
+	EncryptedPayload = "EncryptedPayload"
+)
+
+// ErrNotProtected is returned for a PDF which is neither encrypted nor wraps an encrypted payload
+var ErrNotProtected = errors.New("PDF is not protected")
+
+// Magic is the header of every PDF
+var Magic = []byte("%PDF-")
+
+// IsPDF reports if b starts with the PDF header
+func IsPDF(b []byte) bool {
+	return bytes.HasPrefix(b, Magic)
+}
+
+// Protected is a PDF encrypted with the MicrosoftIRMServices security handler
+type Protected struct {
+	// File is the encrypted PDF (the payload, not the wrapper)
+	File *File
+	// Encrypt is the encryption dictionary
+	Encrypt Dict
+	// PublishingLicense is the XrML license from the encryption dictionary
+	PublishingLicense []byte
+}
+
+// payload returns the encrypted payload embedded in a wrapper document (ISO 32000-2 7.6.7)
+func (f *File) payload() ([]byte, error) {
+	for _, obj := range f.Objects {
+		spec, ok := obj.Value.(Dict)
+		if !ok || (spec.Name("AFRelationship") != EncryptedPayload && spec["EP"] == nil) {
+			continue
+		}
+		if ep, ok := f.Resolve(spec["EP"]).(Dict); ok {
+			if subtype := ep.Name("Subtype"); subtype != "" && subtype != SecurityHandler {
+				return nil, errors.Errorf("encrypted payload uses the %s security handler", subtype)
+			}
+		}
+		ef, ok := f.Resolve(spec["EF"]).(Dict)
+		if !ok {
+			continue
+		}
+		for _, key := range []Name{"F", "UF"} {
+			if s, ok := f.Resolve(ef[key]).(*Stream); ok {
+				return f.Decode(s)
+			}
+		}
+	}
+	return nil, ErrNotProtected
+}
+
+// license extracts the publishing license, some writers compress or base64 encode it
+func (f *File) license(o Object) ([]byte, error) {
+	var b []byte
+	switch v := f.Resolve(o).(type) {
+	case String:
+		b = v
+	case *Stream:
+		var err error
+		if b, err = f.Decode(v); err != nil {
+			return nil, errors.Wrap(err, "failed to decode PublishingLicense")
+		}
+	default:
+		return nil, errors.Errorf("unexpected /PublishingLicense %T", v)
+	}
+	if !bytes.Contains(b, []byte("<")) {
+		if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b))); err == nil {
+			b = decoded
+		}
+	}
+	if zr, err := zlib.NewReader(bytes.NewReader(b)); err == nil {
+		if inflated, err := ioutil.ReadAll(zr); err == nil {
+			b = inflated
+		}
+	}
+	if len(b) == 0 {
+		return nil, errors.New("PublishingLicense is empty")
+	}
+	return b, nil
+}
+
+// ReadProtected parses a protected PDF, unwrapping the encrypted payload if needed
+func ReadProtected(b []byte) (*Protected, error) {
+	f, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if f.Trailer["Encrypt"] == nil {
+		payload, err := f.payload()
+		if err != nil {
+			return nil, err
+		}
+		if f, err = Parse(payload); err != nil {
+			return nil, errors.Wrap(err, "failed to parse encrypted payload")
+		}
+	}
+	encrypt, ok := f.Resolve(f.Trailer["Encrypt"]).(Dict)
+	if !ok {
+		return nil, ErrNotProtected
+	} else if filter := encrypt.Name("Filter"); filter != SecurityHandler {
+		return nil, errors.Errorf("PDF uses the %s security handler, not %s", filter, SecurityHandler)
+	}
+	license, err := f.license(encrypt["PublishingLicense"])
+	if err != nil {
+		return nil, err
+	}
+	return &Protected{
+		File:              f,
+		Encrypt:           encrypt,
+		PublishingLicense: license,
+	}, nil
+}
+
+// Crypt filter methods
+const (
+	methodNone  = "None"
+	methodRC4   = "V2"
+	methodAESV2 = "AESV2"
+	methodAESV3 = "AESV3"
+)
+
+// method returns the crypt filter method for the filter named by key (StmF or StrF)
+func (p *Protected) method(key Name, fileKey []byte) string {
+	v, _ := intValue(p.File.Resolve(p.Encrypt["V"]))
+	switch v {
+	case 1, 2, 3:
+		return methodRC4
+	case 4, 5:
+		name := p.Encrypt.Name(key)
+		if name == "" || name == "Identity" {
+			return methodNone
+		}
+		filters, _ := p.File.Resolve(p.Encrypt["CF"]).(Dict)
+		filter, _ := p.File.Resolve(filters[name]).(Dict)
+		if cfm := filter.Name("CFM"); cfm != "" {
+			return string(cfm)
+		}
+		return methodNone
+	}
+	// Without a version pick AES based on the size of the content key
+	if len(fileKey) == 32 {
+		return methodAESV3
+	}
+	return methodAESV2
+}
+
+// CryptFilter returns the crypt filter method of the streams (ex: AESV2), empty if it depends on the content key
+func (p *Protected) CryptFilter() string {
+	if p.Encrypt["V"] == nil {
+		return ""
+	}
+	return p.method("StmF", nil)
+}
+
+// decrypter decrypts the strings and streams of a single object
+type decrypter struct {
+	stream, str string
+	fileKey     []byte
+}
+
+// objectKey derives the key of an object (ISO 32000-1 7.6.2 algorithm 1), AESV3 uses the file key as-is
+func (d *decrypter) objectKey(method string, num int, gen int) []byte {
+	if method == methodAESV3 {
+		return d.fileKey
+	}
+	h := md5.New()
+	h.Write(d.fileKey)
+	h.Write([]byte{byte(num), byte(num >> 8), byte(num >> 16), byte(gen), byte(gen >> 8)})
+	if method == methodAESV2 {
+		h.Write([]byte("sAlT"))
+	}
+	n := len(d.fileKey) + 5
+	if n > 16 {
+		n = 16
+	}
+	return h.Sum(nil)[:n]
+}
+
+// decrypt decrypts b with method using the key of object num
+func (d *decrypter) decrypt(method string, num int, gen int, b []byte) ([]byte, error) {
+	switch method {
+	case methodNone:
+		return b, nil
+	case methodRC4:
+		c, err := rc4.NewCipher(d.objectKey(method, num, gen))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(b))
+		c.XORKeyStream(out, b)
+		return out, nil
+	case methodAESV2, methodAESV3:
+		block, err := aes.NewCipher(d.objectKey(method, num, gen))
+		if err != nil {
+			return nil, err
+		}
+		// The IV prefixes the ciphertext, which is padded with PKCS#5
+		if len(b) < aes.BlockSize || len(b)%aes.BlockSize != 0 {
+			return nil, errors.Errorf("ciphertext size %d is not a multiple of the block size", len(b))
+		}
+		out := make([]byte, len(b)-aes.BlockSize)
+		cipher.NewCBCDecrypter(block, b[:aes.BlockSize]).CryptBlocks(out, b[aes.BlockSize:])
+		if len(out) == 0 {
+			return out, nil
+		}
+		pad := int(out[len(out)-1])
+		if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+			return nil, errors.New("invalid padding")
+		}
+		return out[:len(out)-pad], nil
+	}
+	return nil, errors.Errorf("unsupported crypt filter method %s", method)
+}
+
+// value decrypts every string in o
+func (d *decrypter) value(o Object, num int, gen int) (Object, error) {
+	switch v := o.(type) {
+	case String:
+		b, err := d.decrypt(d.str, num, gen, v)
+		if err != nil {
+			return nil, err
+		}
+		return String(b), nil
+	case Array:
+		a := make(Array, len(v))
+		for i, e := range v {
+			var err error
+			if a[i], err = d.value(e, num, gen); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case Dict:
+		out := make(Dict, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = d.value(e, num, gen); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return o, nil
+}
+
+// Decrypt decrypts every object with the content key and returns the plain PDF
+func (p *Protected) Decrypt(key *aadrm.Key) (*File, error) {
+	fileKey, err := key.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	d := &decrypter{
+		stream:  p.method("StmF", fileKey),
+		str:     p.method("StrF", fileKey),
+		fileKey: fileKey,
+	}
+	encryptRef, _ := p.File.Trailer["Encrypt"].(Ref)
+	encryptMetadata := p.Encrypt["EncryptMetadata"] != Raw("false")
+
+	out := &File{
+		Version: p.File.Version,
+		Objects: make(map[int]*Indirect, len(p.File.Objects)),
+		Trailer: make(Dict),
+	}
+	for k, v := range p.File.Trailer {
+		if k != "Encrypt" {
+			out.Trailer[k] = v
+		}
+	}
+	for num, obj := range p.File.Objects {
+		if num == encryptRef.Num && encryptRef.Num != 0 {
+			continue
+		}
+		s, isStream := obj.Value.(*Stream)
+		// Cross-reference streams are never encrypted and are dropped when writing
+		if isStream && s.Dict.Name("Type") == "XRef" {
+			continue
+		}
+		v, err := d.value(obj.Value, num, obj.Gen)
+		if isStream {
+			var dict Object
+			if dict, err = d.value(s.Dict, num, obj.Gen); err == nil {
+				data := s.Data
+				if encryptMetadata || s.Dict.Name("Type") != "Metadata" {
+					data, err = d.decrypt(d.stream, num, obj.Gen, s.Data)
+				}
+				v = &Stream{Dict: dict.(Dict), Data: data}
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt object %d", num)
+		}
+		out.Objects[num] = &Indirect{Gen: obj.Gen, Value: v}
+	}
+	if err := out.ExpandObjectStreams(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Document is a decrypted protected PDF
+type Document struct {
+	// PublishingLicense is the XrML license the PDF was protected with
+	PublishingLicense []byte
+	// EndUserLicense is the license issued for the PDF
+	EndUserLicense *aadrm.EndUserLicense
+	// File is the decrypted PDF, write it with WriteTo
+	File *File
+}
+
+// Open parses a protected PDF, fetches a user license for it using client and decrypts it
+func Open(ctx context.Context, b []byte, client aadrm.Licensor) (*Document, error) {
+	p, err := ReadProtected(b)
+	if err != nil {
+		return nil, err
+	}
+	userLicense, _, _, err := client.GetEndUserLicense(ctx, p.PublishingLicense)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request EndUserLicense")
+	}
+	f, err := p.Decrypt(userLicense.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return &Document{
+		PublishingLicense: p.PublishingLicense,
+		EndUserLicense:    userLicense,
+		File:              f,
+	}, nil
+}
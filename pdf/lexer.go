@@ -0,0 +1,249 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// isSpace reports if c is PDF whitespace
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isDelimiter reports if c is a PDF delimiter
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// lexer parses objects from b starting at pos
+type lexer struct {
+	b   []byte
+	pos int
+}
+
+// skipSpace skips whitespace and comments
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		if c == '%' {
+			for l.pos < len(l.b) && l.b[l.pos] != '\r' && l.b[l.pos] != '\n' {
+				l.pos++
+			}
+		} else if isSpace(c) {
+			l.pos++
+		} else {
+			return
+		}
+	}
+}
+
+// regular reads a token of regular characters (ex: a number or keyword)
+func (l *lexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.b) && !isSpace(l.b[l.pos]) && !isDelimiter(l.b[l.pos]) {
+		l.pos++
+	}
+	return string(l.b[start:l.pos])
+}
+
+// keyword reads the next token if it is kw
+func (l *lexer) keyword(kw string) bool {
+	l.skipSpace()
+	start := l.pos
+	if l.regular() == kw {
+		return true
+	}
+	l.pos = start
+	return false
+}
+
+// isInt reports if s is an unsigned integer
+func isInt(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// literal reads a literal string, the opening parenthesis has been consumed
+func (l *lexer) literal() (String, error) {
+	var s []byte
+	depth := 1
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return String(s), nil
+			}
+		case '\\':
+			if l.pos >= len(l.b) {
+				continue
+			}
+			c = l.b[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := c - '0'
+					for i := 0; i < 2 && l.pos < len(l.b) && l.b[l.pos] >= '0' && l.b[l.pos] <= '7'; i++ {
+						v = v*8 + l.b[l.pos] - '0'
+						l.pos++
+					}
+					c = v
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return nil, errors.New("unterminated literal string")
+}
+
+// hexString reads a hex string, the opening angle bracket has been consumed
+func (l *lexer) hexString() (String, error) {
+	end := bytes.IndexByte(l.b[l.pos:], '>')
+	if end == -1 {
+		return nil, errors.New("unterminated hex string")
+	}
+	digits := make([]byte, 0, end)
+	for _, c := range l.b[l.pos : l.pos+end] {
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	l.pos += end + 1
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	if _, err := hex.Decode(s, digits); err != nil {
+		return nil, errors.Wrap(err, "invalid hex string")
+	}
+	return String(s), nil
+}
+
+// value parses the next object
+func (l *lexer) value() (Object, error) {
+	l.skipSpace()
+	if l.pos >= len(l.b) {
+		return nil, errors.New("unexpected end of file")
+	}
+	start := l.pos
+	switch c := l.b[l.pos]; c {
+	case '/':
+		l.pos++
+		return Name(l.regular()), nil
+	case '(':
+		l.pos++
+		return l.literal()
+	case '<':
+		if l.pos+1 < len(l.b) && l.b[l.pos+1] == '<' {
+			l.pos += 2
+			return l.dict()
+		}
+		l.pos++
+		return l.hexString()
+	case '[':
+		l.pos++
+		return l.array()
+	case ')', '>', ']', '{', '}':
+		return nil, errors.Errorf("unexpected %q at offset %d", c, start)
+	}
+
+	token := l.regular()
+	if token == "" {
+		return nil, errors.Errorf("unexpected %q at offset %d", l.b[l.pos], start)
+	}
+	// An integer may be the start of an indirect reference
+	if isInt(token) {
+		save := l.pos
+		l.skipSpace()
+		if gen := l.regular(); isInt(gen) && l.keyword("R") {
+			num, _ := Raw(token).Int()
+			g, _ := Raw(gen).Int()
+			return Ref{Num: int(num), Gen: int(g)}, nil
+		}
+		l.pos = save
+	}
+	return Raw(token), nil
+}
+
+// array parses an array, the opening bracket has been consumed
+func (l *lexer) array() (Array, error) {
+	var a Array
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.b) {
+			return nil, errors.New("unterminated array")
+		} else if l.b[l.pos] == ']' {
+			l.pos++
+			return a, nil
+		}
+		v, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+}
+
+// dict parses a dictionary, the opening angle brackets have been consumed
+func (l *lexer) dict() (Dict, error) {
+	d := make(Dict)
+	for {
+		l.skipSpace()
+		if l.pos+1 < len(l.b) && l.b[l.pos] == '>' && l.b[l.pos+1] == '>' {
+			l.pos += 2
+			return d, nil
+		}
+		key, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(Name)
+		if !ok {
+			return nil, errors.Errorf("dictionary key is %T, not a name", key)
+		}
+		v, err := l.value()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse value of /%s", name)
+		}
+		d[name] = v
+	}
+}
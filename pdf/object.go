@@ -0,0 +1,150 @@
+// Package pdf reads protected PDFs using the MicrosoftIRMServices security handler (ISO 32000-2
+// encrypted payload documents) and writes the decrypted PDF.
+//
+// Only as much of PDF is implemented as is needed to do that: objects are parsed and written back
+// as-is except for decrypting their strings and streams, and the cross-reference table is rebuilt.
+package pdf
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Object is a PDF object: Raw, Name, String, Array, Dict, Ref or *Stream
+type Object interface{}
+
+// Raw is a number, boolean or null kept as it was written
+type Raw string
+
+// Int parses a Raw integer
+func (r Raw) Int() (int64, bool) {
+	i, err := strconv.ParseInt(string(r), 10, 64)
+	return i, err == nil
+}
+
+// intValue returns o as an integer if it is a Raw integer
+func intValue(o Object) (int64, bool) {
+	r, ok := o.(Raw)
+	if !ok {
+		return 0, false
+	}
+	return r.Int()
+}
+
+// Name is a name without the leading slash, #xx escapes are left as written
+type Name string
+
+// String is a literal or hex string
+type String []byte
+
+// Array is an array of objects
+type Array []Object
+
+// Dict is a dictionary keyed by name
+type Dict map[Name]Object
+
+// Ref is an indirect reference to an object
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Stream is a stream object, Data is not decoded
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+// Name returns the value of key if it is a Name
+func (d Dict) Name(key Name) Name {
+	n, _ := d[key].(Name)
+	return n
+}
+
+// writer serializes objects and tracks the offset for the cross-reference table
+type writer struct {
+	w   *bufio.Writer
+	off int64
+	err error
+}
+
+func (w *writer) WriteString(s string) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.WriteString(s)
+	w.off += int64(n)
+	w.err = err
+}
+
+func (w *writer) Write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.off += int64(n)
+	w.err = err
+}
+
+// object writes o, strings are always written as hex strings
+func (w *writer) object(o Object) {
+	switch v := o.(type) {
+	case nil:
+		w.WriteString("null")
+	case Raw:
+		w.WriteString(string(v))
+	case Name:
+		w.WriteString("/" + string(v))
+	case String:
+		w.WriteString("<" + hex.EncodeToString(v) + ">")
+	case Ref:
+		w.WriteString(strconv.Itoa(v.Num) + " " + strconv.Itoa(v.Gen) + " R")
+	case Array:
+		w.WriteString("[")
+		for i, e := range v {
+			if i > 0 {
+				w.WriteString(" ")
+			}
+			w.object(e)
+		}
+		w.WriteString("]")
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		w.WriteString("<<")
+		for _, k := range keys {
+			w.WriteString("/" + k + " ")
+			w.object(v[Name(k)])
+		}
+		w.WriteString(">>")
+	case *Stream:
+		d := make(Dict, len(v.Dict))
+		for k, e := range v.Dict {
+			d[k] = e
+		}
+		d["Length"] = Raw(strconv.Itoa(len(v.Data)))
+		w.object(d)
+		w.WriteString("\nstream\n")
+		w.Write(v.Data)
+		w.WriteString("\nendstream")
+	}
+}
+
+// newWriter creates a *writer for w
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+// flush flushes the underlying *bufio.Writer
+func (w *writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
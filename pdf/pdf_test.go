@@ -0,0 +1,290 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/internal/rmstest"
+)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile("testdata/simple.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(readFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != "1.4" || len(f.Objects) != 6 {
+		t.Fatalf("got version %s with %d objects", f.Version, len(f.Objects))
+	}
+	if f.Trailer["Root"] != (Ref{Num: 1}) {
+		t.Errorf("Root is %v", f.Trailer["Root"])
+	}
+	// The incremental update replaces object 3
+	if page := f.Resolve(Ref{Num: 3}).(Dict); page["Rotate"] != Raw("90") {
+		t.Errorf("page is %v", page)
+	}
+	info := f.Resolve(f.Trailer["Info"]).(Dict)
+	for key, want := range map[Name]string{
+		"Title":    "Nested (parens) and (balanced)\tA16",
+		"Author":   "Alic`",
+		"Keywords": "linecontinued",
+	} {
+		if got, ok := info[key].(String); !ok || string(got) != want {
+			t.Errorf("/%s is %q, want %q", key, info[key], want)
+		}
+	}
+	if s := f.Resolve(Ref{Num: 4}).(*Stream); string(s.Data) != "BT /F1 12 Tf 72 712 Td (Hello) Tj ET" {
+		t.Errorf("stream 4 is %q", s.Data)
+	}
+	// A wrong /Length falls back to searching for endstream
+	if s := f.Resolve(Ref{Num: 6}).(*Stream); string(s.Data) != "wrong length" {
+		t.Errorf("stream 6 is %q", s.Data)
+	}
+	if media := f.Resolve(Ref{Num: 3}).(Dict)["MediaBox"]; !reflect.DeepEqual(media, Array{Raw("0"), Raw("0"), Raw("612"), Raw("792")}) {
+		t.Errorf("MediaBox is %v", media)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, b := range map[string]string{
+		"no header":         "1 0 obj\n<<>>\nendobj\n",
+		"no objects":        "%PDF-1.7\n",
+		"unterminated":      "%PDF-1.7\n1 0 obj\n(open\n",
+		"bad key":           "%PDF-1.7\n1 0 obj\n<< (key) 1 >>\nendobj\n",
+		"missing endstream": "%PDF-1.7\n1 0 obj\n<< /Length 100 >>\nstream\ndata\n",
+	} {
+		if _, err := Parse([]byte(b)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	f, err := Parse(readFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d but wrote %d bytes", n, buf.Len())
+	}
+	// Every cross-reference entry must point at its object
+	out := buf.Bytes()
+	xref := bytes.Index(out, []byte("\nxref\n")) + 1
+	for num := 1; num <= 6; num++ {
+		entry := out[xref+len("xref\n0 7\n")+num*20:]
+		off := 0
+		for _, c := range entry[:10] {
+			off = off*10 + int(c-'0')
+		}
+		if prefix := []byte(strconv.Itoa(num) + " 0 obj"); !bytes.HasPrefix(out[off:], prefix) {
+			t.Errorf("cross-reference entry of object %d points at %q", num, out[off:off+10])
+		}
+	}
+	g, err := Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// WriteTo corrects the /Length
+	f.Objects[6].Value.(*Stream).Dict["Length"] = Raw("12")
+	if !reflect.DeepEqual(f.Objects, g.Objects) || !reflect.DeepEqual(f.Trailer, g.Trailer) {
+		t.Error("the written file does not parse to the same objects")
+	}
+}
+
+// encryptAES encrypts b with a random IV and PKCS#5 padding the way a conforming writer does
+func encryptAES(t *testing.T, key []byte, b []byte) String {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(b)%aes.BlockSize
+	plaintext := append(append([]byte{}, b...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plaintext)
+	return out
+}
+
+// objectKeyAESV2 is ISO 32000-1 7.6.2 algorithm 1 for AESV2
+func objectKeyAESV2(fileKey []byte, num int) []byte {
+	h := md5.New()
+	h.Write(fileKey)
+	h.Write([]byte{byte(num), byte(num >> 8), byte(num >> 16), 0, 0})
+	h.Write([]byte("sAlT"))
+	return h.Sum(nil)
+}
+
+var testLicense = rmstest.License("pdf")
+
+// buildProtected writes a PDF encrypted by the MicrosoftIRMServices handler with fileKey using method
+func buildProtected(t *testing.T, fileKey []byte, method Name) []byte {
+	t.Helper()
+	objectKey := func(num int) []byte {
+		if method == methodAESV3 {
+			return fileKey
+		}
+		return objectKeyAESV2(fileKey, num)
+	}
+	// The license is compressed like some writers do
+	var license bytes.Buffer
+	zw := zlib.NewWriter(&license)
+	zw.Write(testLicense)
+	zw.Close()
+
+	f := &File{
+		Version: "1.7",
+		Objects: map[int]*Indirect{
+			1: {Value: Dict{"Type": Name("Catalog"), "Pages": Ref{Num: 2}}},
+			2: {Value: Dict{"Type": Name("Pages"), "Kids": Array{}, "Count": Raw("0")}},
+			3: {Value: Dict{"Title": encryptAES(t, objectKey(3), []byte("Secret title"))}},
+			4: {Value: &Stream{Dict: Dict{}, Data: encryptAES(t, objectKey(4), []byte("BT (Secret) Tj ET"))}},
+			5: {Value: Dict{
+				"Filter":            Name(SecurityHandler),
+				"V":                 Raw("4"),
+				"CF":                Dict{"StdCF": Dict{"CFM": method}},
+				"StmF":              Name("StdCF"),
+				"StrF":              Name("StdCF"),
+				"PublishingLicense": String(license.Bytes()),
+			}},
+		},
+		Trailer: Dict{"Root": Ref{Num: 1}, "Info": Ref{Num: 3}, "Encrypt": Ref{Num: 5}},
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkDecrypted(t *testing.T, doc *Document) {
+	t.Helper()
+	if !bytes.Equal(doc.PublishingLicense, testLicense) {
+		t.Errorf("PublishingLicense is %q", doc.PublishingLicense)
+	}
+	f := doc.File
+	if f.Trailer["Encrypt"] != nil || f.Objects[5] != nil {
+		t.Error("the encryption dictionary was not removed")
+	}
+	if title := f.Resolve(f.Trailer["Info"]).(Dict)["Title"]; !reflect.DeepEqual(title, String("Secret title")) {
+		t.Errorf("Title is %q", title)
+	}
+	if s := f.Resolve(Ref{Num: 4}).(*Stream); string(s.Data) != "BT (Secret) Tj ET" {
+		t.Errorf("stream is %q", s.Data)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, tc := range []struct {
+		method Name
+		size   int
+	}{{methodAESV2, 16}, {methodAESV3, 32}} {
+		key, err := aadrm.GenerateKey("MICROSOFT.ECB", tc.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileKey, err := key.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := buildProtected(t, fileKey, tc.method)
+		p, err := ReadProtected(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.CryptFilter(); got != string(tc.method) {
+			t.Errorf("CryptFilter is %s, want %s", got, tc.method)
+		}
+		doc, err := Open(context.Background(), b, &rmstest.Licensor{Key: key})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.method, err)
+		}
+		checkDecrypted(t, doc)
+
+		// The decrypted file is written without an /Encrypt entry and still parses
+		var buf bytes.Buffer
+		if _, err := doc.File.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadProtected(buf.Bytes()); !errors.Is(err, ErrNotProtected) {
+			t.Errorf("got %v for the decrypted file, want ErrNotProtected", err)
+		}
+	}
+}
+
+// TestOpenWrapper decrypts a protected PDF embedded as the encrypted payload of a wrapper document
+func TestOpenWrapper(t *testing.T) {
+	key, err := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, _ := key.Bytes()
+	wrapper := &File{
+		Version: "2.0",
+		Objects: map[int]*Indirect{
+			1: {Value: Dict{"Type": Name("Catalog"), "AF": Array{Ref{Num: 2}}}},
+			2: {Value: Dict{
+				"Type":           Name("Filespec"),
+				"AFRelationship": Name(EncryptedPayload),
+				"EP":             Dict{"Subtype": Name(SecurityHandler)},
+				"EF":             Dict{"F": Ref{Num: 3}},
+			}},
+			3: {Value: &Stream{Dict: Dict{"Type": Name("EmbeddedFile")}, Data: buildProtected(t, fileKey, methodAESV2)}},
+		},
+		Trailer: Dict{"Root": Ref{Num: 1}},
+	}
+	var buf bytes.Buffer
+	if _, err := wrapper.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Open(context.Background(), buf.Bytes(), &rmstest.Licensor{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDecrypted(t, doc)
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(context.Background(), readFixture(t), &rmstest.Licensor{}); !errors.Is(err, ErrNotProtected) {
+		t.Errorf("got %v, want ErrNotProtected", err)
+	}
+
+	key, err := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, _ := key.Bytes()
+	b := buildProtected(t, fileKey, methodAESV2)
+	if _, err := Open(context.Background(), b, &rmstest.Licensor{Key: key, Denied: map[string]bool{"pdf": true}}); !errors.Is(err, aadrm.ErrAccessDenied) {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+	// The wrong key fails the padding check instead of returning garbage
+	other, _ := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	if _, err := Open(context.Background(), b, &rmstest.Licensor{Key: other}); err == nil {
+		t.Error("expected an error decrypting with the wrong key")
+	}
+}
@@ -0,0 +1,50 @@
+package pfile
+
+import (
+	"context"
+	"io"
+
+	"github.com/bored-engineer/rms/aadrm"
+
+	"github.com/pkg/errors"
+)
+
+// NewDecrypter returns the plaintext of the PFile read from r, decrypted with key as it is read
+func (h *Header) NewDecrypter(r io.ReaderAt, size int64, key *aadrm.Key) (*io.SectionReader, error) {
+	content := io.NewSectionReader(r, h.ContentOffset, size-h.ContentOffset)
+	d, err := key.NewDecrypter(content, content.Size())
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(d, 0, h.OriginalSize), nil
+}
+
+// File is an opened PFile, reads return the decrypted plaintext
+type File struct {
+	*io.SectionReader
+	// Header of the PFile
+	Header *Header
+	// EndUserLicense is the license issued for the PFile
+	EndUserLicense *aadrm.EndUserLicense
+}
+
+// Open parses the header of a PFile of size bytes read from r and fetches a user license for it using client
+func Open(ctx context.Context, r io.ReaderAt, size int64, client aadrm.Licensor) (*File, error) {
+	h, err := ReadHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	userLicense, _, _, err := client.GetEndUserLicense(ctx, h.PublishingLicense)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request EndUserLicense")
+	}
+	sr, err := h.NewDecrypter(r, size, userLicense.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return &File{
+		SectionReader:  sr,
+		Header:         h,
+		EndUserLicense: userLicense,
+	}, nil
+}
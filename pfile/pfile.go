@@ -0,0 +1,176 @@
+// Package pfile reads the generic protected file wrapper Azure Information Protection uses for
+// files without native protection (ex: .pfile, .ptxt, .pjpg and the older .ppdf).
+//
+// A PFile is a small header holding the original file extension and the XrML publishing license
+// followed by the content, encrypted with the content key in the same way as DRMContent.
+package pfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Magic is the preamble of every PFile
+var Magic = []byte(".pfile")
+
+// Supported major versions, the metadata fields were added in version 3
+const (
+	minVersion      = 2
+	maxVersion      = 3
+	metadataVersion = 3
+)
+
+// extensionPattern matches the extensions OriginalName uses, the header is untrusted and could
+// otherwise hold path separators or ".."
+var extensionPattern = regexp.MustCompile(`^\.[A-Za-z0-9]+$`)
+
+// maxFieldSize bounds the size of each variable length header field
+const maxFieldSize = 16 << 20
+
+// Header is the (unencrypted) header of a PFile
+type Header struct {
+	MajorVersion uint32
+	MinorVersion uint32
+	// CleartextRedirect is the text shown by readers which do not understand PFiles
+	CleartextRedirect string
+	// Extension is the extension of the original file including the dot (ex: ".pdf")
+	Extension string
+	// PublishingLicense is the XrML license the content was protected with
+	PublishingLicense []byte
+	// Metadata is the (optional) metadata stored since version 3
+	Metadata []byte
+	// ContentOffset is the offset of the encrypted content from the start of the file
+	ContentOffset int64
+	// OriginalSize is the size of the plaintext, the content is padded to the cipher block size
+	OriginalSize int64
+}
+
+// IsPFile reports if r starts with the PFile preamble
+func IsPFile(r io.ReaderAt) bool {
+	b := make([]byte, len(Magic))
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(b, Magic)
+}
+
+// headerReader reads the little-endian fields of the header in order
+type headerReader struct {
+	r   io.ReaderAt
+	off int64
+	err error
+}
+
+func (hr *headerReader) read(n int64) []byte {
+	if hr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := hr.r.ReadAt(b, hr.off); err != nil {
+		hr.err = errors.Wrapf(err, "failed to read %d bytes at offset %d", n, hr.off)
+		return nil
+	}
+	hr.off += n
+	return b
+}
+
+func (hr *headerReader) uint32() uint32 {
+	if b := hr.read(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (hr *headerReader) uint64() uint64 {
+	if b := hr.read(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// field reads size bytes at an absolute offset without moving the header position
+func (hr *headerReader) field(name string, offset uint32, size uint32) []byte {
+	if hr.err != nil {
+		return nil
+	}
+	if size > maxFieldSize {
+		hr.err = errors.Errorf("%s is too large (%d bytes)", name, size)
+		return nil
+	}
+	b := make([]byte, size)
+	if _, err := hr.r.ReadAt(b, int64(offset)); err != nil {
+		hr.err = errors.Wrapf(err, "failed to read %s", name)
+		return nil
+	}
+	return b
+}
+
+// ReadHeader parses the header of a PFile of size bytes
+func ReadHeader(r io.ReaderAt, size int64) (*Header, error) {
+	if !IsPFile(r) {
+		return nil, errors.New("file does not start with the PFile preamble")
+	}
+	hr := &headerReader{r: r, off: int64(len(Magic))}
+	var h Header
+	h.MajorVersion = hr.uint32()
+	h.MinorVersion = hr.uint32()
+	if hr.err != nil {
+		return nil, errors.Wrap(hr.err, "failed to read version")
+	} else if h.MajorVersion < minVersion || h.MajorVersion > maxVersion {
+		return nil, errors.Errorf("unsupported PFile version %d.%d", h.MajorVersion, h.MinorVersion)
+	}
+	redirectSize := hr.uint32()
+	if redirectSize > maxFieldSize {
+		return nil, errors.Errorf("CleartextRedirect is too large (%d bytes)", redirectSize)
+	}
+	h.CleartextRedirect = string(hr.read(int64(redirectSize)))
+	// HeaderSize, the fields below are located by offset so it is not needed
+	hr.uint32()
+	extensionOffset, extensionSize := hr.uint32(), hr.uint32()
+	licenseOffset, licenseSize := hr.uint32(), hr.uint32()
+	var metadataOffset, metadataSize uint32
+	if h.MajorVersion >= metadataVersion {
+		metadataOffset, metadataSize = hr.uint32(), hr.uint32()
+	}
+	h.ContentOffset = int64(hr.uint32())
+	h.OriginalSize = int64(hr.uint64())
+	if hr.err != nil {
+		return nil, errors.Wrap(hr.err, "failed to read header")
+	}
+
+	h.Extension = string(hr.field("Extension", extensionOffset, extensionSize))
+	h.PublishingLicense = hr.field("PublishingLicense", licenseOffset, licenseSize)
+	if metadataSize > 0 {
+		h.Metadata = hr.field("Metadata", metadataOffset, metadataSize)
+	}
+	if hr.err != nil {
+		return nil, hr.err
+	}
+	if len(h.PublishingLicense) == 0 {
+		return nil, errors.New("PFile does not have a publishing license")
+	}
+	if h.ContentOffset < hr.off || h.ContentOffset > size {
+		return nil, errors.Errorf("content offset %d is outside of the file", h.ContentOffset)
+	}
+	if h.OriginalSize < 0 || h.OriginalSize > size-h.ContentOffset {
+		return nil, errors.Errorf("original size %d does not fit the %d bytes of content", h.OriginalSize, size-h.ContentOffset)
+	}
+	return &h, nil
+}
+
+// OriginalName returns the name of the original file given the name of the PFile,
+// ex: "report.pdf.pfile" becomes "report.pdf" and "photo.pjpg" becomes "photo.jpg", an Extension
+// which is not alphanumeric (ex: "/../x") is ignored
+func (h *Header) OriginalName(name string) string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if !extensionPattern.MatchString(h.Extension) || strings.EqualFold(filepath.Ext(base), h.Extension) {
+		return base
+	}
+	return base + h.Extension
+}
@@ -0,0 +1,131 @@
+package pfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bored-engineer/rms/aadrm"
+	"github.com/bored-engineer/rms/internal/rmstest"
+)
+
+var testLicense = rmstest.License("pfile")
+
+// build creates a PFile of plaintext encrypted with key with a 24 byte CleartextRedirect
+func build(t *testing.T, major uint32, key *aadrm.Key, plaintext []byte) []byte {
+	return rmstest.BuildPFile(t, key, rmstest.PFile{
+		MajorVersion: major,
+		Redirect:     "Open with a PFile viewer",
+		Extension:    ".pdf",
+		License:      testLicense,
+		Metadata:     []byte("metadata"),
+	}, plaintext)
+}
+
+func TestReadHeader(t *testing.T) {
+	key, err := aadrm.GenerateKey("MICROSOFT.CBC4K", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, major := range []uint32{2, 3} {
+		b := build(t, major, key, []byte("plaintext"))
+		if !IsPFile(bytes.NewReader(b)) {
+			t.Fatal("IsPFile is false")
+		}
+		h, err := ReadHeader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.MajorVersion != major || h.Extension != ".pdf" || !bytes.Equal(h.PublishingLicense, testLicense) || h.OriginalSize != 9 {
+			t.Errorf("v%d: unexpected %+v", major, h)
+		}
+		if wantMetadata := major >= metadataVersion; (h.Metadata != nil) != wantMetadata {
+			t.Errorf("v%d: Metadata is %q", major, h.Metadata)
+		}
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	key, err := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := build(t, 3, key, []byte("plaintext"))
+	// Offsets of the fixed header fields after the 24 byte CleartextRedirect
+	const (
+		versionOffset       = 6
+		licenseSizeOffset   = 6 + 12 + 24 + 16
+		contentOffsetOffset = licenseSizeOffset + 12
+	)
+	for name, corrupt := range map[string]func(b []byte) []byte{
+		"magic":        func(b []byte) []byte { b[0] = 'x'; return b },
+		"version":      func(b []byte) []byte { binary.LittleEndian.PutUint32(b[versionOffset:], 9); return b },
+		"license size": func(b []byte) []byte { binary.LittleEndian.PutUint32(b[licenseSizeOffset:], maxFieldSize+1); return b },
+		"no license":   func(b []byte) []byte { binary.LittleEndian.PutUint32(b[licenseSizeOffset:], 0); return b },
+		"content offset": func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[contentOffsetOffset:], uint32(len(b)+1))
+			return b
+		},
+		"original size": func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[contentOffsetOffset+4:], uint64(len(b)))
+			return b
+		},
+		"truncated": func(b []byte) []byte { return b[:40] },
+	} {
+		bad := corrupt(append([]byte{}, b...))
+		if _, err := ReadHeader(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, mode := range []string{"MICROSOFT.ECB", "MICROSOFT.CBC4K", "MICROSOFT.CBC512"} {
+		key, err := aadrm.GenerateKey(mode, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext := bytes.Repeat([]byte("pfile content "), 700)
+		b := build(t, 3, key, plaintext)
+		f, err := Open(context.Background(), bytes.NewReader(b), int64(len(b)), &rmstest.Licensor{Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: decrypted %d bytes which do not match the plaintext", mode, len(got))
+		}
+	}
+
+	key, _ := aadrm.GenerateKey("MICROSOFT.ECB", 16)
+	b := build(t, 3, key, []byte("plaintext"))
+	if _, err := Open(context.Background(), bytes.NewReader(b), int64(len(b)), &rmstest.Licensor{Key: key, Denied: map[string]bool{"pfile": true}}); !errors.Is(err, aadrm.ErrAccessDenied) {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestOriginalName(t *testing.T) {
+	for _, tc := range []struct {
+		extension, name, want string
+	}{
+		{".pdf", "report.pdf.pfile", "report.pdf"},
+		{".PDF", "report.pdf.pfile", "report.pdf"},
+		{".jpg", "photo.pjpg", "photo.jpg"},
+		{"", "notes.pfile", "notes"},
+		// The extension comes from the file and may not change the directory
+		{"/../../escape", "notes.pfile", "notes"},
+		{"..", "notes.pfile", "notes"},
+		{`.txt\..\..\escape`, "notes.pfile", "notes"},
+		{".t/xt", "notes.pfile", "notes"},
+	} {
+		if got := (&Header{Extension: tc.extension}).OriginalName(tc.name); got != tc.want {
+			t.Errorf("%q %q: got %q, want %q", tc.extension, tc.name, got, tc.want)
+		}
+	}
+}
@@ -15,6 +15,9 @@ var magicBytes = []byte{0x76, 0xe8, 0x04, 0x60, 0xc4, 0x11, 0xe3, 0x86}
 // https://docs.microsoft.com/en-us/previous-versions/windows/internet-explorer/ie-developer/platform-apis/aa767786(v=vs.85)?redirectedfrom=MSDN#compress-the-resulting-compound-file
 var segmentBytes = []byte{0xA0, 0x0F, 0x00, 0x00}
 
+// maxCompressedSize bounds the buffer allocated for a segment, zlib only grows a segment by a few bytes
+var maxCompressedSize = 4 * segmentSize
+
 // segmentReader reads segments from an rpmsg
 type segmentReader struct {
 	// underlying io.Reader of rpmsg data
@@ -42,6 +45,9 @@ func (r *segmentReader) readSegment() error {
 	}
 	originalSize := binary.LittleEndian.Uint32(r.header[4:8])
 	compressedSize := binary.LittleEndian.Uint32(r.header[8:12])
+	if uint64(compressedSize) > uint64(maxCompressedSize) {
+		return fmt.Errorf("compressed segment size %d exceeds %d", compressedSize, maxCompressedSize)
+	}
 	r.buf = make([]byte, compressedSize)
 	r.expected += uint64(originalSize)
 	if _, err := io.ReadFull(r.r, r.buf); err != nil {
@@ -80,6 +86,30 @@ func (r *segmentReader) Read(p []byte) (int, error) {
 	return copied, nil
 }
 
+// ReadByte implements io.ByteReader so zlib does not buffer past the end of a stream
+func (r *segmentReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if n, err := r.Read(b[:]); n == 1 {
+		return b[0], nil
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, io.ErrNoProgress
+}
+
+// more reports if there is any segment data left to be read
+func (r *segmentReader) more() (bool, error) {
+	if len(r.buf) > 0 {
+		return true, nil
+	}
+	if err := r.readSegment(); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read segment: %v", err)
+	}
+	return true, nil
+}
+
 // zlibReader converts the io.ReadCloser to io.Reader
 type zlibReader struct {
 	r    *segmentReader
@@ -87,30 +117,54 @@ type zlibReader struct {
 	read uint64
 }
 
+// eof validates the decompressed size once all segments are consumed
+func (r *zlibReader) eof() error {
+	if r.read != r.r.expected {
+		return errors.New("unexpected EOF")
+	}
+	return io.EOF
+}
+
 func (r *zlibReader) Read(p []byte) (int, error) {
-	// If no zlib reader yet create one
+	// If no zlib reader yet create one (unless there is no data at all)
 	if r.zr == nil {
+		if more, err := r.r.more(); err != nil {
+			return 0, err
+		} else if !more {
+			return 0, r.eof()
+		}
 		var err error
 		r.zr, err = zlib.NewReader(r.r)
 		if err != nil {
 			return 0, fmt.Errorf("failed to read zlib header: %v", err)
 		}
 	}
-	// Read directly into p
-	n, err := io.ReadFull(r.zr, p)
-	r.read += uint64(n)
-	if err != nil {
-		// TODO: Hacky af
-		if err == io.EOF || err.Error() == "unexpected EOF" {
-			if r.read == r.r.expected {
-				return n, io.EOF
-			} else {
-				return n, errors.New("unexpected EOF")
+	// Loop until we have filled p with data from zlib streams
+	copied := 0
+	for copied < len(p) {
+		n, err := r.zr.Read(p[copied:])
+		copied += n
+		r.read += uint64(n)
+		if err == io.EOF {
+			// Each segment is usually its own zlib stream, start the next one if there is any
+			if more, err := r.r.more(); err != nil {
+				return copied, err
+			} else if !more {
+				return copied, r.eof()
 			}
+			if err := r.zr.(zlib.Resetter).Reset(r.r, nil); err != nil {
+				return copied, fmt.Errorf("failed to read zlib header: %v", err)
+			}
+		} else if err != nil {
+			return copied, fmt.Errorf("failed to read zlib data: %v", err)
 		}
-		return n, fmt.Errorf("failed to read zlib data: %v", err)
 	}
-	return n, nil
+	return copied, nil
+}
+
+// IsRPMSG reports if b starts with the rpmsg magic bytes
+func IsRPMSG(b []byte) bool {
+	return bytes.HasPrefix(b, magicBytes)
 }
 
 // NewReader reads the prefix and reads
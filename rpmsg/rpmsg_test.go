@@ -0,0 +1,162 @@
+package rpmsg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// roundTrip writes data as an rpmsg and returns the encoded bytes
+func roundTrip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	// Write in uneven chunks to exercise the segment buffering
+	for p := data; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 7} {
+		data := make([]byte, size)
+		rng.Read(data)
+		encoded := roundTrip(t, data)
+		if !IsRPMSG(encoded) {
+			t.Fatalf("size %d: encoded data is missing the magic bytes", size)
+		}
+		r, err := NewReader(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("size %d: NewReader: %v", size, err)
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("size %d: decoded %d bytes which do not match", size, len(decoded))
+		}
+	}
+}
+
+func TestSegmentLayout(t *testing.T) {
+	data := bytes.Repeat([]byte("rpmsg"), segmentSize/2)
+	encoded := roundTrip(t, data)[len(magicBytes):]
+	var total int
+	for segments := 0; len(encoded) > 0; segments++ {
+		if len(encoded) < 12 || !bytes.Equal(encoded[:4], segmentBytes) {
+			t.Fatalf("segment %d: bad header %x", segments, encoded)
+		}
+		original := int(binary.LittleEndian.Uint32(encoded[4:8]))
+		compressed := int(binary.LittleEndian.Uint32(encoded[8:12]))
+		if original > segmentSize {
+			t.Fatalf("segment %d: original size %d exceeds %d", segments, original, segmentSize)
+		}
+		total += original
+		encoded = encoded[12+compressed:]
+	}
+	if total != len(data) {
+		t.Fatalf("segments hold %d bytes, want %d", total, len(data))
+	}
+}
+
+// TestSingleStream reads a file written as one zlib stream spanning every segment
+func TestSingleStream(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	encoded := append([]byte{}, magicBytes...)
+	c := compressed.Bytes()
+	half := len(c) / 2
+	for i, part := range [][]byte{c[:half], c[half:]} {
+		var header [12]byte
+		copy(header[:4], segmentBytes)
+		// The original sizes only have to add up
+		size := len(data) / 2
+		if i == 1 {
+			size = len(data) - size
+		}
+		binary.LittleEndian.PutUint32(header[4:8], uint32(size))
+		binary.LittleEndian.PutUint32(header[8:12], uint32(len(part)))
+		encoded = append(append(encoded, header[:]...), part...)
+	}
+
+	r, err := NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("decoded data does not match")
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	valid := roundTrip(t, bytes.Repeat([]byte("x"), 100))
+	wrongSize := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(wrongSize[len(magicBytes)+4:], 101)
+	tests := map[string][]byte{
+		"magic":     append([]byte{0}, valid[1:]...),
+		"short":     valid[:4],
+		"segment":   append(append([]byte{}, magicBytes...), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+		"truncated": valid[:len(valid)-3],
+		"size":      wrongSize,
+	}
+	for name, b := range tests {
+		r, err := NewReader(bytes.NewReader(b))
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestOversizedSegment makes sure a header can not force a huge allocation before any data is read
+func TestOversizedSegment(t *testing.T) {
+	header := append(append([]byte{}, magicBytes...), segmentBytes...)
+	header = append(header, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r, err := NewReader(bytes.NewReader(header))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("expected an error")
+	} else if !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes", allocated)
+	}
+}
@@ -0,0 +1,105 @@
+package rpmsg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// segmentSize is the maximum amount of uncompressed data stored in a single segment
+var segmentSize = int(binary.LittleEndian.Uint32(segmentBytes))
+
+// segmentWriter writes segments to an rpmsg
+type segmentWriter struct {
+	// underlying io.Writer for rpmsg data
+	w io.Writer
+	// segment header, re-used for memory usage
+	header [12]byte
+	// buf is uncompressed data waiting to fill a segment
+	buf []byte
+	// compressed is the zlib output of the current segment, re-used for memory usage
+	compressed bytes.Buffer
+	// zw compresses each segment, it is reset between segments
+	zw *zlib.Writer
+	// closed is set once Close has been called
+	closed bool
+}
+
+// writeSegment compresses p and writes it as a single segment
+func (w *segmentWriter) writeSegment(p []byte) error {
+	w.compressed.Reset()
+	if w.zw == nil {
+		w.zw = zlib.NewWriter(&w.compressed)
+	} else {
+		w.zw.Reset(&w.compressed)
+	}
+	if _, err := w.zw.Write(p); err != nil {
+		return fmt.Errorf("failed to write zlib data: %v", err)
+	}
+	if err := w.zw.Close(); err != nil {
+		return fmt.Errorf("failed to close zlib stream: %v", err)
+	}
+	copy(w.header[0:4], segmentBytes)
+	binary.LittleEndian.PutUint32(w.header[4:8], uint32(len(p)))
+	binary.LittleEndian.PutUint32(w.header[8:12], uint32(w.compressed.Len()))
+	if _, err := w.w.Write(w.header[:]); err != nil {
+		return fmt.Errorf("failed to write segment header: %v", err)
+	}
+	if _, err := w.w.Write(w.compressed.Bytes()); err != nil {
+		return fmt.Errorf("failed to write compressed segment: %v", err)
+	}
+	return nil
+}
+
+// Write buffers p, writing a segment each time enough data is available
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed rpmsg writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// Fill the buffer up to a full segment
+		n := segmentSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		// Flush the segment once it is full
+		if len(w.buf) == segmentSize {
+			if err := w.writeSegment(w.buf); err != nil {
+				return written, fmt.Errorf("failed to write segment: %v", err)
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return written, nil
+}
+
+// Close writes any remaining buffered data as a final (short) segment
+func (w *segmentWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.buf) > 0 {
+		if err := w.writeSegment(w.buf); err != nil {
+			return fmt.Errorf("failed to write segment: %v", err)
+		}
+		w.buf = nil
+	}
+	return nil
+}
+
+// NewWriter writes the prefix and returns a writer which compresses into segments,
+// the caller must call Close to flush the final segment
+func NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := w.Write(magicBytes); err != nil {
+		return nil, fmt.Errorf("failed to write magic bytes: %v", err)
+	}
+	return &segmentWriter{w: w, buf: make([]byte, 0, segmentSize)}, nil
+}
@@ -0,0 +1,161 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/pkg/errors"
+)
+
+// magic prefixes every sealed file
+var magic = []byte("RMSSEAL1")
+
+// How the AES key of a sealed file is obtained
+const (
+	modeKey        = 0
+	modePassphrase = 1
+)
+
+// Sizes of the header fields
+const (
+	saltSize  = 16
+	nonceSize = 12
+	keySize   = 32
+)
+
+// scrypt parameters for passphrases
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Sealer encrypts (seals) and decrypts (opens) data at rest with AES-256-GCM
+type Sealer struct {
+	// key is used as-is if set
+	key []byte
+	// passphrase derives a key per file with scrypt
+	passphrase []byte
+}
+
+// NewKeySealer creates a *Sealer using a 256-bit key
+func NewKeySealer(key []byte) (*Sealer, error) {
+	if len(key) != keySize {
+		return nil, errors.Errorf("key must be %d bytes, not %d", keySize, len(key))
+	}
+	return &Sealer{key: key}, nil
+}
+
+// NewPassphraseSealer creates a *Sealer deriving keys from passphrase
+func NewPassphraseSealer(passphrase []byte) (*Sealer, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+	return &Sealer{passphrase: passphrase}, nil
+}
+
+// ReadKeyFile reads a 256-bit key which is stored raw, hex or base64 encoded
+func ReadKeyFile(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key file %s", name)
+	}
+	if len(b) == keySize {
+		return b, nil
+	}
+	s := strings.TrimSpace(string(b))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, errors.Errorf("key file %s does not contain a %d byte key", name, keySize)
+}
+
+// IsSealed reports if b was produced by Seal
+func IsSealed(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// aead creates the AES-GCM cipher for a file with the given mode and salt
+func (s *Sealer) aead(mode byte, salt []byte) (cipher.AEAD, error) {
+	var key []byte
+	switch mode {
+	case modeKey:
+		if s.key == nil {
+			return nil, errors.New("sealed with a key file but no key was provided")
+		}
+		key = s.key
+	case modePassphrase:
+		if s.passphrase == nil {
+			return nil, errors.New("sealed with a passphrase but no passphrase was provided")
+		}
+		var err error
+		key, err = scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, keySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to derive key")
+		}
+	default:
+		return nil, errors.Errorf("unknown seal mode %d", mode)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AES cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCM")
+	}
+	return aead, nil
+}
+
+// Seal encrypts plaintext, the header is authenticated as additional data
+func (s *Sealer) Seal(plaintext []byte) ([]byte, error) {
+	mode := byte(modeKey)
+	if s.key == nil {
+		mode = modePassphrase
+	}
+	header := make([]byte, len(magic)+1+saltSize+nonceSize)
+	copy(header, magic)
+	header[len(magic)] = mode
+	if _, err := io.ReadFull(rand.Reader, header[len(magic)+1:]); err != nil {
+		return nil, errors.Wrap(err, "failed to generate salt and nonce")
+	}
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	nonce := header[len(magic)+1+saltSize:]
+	aead, err := s.aead(mode, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Open decrypts data produced by Seal
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	headerSize := len(magic) + 1 + saltSize + nonceSize
+	if !IsSealed(sealed) || len(sealed) < headerSize {
+		return nil, errors.New("data is not sealed")
+	}
+	header := sealed[:headerSize]
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	nonce := header[len(magic)+1+saltSize:]
+	aead, err := s.aead(header[len(magic)], salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed[headerSize:], header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open (wrong key or passphrase?)")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,159 @@
+package seal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// headerSize is the size of the magic, mode, salt and nonce before the ciphertext
+var headerSize = len(magic) + 1 + saltSize + nonceSize
+
+func testSealers(t *testing.T) map[string]*Sealer {
+	keySealer, err := NewKeySealer(bytes.Repeat([]byte{1}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphraseSealer, err := NewPassphraseSealer([]byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Sealer{"key": keySealer, "passphrase": passphraseSealer}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, s := range testSealers(t) {
+		for _, plaintext := range [][]byte{nil, []byte("user license"), bytes.Repeat([]byte{0xff}, 1<<16)} {
+			sealed, err := s.Seal(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsSealed(sealed) {
+				t.Errorf("%s: IsSealed is false", name)
+			}
+			if len(plaintext) > 0 && bytes.Contains(sealed, plaintext) {
+				t.Errorf("%s: sealed data contains the plaintext", name)
+			}
+			opened, err := s.Open(sealed)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("%s: opened %d bytes which do not match", name, len(opened))
+			}
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealers := testSealers(t)
+	otherKey, _ := NewKeySealer(bytes.Repeat([]byte{2}, keySize))
+	otherPassphrase, _ := NewPassphraseSealer([]byte("correct horse battery stapler"))
+	for _, tc := range []struct {
+		name         string
+		seal, opener *Sealer
+	}{
+		{"wrong key", sealers["key"], otherKey},
+		{"wrong passphrase", sealers["passphrase"], otherPassphrase},
+		{"key for passphrase", sealers["passphrase"], sealers["key"]},
+		{"passphrase for key", sealers["key"], sealers["passphrase"]},
+	} {
+		sealed, err := tc.seal.Seal([]byte("user license"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tc.opener.Open(sealed); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestTampered(t *testing.T) {
+	for name, s := range testSealers(t) {
+		sealed, err := s.Seal([]byte("user license"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every byte after the magic is authenticated: mode, salt, nonce, ciphertext and tag
+		for _, off := range []int{len(magic), len(magic) + 1, len(magic) + 1 + saltSize, headerSize, len(sealed) - 1} {
+			tampered := append([]byte{}, sealed...)
+			tampered[off] ^= 0x01
+			if _, err := s.Open(tampered); err == nil {
+				t.Errorf("%s: expected an error with byte %d flipped", name, off)
+			}
+		}
+		for _, n := range []int{0, 7, headerSize - 1, headerSize, len(sealed) - 1} {
+			if _, err := s.Open(sealed[:n]); err == nil {
+				t.Errorf("%s: expected an error truncated to %d bytes", name, n)
+			}
+		}
+	}
+}
+
+func TestNonceReuse(t *testing.T) {
+	for name, s := range testSealers(t) {
+		first, err := s.Seal([]byte("same input"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := s.Seal([]byte("same input"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := func(b []byte) []byte { return b[len(magic)+1+saltSize : headerSize] }
+		if bytes.Equal(nonce(first), nonce(second)) {
+			t.Errorf("%s: nonce was reused", name)
+		}
+		if bytes.Equal(first[headerSize:], second[headerSize:]) {
+			t.Errorf("%s: ciphertext was repeated", name)
+		}
+	}
+}
+
+func TestNewSealer(t *testing.T) {
+	for _, n := range []int{0, 16, 31, 33} {
+		if _, err := NewKeySealer(make([]byte, n)); err == nil {
+			t.Errorf("expected an error for a %d byte key", n)
+		}
+	}
+	if _, err := NewPassphraseSealer(nil); err == nil {
+		t.Error("expected an error for an empty passphrase")
+	}
+	if _, err := testSealers(t)["key"].Open([]byte("not sealed at all, but long enough to have a header")); err == nil {
+		t.Error("expected an error opening data which is not sealed")
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, keySize)
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		contents []byte
+		ok       bool
+	}{
+		"raw":    {key, true},
+		"hex":    {[]byte(hex.EncodeToString(key) + "\n"), true},
+		"base64": {[]byte(" " + base64.StdEncoding.EncodeToString(key) + "\r\n"), true},
+		"short":  {[]byte(hex.EncodeToString(key[:20])), false},
+		"text":   {[]byte("not a key"), false},
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, tc.contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadKeyFile(path)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		} else if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("%s: got %x (%v)", name, got, err)
+		}
+	}
+	if _, err := ReadKeyFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
@@ -0,0 +1,121 @@
+package xrml
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Well known ENABLINGBITS types
+const (
+	// SealedKeyType is a CryptoAPI key blob encrypted to the public key of the principal
+	SealedKeyType = "sealed-key"
+	// EncryptedPrivateKeyType is a CryptoAPI private key blob encrypted with the sealed key
+	EncryptedPrivateKeyType = "encrypted-private-key"
+)
+
+// Well known PUBLICKEY parameter names
+const (
+	PublicExponentParameter = "public-exponent"
+	ModulusParameter        = "modulus"
+)
+
+// EnablingBits are the (encrypted) keys a license grants to its principal
+type EnablingBits struct {
+	Type  string `xml:"type,attr"`
+	Value Value  `xml:"VALUE"`
+}
+
+// Bytes decodes a base64 VALUE
+func (v *Value) Bytes() ([]byte, error) {
+	if v.Encoding != "" && !strings.EqualFold(v.Encoding, "base64") {
+		return nil, errors.Errorf("unsupported VALUE encoding %s", v.Encoding)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v.Value), ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode base64 VALUE")
+	}
+	return b, nil
+}
+
+// EnablingBits returns the first ENABLINGBITS of typ in the certificate
+func (c *Certificate) EnablingBits(typ string) *EnablingBits {
+	for idx := range c.Body.EnablingBits {
+		if strings.EqualFold(c.Body.EnablingBits[idx].Type, typ) {
+			return &c.Body.EnablingBits[idx]
+		}
+	}
+	return nil
+}
+
+// parameter returns the VALUE of the named parameter
+func (k *PublicKey) parameter(name string) (*Value, error) {
+	for idx := range k.Parameters {
+		if strings.EqualFold(k.Parameters[idx].Name, name) {
+			return &k.Parameters[idx].Value, nil
+		}
+	}
+	return nil, errors.Errorf("PUBLICKEY does not have a %s", name)
+}
+
+// reverse returns a reversed copy of b, CryptoAPI stores big integers little-endian
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for idx := range b {
+		r[len(b)-1-idx] = b[idx]
+	}
+	return r
+}
+
+// RSA decodes the RSA public key, the modulus is stored little-endian
+func (k *PublicKey) RSA() (*rsa.PublicKey, error) {
+	if !strings.EqualFold(k.Algorithm, "RSA") {
+		return nil, errors.Errorf("unsupported PUBLICKEY algorithm %s", k.Algorithm)
+	}
+	exponent, err := k.parameter(PublicExponentParameter)
+	if err != nil {
+		return nil, err
+	}
+	e, err := strconv.ParseUint(strings.TrimSpace(exponent.Value), 10, 31)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public-exponent")
+	}
+	modulus, err := k.parameter(ModulusParameter)
+	if err != nil {
+		return nil, err
+	}
+	n, err := modulus.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode modulus")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(reverse(n)),
+		E: int(e),
+	}, nil
+}
+
+// NewPublicKey encodes an RSA public key as a PUBLICKEY
+func NewPublicKey(pub *rsa.PublicKey) *PublicKey {
+	n := reverse(pub.N.FillBytes(make([]byte, pub.Size())))
+	return &PublicKey{
+		Algorithm: "RSA",
+		Parameters: []Parameter{{
+			Name: PublicExponentParameter,
+			Value: Value{
+				Encoding: "integer32",
+				Value:    strconv.Itoa(pub.E),
+			},
+		}, {
+			Name: ModulusParameter,
+			Value: Value{
+				Encoding: "base64",
+				Size:     strconv.Itoa(pub.N.BitLen()),
+				Value:    base64.StdEncoding.EncodeToString(n),
+			},
+		}},
+	}
+}
@@ -0,0 +1,315 @@
+package xrml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// Well known BODY types of the certificates in a publishing license
+const (
+	RightsLabelType = "Microsoft Rights Label"
+)
+
+// Well known OBJECT types of DISTRIBUTIONPOINT
+const (
+	LicenseAcquisitionURLType         = "License-Acquisition-URL"
+	ExtranetLicenseAcquisitionURLType = "Extranet-License-Acquisition-URL"
+)
+
+// Value is an (often base64) encoded value
+type Value struct {
+	Encoding string `xml:"encoding,attr,omitempty" json:",omitempty"`
+	Size     string `xml:"size,attr,omitempty" json:",omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+// ID identifies an OBJECT
+type ID struct {
+	Type  string `xml:"type,attr,omitempty" json:",omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Address is usually the URL of a server
+type Address struct {
+	Type  string `xml:"type,attr,omitempty" json:",omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Object is the generic XrML OBJECT element
+type Object struct {
+	Type    string    `xml:"type,attr,omitempty" json:",omitempty"`
+	ID      *ID       `xml:"ID" json:",omitempty"`
+	Name    string    `xml:"NAME,omitempty" json:",omitempty"`
+	Address []Address `xml:"ADDRESS" json:",omitempty"`
+}
+
+// URL returns the first URL address of the object
+func (o *Object) URL() string {
+	for _, a := range o.Address {
+		if strings.EqualFold(a.Type, "URL") {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// Parameter is a named parameter of a PUBLICKEY
+type Parameter struct {
+	Name  string `xml:"name,attr"`
+	Value Value  `xml:"VALUE"`
+}
+
+// PublicKey is (usually) a RSA public key
+type PublicKey struct {
+	Algorithm  string      `xml:"ALGORITHM"`
+	Parameters []Parameter `xml:"PARAMETER" json:",omitempty"`
+}
+
+// SecurityLevel is a name/value pair describing the security level of a principal
+type SecurityLevel struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Principal is an ISSUER, OWNER, etc
+type Principal struct {
+	Object        Object          `xml:"OBJECT"`
+	PublicKey     *PublicKey      `xml:"PUBLICKEY" json:",omitempty"`
+	SecurityLevel []SecurityLevel `xml:"SECURITYLEVEL" json:",omitempty"`
+}
+
+// DistributionPoint is a server which can issue licenses for the content
+type DistributionPoint struct {
+	Object Object `xml:"OBJECT"`
+}
+
+// Metadata of a WORK
+type Metadata struct {
+	Owner *Principal `xml:"OWNER" json:",omitempty"`
+}
+
+// Work is the content protected by the license
+type Work struct {
+	Object   Object    `xml:"OBJECT"`
+	Metadata *Metadata `xml:"METADATA" json:",omitempty"`
+}
+
+// AuthenticatedData is signed (but not encrypted) application data, ex: template and label IDs
+type AuthenticatedData struct {
+	ID    string `xml:"id,attr,omitempty" json:",omitempty"`
+	Name  string `xml:"name,attr,omitempty" json:",omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Body is the signed BODY of a certificate
+type Body struct {
+	Type                string              `xml:"type,attr"`
+	Version             string              `xml:"version,attr,omitempty" json:",omitempty"`
+	IssuedTime          string              `xml:"ISSUEDTIME,omitempty" json:",omitempty"`
+	Descriptor          *Object             `xml:"DESCRIPTOR>OBJECT" json:",omitempty"`
+	Issuer              *Principal          `xml:"ISSUER" json:",omitempty"`
+	DistributionPoints  []DistributionPoint `xml:"DISTRIBUTIONPOINT" json:",omitempty"`
+	IssuedPrincipals    []Principal         `xml:"ISSUEDPRINCIPALS>PRINCIPAL" json:",omitempty"`
+	Work                *Work               `xml:"WORK" json:",omitempty"`
+	AuthenticatedData   []AuthenticatedData `xml:"AUTHENTICATEDDATA" json:",omitempty"`
+	EncryptedRightsData *Value              `xml:"ENCRYPTEDRIGHTSDATA" json:",omitempty"`
+	EnablingBits        []EnablingBits      `xml:"ENABLINGBITS" json:",omitempty"`
+}
+
+// Digest of the signed BODY
+type Digest struct {
+	Algorithm string `xml:"ALGORITHM"`
+	Value     Value  `xml:"VALUE"`
+}
+
+// Signature over the BODY
+type Signature struct {
+	Algorithm string  `xml:"ALGORITHM"`
+	Digest    *Digest `xml:"DIGEST" json:",omitempty"`
+	Value     Value   `xml:"VALUE"`
+}
+
+// Certificate is a single XrML document
+type Certificate struct {
+	XMLName   xml.Name   `xml:"XrML" json:"-"`
+	Version   string     `xml:"version,attr,omitempty" json:",omitempty"`
+	Purpose   string     `xml:"purpose,attr,omitempty" json:",omitempty"`
+	Body      Body       `xml:"BODY"`
+	Signature *Signature `xml:"SIGNATURE" json:",omitempty"`
+}
+
+// PublishingLicense is the chain of XrML certificates from a DRMTransform/Primary stream
+type PublishingLicense struct {
+	Certificates []*Certificate
+}
+
+func (pl *PublishingLicense) String() string {
+	b, _ := json.MarshalIndent(&pl, "", "\t")
+	return string(b)
+}
+
+// License returns the rights label certificate of the chain (or the first certificate if there isn't one)
+func (pl *PublishingLicense) License() *Certificate {
+	for _, cert := range pl.Certificates {
+		if cert.Body.Type == RightsLabelType {
+			return cert
+		}
+	}
+	return pl.Certificates[0]
+}
+
+// ContentID returns the ID of the protected content
+func (pl *PublishingLicense) ContentID() string {
+	if work := pl.License().Body.Work; work != nil && work.Object.ID != nil {
+		return strings.TrimSpace(work.Object.ID.Value)
+	}
+	return ""
+}
+
+// Owner returns the name (or ID if unnamed) of the owner of the content
+func (pl *PublishingLicense) Owner() string {
+	work := pl.License().Body.Work
+	if work == nil || work.Metadata == nil || work.Metadata.Owner == nil {
+		return ""
+	}
+	owner := work.Metadata.Owner.Object
+	if name := strings.TrimSpace(owner.Name); name != "" {
+		return name
+	}
+	if owner.ID != nil {
+		return strings.TrimSpace(owner.ID.Value)
+	}
+	return ""
+}
+
+// Issuer returns the server which issued the license
+func (pl *PublishingLicense) Issuer() *Object {
+	if issuer := pl.License().Body.Issuer; issuer != nil {
+		return &issuer.Object
+	}
+	return nil
+}
+
+// distributionPoint returns the URL of the first DISTRIBUTIONPOINT of typ
+func (pl *PublishingLicense) distributionPoint(typ string) string {
+	for _, dp := range pl.License().Body.DistributionPoints {
+		if strings.EqualFold(dp.Object.Type, typ) {
+			return dp.Object.URL()
+		}
+	}
+	return ""
+}
+
+// IntranetURL returns the license acquisition URL for the content
+func (pl *PublishingLicense) IntranetURL() string {
+	return pl.distributionPoint(LicenseAcquisitionURLType)
+}
+
+// ExtranetURL returns the extranet license acquisition URL for the content
+func (pl *PublishingLicense) ExtranetURL() string {
+	return pl.distributionPoint(ExtranetLicenseAcquisitionURLType)
+}
+
+// authenticatedData returns the (trimmed) value of the first AUTHENTICATEDDATA accepted by match
+func (pl *PublishingLicense) authenticatedData(match func(name string) bool) (string, string) {
+	for _, data := range pl.License().Body.AuthenticatedData {
+		if match(data.Name) {
+			return data.Name, strings.TrimSpace(data.Value)
+		}
+	}
+	return "", ""
+}
+
+// TemplateID returns the ID of the template the content was protected with (if any)
+func (pl *PublishingLicense) TemplateID() string {
+	_, value := pl.authenticatedData(func(name string) bool {
+		return strings.EqualFold(name, "TemplateID")
+	})
+	return strings.Trim(value, "{}")
+}
+
+// Sensitivity label AUTHENTICATEDDATA is named MSIP_Label_<id>_Enabled
+const (
+	labelPrefix = "MSIP_Label_"
+	labelSuffix = "_Enabled"
+)
+
+// LabelID returns the ID of the sensitivity label applied to the content (if any)
+func (pl *PublishingLicense) LabelID() string {
+	name, _ := pl.authenticatedData(func(name string) bool {
+		return strings.HasPrefix(name, labelPrefix) && strings.HasSuffix(name, labelSuffix)
+	})
+	return strings.TrimSuffix(strings.TrimPrefix(name, labelPrefix), labelSuffix)
+}
+
+// CipherMode returns the cipher mode of the content key if the license declares it, it is usually
+// only known once the key has been issued
+func (pl *PublishingLicense) CipherMode() string {
+	_, value := pl.authenticatedData(func(name string) bool {
+		return strings.EqualFold(name, "CipherMode")
+	})
+	return value
+}
+
+// decodeUTF16 converts little-endian UTF-16 to UTF-8
+func decodeUTF16(b []byte) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return []byte(string(utf16.Decode(u)))
+}
+
+// Parse parses a publishing license, b may have a binary prefix before the XML (ex: a Primary stream)
+func Parse(b []byte) (*PublishingLicense, error) {
+	// Find the start of the XML, sometimes it's UTF-16
+	if idx := bytes.Index(b, []byte("<?xml")); idx != -1 {
+		b = b[idx:]
+	} else if idx := bytes.Index(b, []byte("<\x00?\x00x\x00m\x00l\x00")); idx != -1 {
+		b = decodeUTF16(b[idx:])
+	} else {
+		return nil, errors.New("license does not have xml prefix")
+	}
+	// There is often NUL padding after the XML
+	b = bytes.TrimRight(b, "\x00\r\n\t ")
+
+	var pl PublishingLicense
+	d := xml.NewDecoder(bytes.NewReader(b))
+	// The input is always UTF-8 by now, even if it declares otherwise
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read XML")
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "XrML" {
+			if err := d.Skip(); err != nil {
+				return nil, errors.Wrapf(err, "failed to skip %s", start.Name.Local)
+			}
+			continue
+		}
+		var cert Certificate
+		if err := d.DecodeElement(&cert, &start); err != nil {
+			return nil, errors.Wrap(err, "failed to decode XrML")
+		}
+		pl.Certificates = append(pl.Certificates, &cert)
+	}
+	if len(pl.Certificates) == 0 {
+		return nil, errors.New("license does not contain any XrML")
+	}
+	return &pl, nil
+}
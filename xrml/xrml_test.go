@@ -0,0 +1,78 @@
+package xrml
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"unicode/utf16"
+)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile("testdata/publishing_license.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// encodeUTF16 converts s to little-endian UTF-16
+func encodeUTF16(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	fixture := readFixture(t)
+	inputs := map[string][]byte{
+		"plain": fixture,
+		// Primary streams have a binary prefix and NUL padding
+		"prefixed": append(append([]byte("\x2c\x00\x00\x00\x01\x00"), fixture...), 0, 0, 0, 0),
+		"utf16":    append(append([]byte{0x10, 0}, encodeUTF16(string(fixture))...), 0, 0),
+	}
+	for name, b := range inputs {
+		pl, err := Parse(b)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", name, err)
+		}
+		if len(pl.Certificates) != 2 {
+			t.Fatalf("%s: got %d certificates, want 2", name, len(pl.Certificates))
+		}
+		if typ := pl.License().Body.Type; typ != RightsLabelType {
+			t.Errorf("%s: License() is a %q", name, typ)
+		}
+		for field, got := range map[string][2]string{
+			"ContentID":   {pl.ContentID(), "{8f3c1a2b-5d6e-4f70-8192-a3b4c5d6e7f8}"},
+			"Owner":       {pl.Owner(), "alice@contoso.com"},
+			"IntranetURL": {pl.IntranetURL(), "https://contoso.rms.na.aadrm.com/_wmcs/licensing"},
+			"ExtranetURL": {pl.ExtranetURL(), "https://extranet.contoso.com/_wmcs/licensing"},
+			"Issuer":      {pl.Issuer().URL(), "https://contoso.rms.na.aadrm.com/_wmcs/licensing"},
+			"TemplateID":  {pl.TemplateID(), "a1b2c3d4-e5f6-4071-8293-a4b5c6d7e8f9"},
+			"LabelID":     {pl.LabelID(), "11111111-2222-3333-4444-555555555555"},
+			"CipherMode":  {pl.CipherMode(), ""},
+		} {
+			if got[0] != got[1] {
+				t.Errorf("%s: %s is %q, want %q", name, field, got[0], got[1])
+			}
+		}
+		if pl.License().Body.EncryptedRightsData == nil {
+			t.Errorf("%s: missing ENCRYPTEDRIGHTSDATA", name)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"empty":     nil,
+		"no xml":    []byte("\x00\x01binary"),
+		"no XrML":   []byte(`<?xml version="1.0"?><OTHER></OTHER>`),
+		"malformed": []byte(`<?xml version="1.0"?><XrML><BODY>`),
+	} {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}